@@ -18,6 +18,9 @@ type RuntimeConfig struct {
 	AdminList     []string `envconfig:"ADMIN_LIST"`
 	OpenAIBaseURL string   `envconfig:"OPENAI_BASE_URL" default:"https://api.openai.com/v1"`
 
+	// the key of the hashes of the open tokens, JWT_TOKEN_KEY if empty. changing it revokes all the tokens
+	TokenHashKey []byte `envconfig:"TOKEN_HASH_KEY"`
+
 	SSOGoogleClientID     string `envconfig:"SSO_GOOGLE_CLIENT_ID"`
 	SSOGoogleClientSecret string `envconfig:"SSO_GOOGLE_CLIENT_SECRET"`
 	SSOGoogleCallbackURL  string `envconfig:"SSO_GOOGLE_CALLBACK_URL"`
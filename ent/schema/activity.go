@@ -3,6 +3,7 @@ package schema
 import (
 	"entgo.io/ent"
 	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
 	"entgo.io/ent/schema/mixin"
 )
 
@@ -10,9 +11,33 @@ type Activity struct {
 	ent.Schema
 }
 
+// PromptLabelChange is a move of a label of a prompt
+type PromptLabelChange struct {
+	Label string `json:"label"`
+	// 0 if the label is created
+	PreviousVersion int `json:"previousVersion"`
+	// 0 if the label is removed
+	Version int `json:"version"`
+}
+
+// PromptRestore is a restore of a previous version of a prompt
+type PromptRestore struct {
+	HistoryID int `json:"historyId"`
+	// the version which is restored
+	FromVersion int `json:"fromVersion"`
+	// the version the restore created
+	Version int    `json:"version"`
+	Reason  string `json:"reason"`
+}
+
 // Fields of the Activity.
 func (Activity) Fields() []ent.Field {
-	return nil
+	return []ent.Field{
+		field.String("event"),
+		field.Int("promptId").Optional().Nillable(),
+		field.JSON("labelChange", &PromptLabelChange{}).Optional(),
+		field.JSON("restore", &PromptRestore{}).Optional(),
+	}
 }
 
 // Edges of the Activity.
@@ -0,0 +1,49 @@
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/mixin"
+)
+
+// Conversation holds the turns of a multi-turn chat, they are sent after the rows of the prompt.
+type Conversation struct {
+	ent.Schema
+}
+
+// Fields of the Conversation.
+func (Conversation) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("userId").Default(""),
+		field.JSON("messages", []PromptRow{}),
+		// keep the last n messages, 0 keeps all
+		field.Int("maxMessages").Default(0),
+		// drop the oldest messages above the token budget, 0 for no budget
+		field.Int("maxTokens").Default(0),
+		// seconds the conversation lives after its last turn
+		field.Int("ttl").Default(86400),
+		field.Time("expireAt"),
+		// incremented by every run, the turns of concurrent runs are appended one after another
+		field.Int("version").Default(0),
+		field.Int("projectId").StorageKey("project_conversations"),
+	}
+}
+
+// Edges of the Conversation.
+func (Conversation) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.
+			From("project", Project.Type).
+			Ref("conversations").
+			Unique().
+			Field("projectId").
+			Required(),
+	}
+}
+
+func (Conversation) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Time{},
+	}
+}
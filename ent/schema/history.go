@@ -4,6 +4,7 @@ import (
 	"entgo.io/ent"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
 	"entgo.io/ent/schema/mixin"
 )
 
@@ -22,6 +23,16 @@ type PromptComplete struct {
 	Variables   []PromptVariable
 	PublicLevel string
 	Version     int
+
+	ModelParameters *ModelParameters
+	OutputSchema    *OutputSchema
+	Tools           []PromptTool
+	ToolChoice      string
+
+	// 0 in the snapshots taken before the provider was recorded
+	ProviderId          int
+	FallbackProviderIds []int
+	FallbackRules       *FallbackRules
 }
 
 // Fields of the History.
@@ -30,6 +41,8 @@ func (History) Fields() []ent.Field {
 		field.Int("modifierId"),
 		field.Int("promptId"),
 		field.JSON("snapshot", PromptComplete{}),
+		// the version of the prompt the snapshot is, it never changes once written
+		field.Int("version").Default(0),
 	}
 }
 
@@ -51,6 +64,12 @@ func (History) Edges() []ent.Edge {
 	}
 }
 
+func (History) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("promptId", "version"),
+	}
+}
+
 func (History) Mixin() []ent.Mixin {
 	return []ent.Mixin{
 		mixin.Time{},
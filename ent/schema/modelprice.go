@@ -0,0 +1,52 @@
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+	"entgo.io/ent/schema/mixin"
+)
+
+// ModelPrice is the price of a model from a date on. prices without a provider
+// apply to every provider, the ones of a provider override them.
+type ModelPrice struct {
+	ent.Schema
+}
+
+// Fields of the ModelPrice.
+func (ModelPrice) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("model").NotEmpty(),
+		field.Time("startFrom"),
+		field.Float("inputTokenCostInCents").Default(0),
+		field.Float("outputTokenCostInCents").Default(0),
+		// the input tokens read from the prompt cache, the input price if not set
+		field.Float("cachedInputTokenCostInCents").Optional().Nillable(),
+		// the reasoning tokens of the reply, the output price if not set
+		field.Float("reasoningTokenCostInCents").Optional().Nillable(),
+		field.Int("providerId").Optional().Nillable().StorageKey("provider_model_prices"),
+	}
+}
+
+// Edges of the ModelPrice.
+func (ModelPrice) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.From("provider", Provider.Type).
+			Ref("modelPrices").
+			Unique().
+			Field("providerId"),
+	}
+}
+
+func (ModelPrice) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("model", "startFrom"),
+	}
+}
+
+func (ModelPrice) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Time{},
+	}
+}
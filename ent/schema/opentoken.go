@@ -4,6 +4,7 @@ import (
 	"entgo.io/ent"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
 	"entgo.io/ent/schema/mixin"
 )
 
@@ -17,10 +18,26 @@ func (OpenToken) Fields() []ent.Field {
 	return []ent.Field{
 		field.String("name"),
 		field.String("description").Default(""),
-		field.String("token").Sensitive(),
+		// the legacy plaintext secret, emptied once it is hashed by MigrateOpenTokens
+		field.String("token").Default("").Sensitive(),
+		// the public part of the token to look it up, the secret is only stored as a keyed hash
+		field.String("prefix").Default(""),
+		field.String("tokenHash").Default("").Sensitive(),
 		field.Bool("apiValidateEnabled").Default(false),
 		field.String("apiValidatePath").Default("/api/v1/validate"),
 		field.Time("expireAt"),
+		// the token before the last rotation, it stays valid until previousTokenExpireAt
+		field.String("previousPrefix").Optional().Nillable(),
+		field.String("previousTokenHash").Optional().Nillable().Sensitive(),
+		field.Time("previousTokenExpireAt").Optional().Nillable(),
+		field.Time("lastUsedAt").Optional().Nillable(),
+		field.String("lastUsedIp").Optional().Nillable(),
+		// limits of the token in sliding windows, 0 means unlimited
+		field.Int("requestsPerMinute").Default(0),
+		field.Int("tokensPerDay").Default(0),
+		field.Float("costCentsPerDay").Default(0),
+		// the label of the prompts the runs resolve, the latest versions if empty
+		field.String("label").Default(""),
 		field.Int("project_open_tokens").Optional(),
 	}
 }
@@ -37,6 +54,13 @@ func (OpenToken) Edges() []ent.Edge {
 	}
 }
 
+func (OpenToken) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("prefix"),
+		index.Fields("previousPrefix"),
+	}
+}
+
 func (OpenToken) Mixin() []ent.Mixin {
 	return []ent.Mixin{
 		mixin.Time{},
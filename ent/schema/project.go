@@ -1,12 +1,26 @@
 package schema
 
 import (
+	"time"
+
 	"entgo.io/ent"
 	"entgo.io/ent/schema/edge"
 	"entgo.io/ent/schema/field"
 	"entgo.io/ent/schema/mixin"
 )
 
+// ProjectBudget limits the spend of a project in a period, summed from the cost of its calls
+type ProjectBudget struct {
+	// cents, 0 disables the limit. reaching the soft limit fires a webhook event,
+	// the runs are rejected once the hard limit is reached
+	SoftLimitCents float64 `json:"softLimitCents"`
+	HardLimitCents float64 `json:"hardLimitCents"`
+	// monthly: calendar months in UTC. custom: periods of PeriodDays from StartAt
+	Period     string    `json:"period"`
+	PeriodDays int       `json:"periodDays,omitempty"`
+	StartAt    time.Time `json:"startAt,omitempty"`
+}
+
 // Project holds the schema definition for the Project entity.
 type Project struct {
 	ent.Schema
@@ -34,6 +48,10 @@ func (Project) Fields() []ent.Field {
 		field.Float("openAITopP").Default(0.9),
 		field.Int("openAIMaxTokens").Default(0),
 		field.Int("providerId").Optional().Nillable().StorageKey("project_provider"),
+		// ordered provider ids to try when the provider fails
+		field.JSON("fallbackProviderIds", []int{}).Optional(),
+		field.JSON("fallbackRules", &FallbackRules{}).Optional(),
+		field.JSON("budget", &ProjectBudget{}).Optional(),
 	}
 }
 
@@ -54,6 +72,7 @@ func (Project) Edges() []ent.Edge {
 			Field("providerId"),
 		edge.To("userProjectRoles", UserProjectRole.Type),
 		edge.To("webhooks", Webhook.Type),
+		edge.To("conversations", Conversation.Type),
 	}
 }
 
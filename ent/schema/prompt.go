@@ -15,6 +15,29 @@ type Prompt struct {
 type PromptRow struct {
 	Prompt string `json:"prompt"`
 	Role   string `json:"role"`
+	// the tools the model asked to call, assistant rows only
+	ToolCalls []ToolCall `json:"toolCalls,omitempty"`
+	// the call the row is the result of, tool rows only
+	ToolCallID string `json:"toolCallId,omitempty"`
+	// the row is appended to the prompt for a run, e.g. a message or the history
+	// of a conversation. it is not a template, only its placeholders are replaced
+	Appended bool `json:"-"`
+}
+
+// PromptTool is a function the model may ask to call
+type PromptTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// JSON Schema of the arguments
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// ToolCall is a call of a PromptTool requested by the model
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// JSON encoded arguments
+	Arguments string `json:"arguments"`
 }
 
 type PromptVariableTypes string
@@ -34,6 +57,42 @@ type PromptVariable struct {
 	Type PromptVariableTypes `json:"type"`
 }
 
+// FallbackRules decides when a prompt run moves on to the next fallback provider
+type FallbackRules struct {
+	// error classes that trigger a fallback: 5xx, rateLimit, timeout and network.
+	// all of them if empty
+	On []string `json:"on"`
+	// timeout of each provider attempt in milliseconds, until the first token for streams
+	AttemptTimeoutMs int `json:"attemptTimeoutMs"`
+	// no more fallback attempt is started once the run takes longer than it
+	BudgetMs int `json:"budgetMs"`
+}
+
+// ModelParameters override the generation parameters of the provider.
+// unset fields keep the provider's defaults.
+type ModelParameters struct {
+	// only applied on the provider of the prompt, fallback providers keep their own
+	Model            string   `json:"model,omitempty"`
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	MaxTokens        *int     `json:"maxTokens,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+}
+
+// OutputSchema is the JSON Schema the reply of a prompt must match
+type OutputSchema struct {
+	// name of the schema sent to the provider, `output` if empty
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+	// ask the provider to follow the schema exactly, if it supports it
+	Strict bool `json:"strict"`
+	// run the prompt once more when the reply does not match the schema
+	RetryOnInvalid bool `json:"retryOnInvalid"`
+}
+
 // Fields of the Prompt.
 func (Prompt) Fields() []ent.Field {
 	return []ent.Field{
@@ -44,10 +103,19 @@ func (Prompt) Fields() []ent.Field {
 		field.Bool("cacheEnabled").Default(true),
 		field.JSON("prompts", []PromptRow{}),
 		field.Int("tokenCount").Default(0),
+		// bumped on every update, the previous versions are kept in the histories
 		field.Int("version").Default(0),
 		field.JSON("variables", []PromptVariable{}),
 		field.Int("projectId").StorageKey("project_prompts"),
 		field.Int("providerId").Optional().StorageKey("provider_prompts"),
+		// ordered provider ids to try when the provider fails. fallback to the project's if empty
+		field.JSON("fallbackProviderIds", []int{}).Optional(),
+		field.JSON("fallbackRules", &FallbackRules{}).Optional(),
+		field.JSON("modelParameters", &ModelParameters{}).Optional(),
+		field.JSON("outputSchema", &OutputSchema{}).Optional(),
+		field.JSON("tools", []PromptTool{}).Optional(),
+		// auto, none, required or the name of a tool. auto if empty
+		field.String("toolChoice").Default(""),
 		field.Enum("publicLevel").
 			Values("public", "protected", "private").
 			Default("protected"),
@@ -77,6 +145,7 @@ func (Prompt) Edges() []ent.Edge {
 			Field("providerId"),
 		edge.To("calls", PromptCall.Type),
 		edge.To("histories", History.Type),
+		edge.To("labels", PromptLabel.Type),
 	}
 }
 
@@ -17,11 +17,12 @@ func (PromptCall) Fields() []ent.Field {
 	return []ent.Field{
 		field.Int("promptId").StorageKey("prompt_calls"),
 		field.String("userId").Optional(),
+		field.Int("promptToken").Default(0),
 		field.Int("responseToken"),
 		field.Int("totalToken"),
 		// how long the prompt executed.
 		field.Int64("duration"),
-		// 0: success, 1: fail
+		// 0: success, 1: fail, 2: the reply does not match the output schema of the prompt
 		field.Int("result"),
 		field.Bool("cached").Default(false),
 		field.JSON("payload", map[string]string{}).Optional(),
@@ -32,6 +33,10 @@ func (PromptCall) Fields() []ent.Field {
 		field.String("message").Optional().Nillable(),
 		// provider information
 		field.Int("providerId").Optional().Nillable().StorageKey("prompt_call_provider"),
+		// the model which answered, as reported by the provider
+		field.String("model").Default(""),
+		// the version of the prompt which ran
+		field.Int("promptVersion").Default(0),
 	}
 }
 
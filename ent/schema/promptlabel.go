@@ -0,0 +1,55 @@
+package schema
+
+import (
+	"entgo.io/ent"
+	"entgo.io/ent/schema/edge"
+	"entgo.io/ent/schema/field"
+	"entgo.io/ent/schema/index"
+	"entgo.io/ent/schema/mixin"
+)
+
+// PromptLabel points a name of the prompt, e.g. production, at one of its versions
+type PromptLabel struct {
+	ent.Schema
+}
+
+// Fields of the PromptLabel.
+func (PromptLabel) Fields() []ent.Field {
+	return []ent.Field{
+		field.Int("promptId"),
+		field.String("name"),
+		field.Int("version"),
+		// the last user who moved the label
+		field.Int("modifierId"),
+	}
+}
+
+// Edges of the PromptLabel.
+func (PromptLabel) Edges() []ent.Edge {
+	return []ent.Edge{
+		edge.
+			From("prompt", Prompt.Type).
+			Ref("labels").
+			Unique().
+			Required().
+			Field("promptId"),
+		edge.
+			From("modifier", User.Type).
+			Ref("promptLabels").
+			Unique().
+			Required().
+			Field("modifierId"),
+	}
+}
+
+func (PromptLabel) Indexes() []ent.Index {
+	return []ent.Index{
+		index.Fields("promptId", "name").Unique(),
+	}
+}
+
+func (PromptLabel) Mixin() []ent.Mixin {
+	return []ent.Mixin{
+		mixin.Time{},
+	}
+}
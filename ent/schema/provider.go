@@ -19,7 +19,7 @@ func (Provider) Fields() []ent.Field {
 		field.String("description").Default(""),
 		field.Bool("enabled").Default(true),
 
-		// Source type of the provider (openai, gemini, claude, deepseek, etc.)
+		// Source type of the provider (openai, azure-openai, gemini, claude, ollama, deepseek, etc.)
 		field.String("source").NotEmpty(),
 
 		// Base endpoint URL for API calls
@@ -68,6 +68,9 @@ func (Provider) Edges() []ent.Edge {
 
 		// A provider can have many webhook calls
 		edge.To("webhookCalls", WebhookCall.Type),
+
+		// A provider can override the prices of the models
+		edge.To("modelPrices", ModelPrice.Type),
 	}
 }
 
@@ -36,6 +36,7 @@ func (User) Edges() []ent.Edge {
 		edge.To("prompts", Prompt.Type),
 		edge.To("activities", Activity.Type),
 		edge.To("histories", History.Type),
+		edge.To("promptLabels", PromptLabel.Type),
 		edge.To("openTokens", OpenToken.Type),
 		edge.To("providers", Provider.Type),
 		edge.To("userProjectRoles", UserProjectRole.Type),
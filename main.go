@@ -39,6 +39,7 @@ func startHTTPServer() {
 	)
 
 	schema.Setup(hi, w3, rbac)
+	routes.InitRBACMiddleware(service.EntClient)
 	h := routes.SetupGinRoutes(GitCommit, w3, iai, hi, graphqlSchema)
 	server := &http.Server{
 		Addr:    publicDomain,
@@ -41,6 +41,7 @@ func SetupGinRoutes(
 	graphqlSchema *graphql.Schema,
 ) *gin.Engine {
 	versionCommit = commitSha
+	service.SetWebhookVersion(commitSha)
 	web3Service = w3
 	isomorphicAIService = iai
 	hashidService = hi
@@ -134,6 +135,9 @@ func SetupGinRoutes(
 			promptCacheMiddleware,
 			apiRunPromptStream,
 		)
+		apiRoutes.POST("/conversations", brHandler, apiCreateConversation)
+		apiRoutes.GET("/conversations/:cid", brHandler, apiGetConversation)
+		apiRoutes.DELETE("/conversations/:cid", brHandler, apiDeleteConversation)
 	}
 
 	// !!! IMPORTANT !!!
@@ -1,15 +1,16 @@
 package routes
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PromptPal/PromptPal/ent"
-	"github.com/PromptPal/PromptPal/ent/opentoken"
 	"github.com/PromptPal/PromptPal/service"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/cache/v9"
@@ -54,10 +55,11 @@ func apiMiddleware(c *gin.Context) {
 
 	ctx := c.Request.Context()
 
-	tk := authKey[1]
+	// the token is only kept as its hash, in the database and in the cache
+	tokenHash := service.HashOpenToken(authKey[1])
 	pid := 0
 	var ot ent.OpenToken
-	err := service.Cache.Get(ctx, fmt.Sprintf("openToken:%s", tk), &ot)
+	err := service.Cache.Get(ctx, fmt.Sprintf("openToken:%s", tokenHash), &ot)
 	if err != nil {
 		if !errors.Is(err, cache.ErrCacheMiss) {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{
@@ -67,12 +69,7 @@ func apiMiddleware(c *gin.Context) {
 			return
 		}
 		err = nil
-		dot, err := service.
-			EntClient.
-			OpenToken.
-			Query().
-			Where(opentoken.Token(tk)).
-			Only(ctx)
+		dot, err := service.FindOpenToken(ctx, authKey[1], time.Now())
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusForbidden, errorResponse{
 				ErrorCode:    http.StatusForbidden,
@@ -92,13 +89,25 @@ func apiMiddleware(c *gin.Context) {
 			return
 		}
 		pid = pj.ID
-		service.Cache.Set(&cache.Item{
-			Ctx:   ctx,
-			Key:   fmt.Sprintf("openToken:%s", tk),
-			Value: ot,
-			TTL:   time.Hour,
+		if ttl := service.OpenTokenCacheTTL(ot, tokenHash, time.Now()); ttl > 0 {
+			service.Cache.Set(&cache.Item{
+				Ctx:   ctx,
+				Key:   fmt.Sprintf("openToken:%s", tokenHash),
+				Value: ot,
+				TTL:   ttl,
+			})
+		}
+	}
+
+	now := time.Now()
+	if err := service.CheckOpenTokenExpiry(ot, tokenHash, now); err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{
+			ErrorCode:    http.StatusUnauthorized,
+			ErrorMessage: err.Error(),
 		})
+		return
 	}
+	go service.TouchOpenToken(context.Background(), ot.ID, c.ClientIP(), now)
 
 	if pid == 0 {
 		pid = ot.ProjectOpenTokens
@@ -106,9 +115,35 @@ func apiMiddleware(c *gin.Context) {
 
 	c.Set("openToken", ot)
 	c.Set("pid", pid)
+
+	if status := service.CheckOpenTokenRateLimits(ctx, ot, now); status != nil {
+		setRateLimitHeaders(c, *status)
+		if !status.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorResponse{
+				ErrorCode:    http.StatusTooManyRequests,
+				ErrorMessage: status.Error().Error(),
+			})
+			return
+		}
+	}
 	c.Next()
 }
 
+// setRateLimitHeaders sets the `RateLimit-*` headers of the limit closest to exhaustion
+func setRateLimitHeaders(c *gin.Context, status service.RateLimitStatus) {
+	c.Header("RateLimit-Limit", strconv.FormatFloat(status.Limit, 'f', -1, 64))
+	c.Header("RateLimit-Remaining", strconv.FormatFloat(math.Floor(status.Remaining()), 'f', -1, 64))
+	c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+}
+
+// requestOpenToken returns the open token the request is authorized by
+func requestOpenToken(c *gin.Context) ent.OpenToken {
+	ot, _ := c.Get("openToken")
+	token, _ := ot.(ent.OpenToken)
+	return token
+}
+
 // rbacService is the RBAC service instance
 var rbacService service.RBACService
 
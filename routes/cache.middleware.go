@@ -13,7 +13,6 @@ import (
 )
 
 func promptCacheMiddleware(c *gin.Context) {
-	hashedValue, _ := c.Params.Get("id")
 	promptData, _ := c.Get("prompt")
 	payloadData, _ := c.Get("payload")
 	pjData, _ := c.Get("pj")
@@ -22,13 +21,14 @@ func promptCacheMiddleware(c *gin.Context) {
 	payload := payloadData.(apiRunPromptPayload)
 	pj := pjData.(ent.Project)
 
-	if !prompt.CacheEnabled {
+	// the cache key only covers the variables, not the messages
+	if !prompt.CacheEnabled || !payload.cacheable() {
 		c.Next()
 		return
 	}
 
 	startTime := time.Now()
-	result, ok, err := service.GetPromptResponseCache(hashedValue, payload.Variables)
+	result, ok, err := service.GetPromptResponseCache(c.GetString("promptCacheID"), payload.Variables)
 
 	if err != nil {
 		logrus.Warnln("promptCache", err)
@@ -48,6 +48,9 @@ func promptCacheMiddleware(c *gin.Context) {
 			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: result.ResponseMessage}}},
 		},
 		pj,
+		requestOpenToken(c),
+		// no provider answered, the call is recorded on the provider of the prompt
+		nil,
 		payload,
 		endTime,
 		startTime,
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+type apiCreateConversationPayload struct {
+	UserId      string `json:"userId"`
+	MaxMessages int    `json:"maxMessages" binding:"gte=0"`
+	MaxTokens   int    `json:"maxTokens" binding:"gte=0"`
+	// seconds, defaults to a day
+	TTL int `json:"ttl" binding:"gte=0,lte=2592000"`
+}
+
+type publicConversationItem struct {
+	HashID      string             `json:"id"`
+	UserId      string             `json:"userId"`
+	Messages    []schema.PromptRow `json:"messages"`
+	MaxMessages int                `json:"maxMessages"`
+	MaxTokens   int                `json:"maxTokens"`
+	TTL         int                `json:"ttl"`
+	ExpireAt    time.Time          `json:"expireAt"`
+	CreatedAt   time.Time          `json:"createdAt"`
+}
+
+func newPublicConversationItem(conv *ent.Conversation) (publicConversationItem, error) {
+	hid, err := hashidService.Encode(conv.ID)
+	if err != nil {
+		return publicConversationItem{}, err
+	}
+	return publicConversationItem{
+		HashID:      hid,
+		UserId:      conv.UserId,
+		Messages:    conv.Messages,
+		MaxMessages: conv.MaxMessages,
+		MaxTokens:   conv.MaxTokens,
+		TTL:         conv.TTL,
+		ExpireAt:    conv.ExpireAt,
+		CreatedAt:   conv.CreateTime,
+	}, nil
+}
+
+// getProjectConversation loads a living conversation of the project of the token
+func getProjectConversation(c *gin.Context, hashedValue string) (*ent.Conversation, int, error) {
+	cid, err := hashidService.Decode(hashedValue)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	conv, err := service.EntClient.Conversation.Get(c, cid)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, http.StatusNotFound, service.ErrorConversationNotFound
+		}
+		return nil, http.StatusInternalServerError, err
+	}
+	if conv.ProjectId != c.GetInt("pid") || service.IsConversationExpired(conv) {
+		return nil, http.StatusNotFound, service.ErrorConversationNotFound
+	}
+	return conv, http.StatusOK, nil
+}
+
+func apiCreateConversation(c *gin.Context) {
+	var payload apiCreateConversationPayload
+	if err := c.Bind(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	// the expired ones are cleaned on the way
+	if _, err := service.DeleteExpiredConversations(c); err != nil {
+		logrus.Warnln("conversations", err)
+	}
+
+	stat := service.EntClient.Conversation.
+		Create().
+		SetProjectId(c.GetInt("pid")).
+		SetUserId(payload.UserId).
+		SetMessages([]schema.PromptRow{}).
+		SetMaxMessages(payload.MaxMessages).
+		SetMaxTokens(payload.MaxTokens)
+	ttl := 24 * time.Hour
+	if payload.TTL > 0 {
+		ttl = time.Duration(payload.TTL) * time.Second
+		stat.SetTTL(payload.TTL)
+	}
+	conv, err := stat.SetExpireAt(time.Now().Add(ttl)).Save(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{
+			ErrorCode:    http.StatusInternalServerError,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	result, err := newPublicConversationItem(conv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{
+			ErrorCode:    http.StatusInternalServerError,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func apiGetConversation(c *gin.Context) {
+	conv, status, err := getProjectConversation(c, c.Param("cid"))
+	if err != nil {
+		c.JSON(status, errorResponse{
+			ErrorCode:    status,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	result, err := newPublicConversationItem(conv)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{
+			ErrorCode:    http.StatusInternalServerError,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func apiDeleteConversation(c *gin.Context) {
+	conv, status, err := getProjectConversation(c, c.Param("cid"))
+	if err != nil {
+		c.JSON(status, errorResponse{
+			ErrorCode:    status,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if err := service.EntClient.Conversation.DeleteOneID(conv.ID).Exec(c); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{
+			ErrorCode:    http.StatusInternalServerError,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// saveConversationTurns stores the messages of the run and the reply in the conversation of the run
+func saveConversationTurns(c *gin.Context, payload apiRunPromptPayload, reply string, toolCalls []schema.ToolCall) {
+	convData, ok := c.Get("conversation")
+	if !ok {
+		return
+	}
+	turns := append([]schema.PromptRow{}, payload.Messages...)
+	turns = append(turns, schema.PromptRow{
+		Role:      openai.ChatMessageRoleAssistant,
+		Prompt:    reply,
+		ToolCalls: toolCalls,
+	})
+	provider, _ := c.Get("conversationProvider")
+	if err := service.AppendConversationTurns(c.Request.Context(), convData.(*ent.Conversation), turns, provider.(*ent.Provider), c.GetString("conversationModel")); err != nil {
+		logrus.Errorln("conversation", err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/PromptPal/PromptPal/ent"
@@ -101,10 +102,91 @@ func apiListPrompts(c *gin.Context) {
 type apiRunPromptPayload struct {
 	Variables map[string]string `json:"variables" binding:"required"`
 	UserId    string            `json:"userId"`
+	// messages appended to the prompt, e.g. the tool calls of the
+	// previous reply and their results
+	Messages []schema.PromptRow `json:"messages"`
+	// the history of the conversation is sent after the rows of the prompt,
+	// the messages and the reply are appended to it
+	ConversationID string `json:"conversationId"`
+	// the label of the prompt to run, the one of the token if empty
+	Label string `json:"label"`
+}
+
+// cacheable tells if the reply only depends on the variables
+func (p apiRunPromptPayload) cacheable() bool {
+	return len(p.Messages) == 0 && p.ConversationID == ""
+}
+
+// appendPromptMessages appends the messages of the request to the prompt.
+// the rows of the prompt are copied, the prompt may come from the cache.
+func appendPromptMessages(prompt ent.Prompt, messages []schema.PromptRow) (ent.Prompt, error) {
+	if len(messages) == 0 {
+		return prompt, nil
+	}
+	for i, msg := range messages {
+		switch msg.Role {
+		case openai.ChatMessageRoleUser, openai.ChatMessageRoleAssistant:
+		case openai.ChatMessageRoleTool:
+			if msg.ToolCallID == "" {
+				return prompt, fmt.Errorf("messages[%d]: toolCallId is required for tool messages", i)
+			}
+		default:
+			return prompt, fmt.Errorf("messages[%d]: invalid role %s", i, msg.Role)
+		}
+	}
+	prompt.Prompts = append(slices.Clone(prompt.Prompts), appendedRows(messages)...)
+	return prompt, nil
+}
+
+// appendedRows copies the rows appended to the prompt for a run. they are
+// not templates, only their placeholders are replaced.
+func appendedRows(rows []schema.PromptRow) []schema.PromptRow {
+	result := slices.Clone(rows)
+	for i := range result {
+		result[i].Appended = true
+	}
+	return result
+}
+
+// getLabelPrompt returns the version of the prompt the label points at.
+// it is cached by the label, moving the label evicts it.
+func getLabelPrompt(c *gin.Context, hashedValue string, latest ent.Prompt, label string) (ent.Prompt, int, error) {
+	if err := service.ValidatePromptLabel(label); err != nil {
+		return latest, http.StatusBadRequest, err
+	}
+
+	key := service.PromptCacheKey(hashedValue, label)
+	var prompt ent.Prompt
+	err := service.Cache.Get(c.Request.Context(), key, &prompt)
+	if err == nil {
+		return prompt, http.StatusOK, nil
+	}
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		return latest, http.StatusInternalServerError, err
+	}
+
+	version, err := service.ResolvePromptLabel(c.Request.Context(), latest.ID, label)
+	if err != nil {
+		if errors.Is(err, service.ErrorPromptLabelNotFound) {
+			return latest, http.StatusNotFound, err
+		}
+		return latest, http.StatusInternalServerError, err
+	}
+	prompt, err = service.GetPromptVersion(c.Request.Context(), latest, version)
+	if err != nil {
+		return latest, http.StatusInternalServerError, err
+	}
+	service.Cache.Set(&cache.Item{
+		Ctx:   c.Request.Context(),
+		Key:   key,
+		Value: prompt,
+		TTL:   24 * time.Hour,
+	})
+	return prompt, http.StatusOK, nil
 }
 
 func apiRunPromptMiddleware(c *gin.Context) {
-	hashedValue, ok := c.Params.Get("id")
+	id, ok := c.Params.Get("id")
 
 	if !ok {
 		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
@@ -114,9 +196,19 @@ func apiRunPromptMiddleware(c *gin.Context) {
 		return
 	}
 
+	// `<id>@v3` or `?version=3` pins the version, the latest one runs otherwise
+	hashedValue, version, err := service.ParsePromptVersion(id, c.Query("version"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
 	var prompt ent.Prompt
 
-	err := service.Cache.Get(c.Request.Context(), fmt.Sprintf("prompt:%s", hashedValue), &prompt)
+	err = service.Cache.Get(c.Request.Context(), service.PromptCacheKey(hashedValue, ""), &prompt)
 	if err != nil {
 		if !errors.Is(err, cache.ErrCacheMiss) {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{
@@ -145,23 +237,28 @@ func apiRunPromptMiddleware(c *gin.Context) {
 		}
 		service.Cache.Set(&cache.Item{
 			Ctx:   c.Request.Context(),
-			Key:   fmt.Sprintf("prompt:%s", hashedValue),
+			Key:   service.PromptCacheKey(hashedValue, ""),
 			Value: promptData,
 			TTL:   24 * time.Hour,
 		})
 		prompt = *promptData
 	}
 
-	var payload apiRunPromptPayload
-	if err := c.Bind(&payload); err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
-			ErrorCode:    http.StatusBadRequest,
+	prompt, err = service.GetPromptVersion(c.Request.Context(), prompt, version)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrorPromptVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.AbortWithStatusJSON(status, errorResponse{
+			ErrorCode:    status,
 			ErrorMessage: err.Error(),
 		})
 		return
 	}
 
-	// check the API token and prompt.projectID is equal
+	// check the API token and prompt.projectID is equal,
+	// before the labels, the partials and the conversation of the project are read
 	pid := c.GetInt("pid")
 
 	var pj ent.Project
@@ -202,6 +299,102 @@ func apiRunPromptMiddleware(c *gin.Context) {
 		return
 	}
 
+	var payload apiRunPromptPayload
+	if err := c.Bind(&payload); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	// a pinned version wins over the labels
+	if version == 0 {
+		label := payload.Label
+		if label == "" {
+			label = requestOpenToken(c).Label
+		}
+		if label != "" {
+			labelled, status, err := getLabelPrompt(c, hashedValue, prompt, label)
+			if err != nil {
+				c.AbortWithStatusJSON(status, errorResponse{
+					ErrorCode:    status,
+					ErrorMessage: err.Error(),
+				})
+				return
+			}
+			prompt = labelled
+		}
+	}
+
+	// only the rows of the prompt are templates, the messages are appended after
+	prompt, partials, err := service.ExpandPromptPartials(c.Request.Context(), prompt, payload.Variables)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var templateErr *service.TemplateError
+		if errors.As(err, &templateErr) {
+			status = http.StatusBadRequest
+		}
+		c.AbortWithStatusJSON(status, errorResponse{
+			ErrorCode:    status,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if payload.ConversationID != "" {
+		conv, status, err := getProjectConversation(c, payload.ConversationID)
+		if err != nil {
+			c.AbortWithStatusJSON(status, errorResponse{
+				ErrorCode:    status,
+				ErrorMessage: err.Error(),
+			})
+			return
+		}
+		// the history is windowed with the tokenizer of the provider of the prompt
+		provider, err := isomorphicAIService.GetProvider(c, prompt)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{
+				ErrorCode:    http.StatusInternalServerError,
+				ErrorMessage: err.Error(),
+			})
+			return
+		}
+		model := service.RequestModel(provider, prompt)
+		prompt.Prompts = append(slices.Clone(prompt.Prompts), appendedRows(service.ConversationHistory(conv, provider, model))...)
+		c.Set("conversation", conv)
+		c.Set("conversationProvider", provider)
+		c.Set("conversationModel", model)
+	}
+
+	prompt, err = appendPromptMessages(prompt, payload.Messages)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if err := service.ValidateMediaVariables(prompt.Variables, payload.Variables); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if err := service.CheckProjectBudget(c.Request.Context(), pj); err != nil {
+		c.AbortWithStatusJSON(http.StatusPaymentRequired, errorResponse{
+			ErrorCode:    http.StatusPaymentRequired,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	c.Set("promptHashID", hashedValue)
+	// the replies are cached by the versions of the prompt and of its partials
+	c.Set("promptCacheID", service.PromptPartialsVersionID(service.PromptVersionID(hashedValue, prompt.Version), partials))
 	c.Set("prompt", prompt)
 	c.Set("pj", pj)
 	c.Set("payload", payload)
@@ -209,7 +402,7 @@ func apiRunPromptMiddleware(c *gin.Context) {
 }
 
 func apiRunPrompt(c *gin.Context) {
-	hashedValue, _ := c.Params.Get("id")
+	hashedValue := c.GetString("promptHashID")
 	promptData, _ := c.Get("prompt")
 	pjData, _ := c.Get("pj")
 	payloadData, _ := c.Get("payload")
@@ -221,9 +414,9 @@ func apiRunPrompt(c *gin.Context) {
 	payload := payloadData.(apiRunPromptPayload)
 
 	startTime := time.Now()
-	responseResult := 0
+	responseResult := service.PromptCallResultSuccess
 
-	provider, err := isomorphicAIService.GetProvider(c, prompt)
+	providerChain, err := isomorphicAIService.GetProviderChain(c, prompt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, errorResponse{
 			ErrorCode:    http.StatusInternalServerError,
@@ -238,25 +431,48 @@ func apiRunPrompt(c *gin.Context) {
 		payload.UserId = serverUid
 	}
 
-	res, err := isomorphicAIService.Chat(c, provider, prompt, payload.Variables, requestUid)
+	res, provider, output, err := chatWithOutputSchema(c, providerChain, prompt, payload.Variables, requestUid)
 	endTime := time.Now()
 
-	defer savePromptCall(
-		c.Request.Context(),
-		prompt,
-		responseResult,
-		res,
-		pj,
-		payload,
-		endTime,
-		startTime,
-		c.Request.UserAgent(),
-		c.ClientIP(),
-		false,
-	)
+	// wrapped in a closure so the final result is recorded
+	defer func() {
+		savePromptCall(
+			c.Request.Context(),
+			prompt,
+			responseResult,
+			res,
+			pj,
+			requestOpenToken(c),
+			provider,
+			payload,
+			endTime,
+			startTime,
+			c.Request.UserAgent(),
+			c.ClientIP(),
+			false,
+		)
+	}()
+
+	if errors.Is(err, service.ErrorInvalidOutput) {
+		responseResult = service.PromptCallResultInvalidOutput
+		c.JSON(http.StatusUnprocessableEntity, errorResponse{
+			ErrorCode:    http.StatusUnprocessableEntity,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+
+	if errors.Is(err, service.ErrorContextWindowExceeded) {
+		responseResult = service.PromptCallResultFail
+		c.JSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
 
 	if err != nil {
-		responseResult = 1
+		responseResult = service.PromptCallResultFail
 		c.JSON(http.StatusInternalServerError, errorResponse{
 			ErrorCode:    http.StatusInternalServerError,
 			ErrorMessage: err.Error(),
@@ -276,10 +492,15 @@ func apiRunPrompt(c *gin.Context) {
 		PromptID:           hashedValue,
 		ResponseTokenCount: res.Usage.CompletionTokens,
 		ResponseMessage:    res.Choices[0].Message.Content,
+		ResponseObject:     output,
+		ToolCalls:          service.ToolCallsFromOpenAI(res.Choices[0].Message.ToolCalls),
 	}
 
-	if responseResult == 0 {
-		service.SetPromptResponseCache(hashedValue, payload.Variables, result)
+	saveConversationTurns(c, payload, result.ResponseMessage, result.ToolCalls)
+
+	// the cache key only covers the variables, not the messages
+	if responseResult == service.PromptCallResultSuccess && payload.cacheable() {
+		service.SetPromptResponseCache(c.GetString("promptCacheID"), payload.Variables, result)
 	}
 
 	c.Header("Server-Timing", fmt.Sprintf("prompt;dur=%d", endTime.Sub(startTime).Milliseconds()))
@@ -287,7 +508,7 @@ func apiRunPrompt(c *gin.Context) {
 }
 
 func apiRunPromptStream(c *gin.Context) {
-	hashedValue, _ := c.Params.Get("id")
+	hashedValue := c.GetString("promptHashID")
 	promptData, _ := c.Get("prompt")
 	pjData, _ := c.Get("pj")
 	payloadData, _ := c.Get("payload")
@@ -297,7 +518,7 @@ func apiRunPromptStream(c *gin.Context) {
 	pj := pjData.(ent.Project)
 	payload := payloadData.(apiRunPromptPayload)
 
-	provider, err := isomorphicAIService.GetProvider(c, prompt)
+	providerChain, err := isomorphicAIService.GetProviderChain(c, prompt)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{
 			ErrorCode:    http.StatusInternalServerError,
@@ -312,7 +533,15 @@ func apiRunPromptStream(c *gin.Context) {
 		payload.UserId = serverUid
 	}
 
-	replyStream, err := isomorphicAIService.ChatStream(c, provider, prompt, payload.Variables, requestUid)
+	replyStream, provider, err := isomorphicAIService.ChatStreamWithFallback(c, providerChain, prompt, payload.Variables, requestUid)
+
+	if errors.Is(err, service.ErrorContextWindowExceeded) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{
+			ErrorCode:    http.StatusBadRequest,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
 
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, errorResponse{
@@ -323,7 +552,7 @@ func apiRunPromptStream(c *gin.Context) {
 	}
 
 	startTime := time.Now()
-	responseResult := 0
+	responseResult := service.PromptCallResultSuccess
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	c.Writer.Header().Set("Cache-Control", "no-cache")
 	c.Writer.Header().Set("Connection", "keep-alive")
@@ -334,6 +563,7 @@ func apiRunPromptStream(c *gin.Context) {
 
 	var info openai.Usage
 	result := ""
+	var toolCalls []schema.ToolCall
 
 	c.Stream(func(w io.Writer) bool {
 		select {
@@ -348,9 +578,26 @@ func apiRunPromptStream(c *gin.Context) {
 			return false
 		case err := <-replyStream.Err:
 			c.SSEvent("error", err.Error())
-			responseResult = 1
+			responseResult = service.PromptCallResultFail
 			return false
 		case data := <-replyStream.Message:
+			// the tool calls are sent once they are complete, in an event of their own
+			if calls := service.ToolCallsFromOpenAI(data[0].Message.ToolCalls); len(calls) > 0 {
+				toolCalls = append(toolCalls, calls...)
+				b, err := json.Marshal(service.APIRunPromptResponse{
+					PromptID:           hashedValue,
+					ResponseTokenCount: -1,
+					ToolCalls:          calls,
+				})
+				if err != nil {
+					c.SSEvent("error", err.Error())
+					return false
+				}
+				c.SSEvent("tool_calls", string(b))
+				if data[0].Message.Content == "" {
+					return true
+				}
+			}
 			// result = append(result, data...)
 			result += data[0].Message.Content
 			chunkResponse := service.APIRunPromptResponse{
@@ -371,11 +618,28 @@ func apiRunPromptStream(c *gin.Context) {
 
 	endTime := time.Now()
 
-	if responseResult == 0 {
-		service.SetPromptResponseCache(hashedValue, payload.Variables, service.APIRunPromptResponse{
+	// the reply of a stream can only be validated once it is complete
+	var output any
+	if responseResult == service.PromptCallResultSuccess && hasOutputSchema(prompt) && len(toolCalls) == 0 {
+		output, err = service.ParsePromptOutput(prompt.OutputSchema, result)
+		if err != nil {
+			responseResult = service.PromptCallResultInvalidOutput
+			c.SSEvent("error", err.Error())
+			c.Writer.Flush()
+		}
+	}
+
+	if responseResult == service.PromptCallResultSuccess {
+		saveConversationTurns(c, payload, result, toolCalls)
+	}
+
+	if responseResult == service.PromptCallResultSuccess && payload.cacheable() {
+		service.SetPromptResponseCache(c.GetString("promptCacheID"), payload.Variables, service.APIRunPromptResponse{
 			PromptID:           hashedValue,
 			ResponseTokenCount: info.CompletionTokens,
 			ResponseMessage:    result,
+			ResponseObject:     output,
+			ToolCalls:          toolCalls,
 		})
 	}
 
@@ -388,6 +652,8 @@ func apiRunPromptStream(c *gin.Context) {
 			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: result}}},
 		},
 		pj,
+		requestOpenToken(c),
+		provider,
 		payload,
 		endTime,
 		startTime,
@@ -397,12 +663,74 @@ func apiRunPromptStream(c *gin.Context) {
 	)
 }
 
+func hasOutputSchema(prompt ent.Prompt) bool {
+	return prompt.OutputSchema != nil && len(prompt.OutputSchema.Schema) > 0
+}
+
+// chatWithOutputSchema runs the prompt and parses the reply if the prompt has an
+// output schema. an invalid reply is retried once if the schema asks for it,
+// the usage of both runs is counted.
+func chatWithOutputSchema(
+	ctx context.Context,
+	chain service.ProviderChain,
+	prompt ent.Prompt,
+	variables map[string]string,
+	userId string,
+) (res openai.ChatCompletionResponse, provider *ent.Provider, output any, err error) {
+	usage := openai.Usage{}
+	for attempt := 1; ; attempt++ {
+		res, provider, err = isomorphicAIService.ChatWithFallback(ctx, chain, prompt, variables, userId)
+		usage = addUsage(usage, res.Usage)
+		res.Usage = usage
+
+		// a reply calling tools is not the final answer yet
+		if err != nil || !hasOutputSchema(prompt) || len(res.Choices) == 0 || len(res.Choices[0].Message.ToolCalls) > 0 {
+			return
+		}
+		output, err = service.ParsePromptOutput(prompt.OutputSchema, res.Choices[0].Message.Content)
+		if err == nil || !prompt.OutputSchema.RetryOnInvalid || attempt > 1 {
+			return
+		}
+		logrus.Warnf("prompt %d: %v, retry once", prompt.ID, err)
+	}
+}
+
+// addUsage sums the usage of two runs, the details included as they are priced apart
+func addUsage(a, b openai.Usage) openai.Usage {
+	result := openai.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+	if a.PromptTokensDetails != nil || b.PromptTokensDetails != nil {
+		result.PromptTokensDetails = &openai.PromptTokensDetails{}
+		for _, d := range []*openai.PromptTokensDetails{a.PromptTokensDetails, b.PromptTokensDetails} {
+			if d != nil {
+				result.PromptTokensDetails.CachedTokens += d.CachedTokens
+				result.PromptTokensDetails.AudioTokens += d.AudioTokens
+			}
+		}
+	}
+	if a.CompletionTokensDetails != nil || b.CompletionTokensDetails != nil {
+		result.CompletionTokensDetails = &openai.CompletionTokensDetails{}
+		for _, d := range []*openai.CompletionTokensDetails{a.CompletionTokensDetails, b.CompletionTokensDetails} {
+			if d != nil {
+				result.CompletionTokensDetails.ReasoningTokens += d.ReasoningTokens
+				result.CompletionTokensDetails.AudioTokens += d.AudioTokens
+			}
+		}
+	}
+	return result
+}
+
 func savePromptCall(
 	ctx context.Context,
 	prompt ent.Prompt,
 	responseResult int,
 	res openai.ChatCompletionResponse,
 	pj ent.Project,
+	openToken ent.OpenToken,
+	provider *ent.Provider,
 	payload apiRunPromptPayload,
 	endTime, startTime time.Time,
 	ua string,
@@ -413,7 +741,9 @@ func savePromptCall(
 		PromptCall.
 		Create().
 		SetPromptID(prompt.ID).
+		SetPromptVersion(prompt.Version).
 		SetResult(responseResult).
+		SetPromptToken(res.Usage.PromptTokens).
 		SetResponseToken(res.Usage.CompletionTokens).
 		SetTotalToken(res.Usage.TotalTokens).
 		SetUserId(payload.UserId).
@@ -424,33 +754,59 @@ func savePromptCall(
 		SetUa(ua)
 		// SetUa(c.Request.UserAgent())
 
-	// Set provider information if available
-	if pj.ProviderId != nil {
-		stat.SetProviderID(*pj.ProviderId)
+	// Set the provider which answered, it may be a fallback provider.
+	// the project settings without a provider have no id
+	var providerID *int
+	switch {
+	case provider != nil && provider.ID > 0:
+		providerID = &provider.ID
+	case provider == nil && prompt.ProviderId > 0:
+		providerID = &prompt.ProviderId
+	case provider == nil:
+		providerID = pj.ProviderId
+	}
+	if providerID != nil {
+		stat.SetProviderID(*providerID)
 	}
 
+	// the model the response reports, azure and openai answer with the model snapshot
+	model := res.Model
+	if model == "" && provider != nil {
+		model = service.RequestModel(provider, prompt)
+	}
+	stat.SetModel(model)
+
 	if prompt.Debug {
-		stat.SetPayload(payload.Variables)
+		// images, audio and video are stored as references, not blobs
+		stat.SetPayload(service.MediaVariableReferences(prompt.Variables, payload.Variables))
 	}
 	if prompt.Debug && len(res.Choices) > 0 {
 		stat.SetMessage(res.Choices[0].Message.Content)
 	}
 
-	cost, err := service.GetCosts(pj.OpenAIModel, endTime)
-	if err != nil {
-		logrus.Errorln(err)
-		err = nil
-	} else {
-		inputCosts := cost.InputTokenCostInCents * float64(res.Usage.PromptTokens)
-		outputCosts := cost.OutputTokenCostInCents * float64(res.Usage.CompletionTokens)
-		stat.SetCostCents(inputCosts + outputCosts)
+	// the cached responses cost nothing
+	costCents := 0.0
+	if !isCachedResponse {
+		cost, err := service.GetCallCosts(ctx, provider, model, endTime)
+		if err != nil {
+			logrus.Errorln(err)
+		} else {
+			costCents = cost.Calculate(res.Usage)
+			stat.SetCostCents(costCents)
+		}
 	}
 
 	exp := stat.Exec(ctx)
 	if exp != nil {
 		logrus.Errorln(exp)
+	} else if service.AddProjectSpend(ctx, pj, costCents, endTime) {
+		go triggerBudgetWebhooks(context.Background(), pj, endTime)
+	}
+
+	if !isCachedResponse {
+		service.RecordOpenTokenUsage(ctx, openToken, res.Usage.TotalTokens, costCents, endTime)
 	}
 
 	// Trigger webhooks in background
-	go triggerWebhooks(context.Background(), pj, prompt, responseResult, res, payload, endTime, startTime, ua, clientIP, isCachedResponse, pj.ProviderId)
+	go triggerWebhooks(context.Background(), pj, prompt, responseResult, res, payload, endTime, startTime, ua, clientIP, isCachedResponse, providerID)
 }
@@ -259,15 +259,96 @@ func (s *promptAPITestSuite) TestAPIRunPromptMiddlewareInvalidID() {
 	assert.Equal(s.T(), http.StatusInternalServerError, w.Code)
 }
 
+func (s *promptAPITestSuite) TestAPIRunPromptMiddlewareVersion() {
+	gin.SetMode(gin.TestMode)
+
+	hashedID := "abc123"
+	s.hashid = service.NewMockHashIDService(s.T())
+	// the prompt may be cached by the previous runs
+	s.hashid.On("Decode", hashedID).Return(s.prompt.ID, nil).Maybe()
+	hashidService = s.hashid
+
+	cases := []struct {
+		target string
+		id     string
+		code   int
+	}{
+		{target: "/api/v1/prompts/abc123@vx/run", id: hashedID + "@vx", code: http.StatusBadRequest},
+		{target: "/api/v1/prompts/abc123/run?version=0", id: hashedID, code: http.StatusBadRequest},
+		{target: "/api/v1/prompts/abc123@v99/run", id: hashedID + "@v99", code: http.StatusNotFound},
+		{target: "/api/v1/prompts/abc123/run?version=99", id: hashedID, code: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", tc.target, nil)
+
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		c.Params = gin.Params{{Key: "id", Value: tc.id}}
+		c.Set("pid", s.project.ID)
+
+		apiRunPromptMiddleware(c)
+
+		assert.True(s.T(), c.IsAborted(), tc.target)
+		assert.Equal(s.T(), tc.code, w.Code, tc.target)
+	}
+}
+
+func (s *promptAPITestSuite) TestAPIRunPromptMiddlewareLegacyTemplate() {
+	gin.SetMode(gin.TestMode)
+
+	// saved before the templates, the rows are not valid templates
+	rows := []schema.PromptRow{
+		{Role: "system", Prompt: "Reply as {{name extra}}"},
+		{Role: "user", Prompt: "Hello {{name}}, {{ unclosed"},
+	}
+	legacy, err := service.EntClient.Prompt.
+		Create().
+		SetName("Legacy Prompt").
+		SetDescription("Legacy prompt description").
+		SetProjectId(s.project.ID).
+		SetProviderId(s.provider.ID).
+		SetPrompts(rows).
+		SetCreatorID(s.user.ID).
+		SetVariables([]schema.PromptVariable{{Name: "name", Type: "string"}}).
+		SetTokenCount(10).
+		Save(context.Background())
+	assert.Nil(s.T(), err)
+
+	hashedID := "legacy123"
+	s.hashid = service.NewMockHashIDService(s.T())
+	s.hashid.On("Decode", hashedID).Return(legacy.ID, nil).Once()
+	hashidService = s.hashid
+
+	payloadBytes, _ := json.Marshal(apiRunPromptPayload{
+		Variables: map[string]string{"name": "John"},
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/prompts/%s/run", hashedID), bytes.NewBuffer(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "id", Value: hashedID}}
+	c.Set("pid", s.project.ID)
+
+	apiRunPromptMiddleware(c)
+
+	assert.False(s.T(), c.IsAborted(), w.Body.String())
+	promptData, exists := c.Get("prompt")
+	assert.True(s.T(), exists)
+	assert.Equal(s.T(), rows, promptData.(ent.Prompt).Prompts)
+}
+
 func (s *promptAPITestSuite) TestAPIRunPrompt() {
 	// Set up mocks
 	hashedID := "abc123"
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
 	// Mock IsomorphicAIService calls
-	s.iai.On("GetProvider", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
+	s.iai.On("GetProviderChain", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	})).Return(s.provider, nil)
+	})).Return(service.ProviderChain{Providers: []*ent.Provider{s.provider}}, nil)
 
 	mockResponse := openai.ChatCompletionResponse{
 		Choices: []openai.ChatCompletionChoice{
@@ -283,11 +364,11 @@ func (s *promptAPITestSuite) TestAPIRunPrompt() {
 		},
 	}
 
-	s.iai.On("Chat", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(p *ent.Provider) bool {
-		return p.ID == s.provider.ID
+	s.iai.On("ChatWithFallback", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(chain service.ProviderChain) bool {
+		return chain.Providers[0].ID == s.provider.ID
 	}), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	}), map[string]string{"name": "John"}, "user123").Return(mockResponse, nil)
+	}), map[string]string{"name": "John"}, "user123").Return(mockResponse, s.provider, nil)
 
 	isomorphicAIService = s.iai
 
@@ -321,9 +402,9 @@ func (s *promptAPITestSuite) TestAPIRunPromptGetProviderError() {
 	hashedID := "abc123"
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
-	s.iai.On("GetProvider", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
+	s.iai.On("GetProviderChain", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	})).Return(nil, fmt.Errorf("provider error"))
+	})).Return(service.ProviderChain{}, fmt.Errorf("provider error"))
 
 	isomorphicAIService = s.iai
 
@@ -349,21 +430,22 @@ func (s *promptAPITestSuite) TestAPIRunPromptChatError() {
 	hashedID := "abc123"
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
-	s.iai.EXPECT().GetProvider(mock.Anything, mock.MatchedBy(func(prompt ent.Prompt) bool {
+	chain := service.ProviderChain{Providers: []*ent.Provider{s.provider}}
+	s.iai.EXPECT().GetProviderChain(mock.Anything, mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
 	})).
-		Return(s.provider, nil)
+		Return(chain, nil)
 
-	s.iai.EXPECT().Chat(
+	s.iai.EXPECT().ChatWithFallback(
 		mock.Anything,
-		s.provider,
+		chain,
 		mock.MatchedBy(func(p ent.Prompt) bool {
 			return p.ID == s.prompt.ID
 		}),
 		map[string]string{"name": "John"},
 		"user123",
 	).
-		Return(openai.ChatCompletionResponse{}, fmt.Errorf("chat error"))
+		Return(openai.ChatCompletionResponse{}, s.provider, fmt.Errorf("chat error"))
 
 	isomorphicAIService = s.iai
 
@@ -397,15 +479,15 @@ func (s *promptAPITestSuite) TestAPIRunPromptNoChoices() {
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
 
-	s.iai.On("GetProvider", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
+	s.iai.On("GetProviderChain", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	})).Return(s.provider, nil)
+	})).Return(service.ProviderChain{Providers: []*ent.Provider{s.provider}}, nil)
 
-	s.iai.On("Chat", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(p *ent.Provider) bool {
-		return p.ID == s.provider.ID
+	s.iai.On("ChatWithFallback", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(chain service.ProviderChain) bool {
+		return chain.Providers[0].ID == s.provider.ID
 	}), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	}), map[string]string{"name": "John"}, "user123").Return(mockResponse, nil)
+	}), map[string]string{"name": "John"}, "user123").Return(mockResponse, s.provider, nil)
 
 	isomorphicAIService = s.iai
 
@@ -428,6 +510,156 @@ func (s *promptAPITestSuite) TestAPIRunPromptNoChoices() {
 	assert.Equal(s.T(), http.StatusBadRequest, w.Code)
 }
 
+func (s *promptAPITestSuite) runPromptWithOutputSchema(output *schema.OutputSchema, replies ...string) *httptest.ResponseRecorder {
+	hashedID := "abc123"
+	pt := *s.prompt
+	pt.OutputSchema = output
+
+	s.iai = service.NewMockIsomorphicAIService(s.T())
+	chain := service.ProviderChain{Providers: []*ent.Provider{s.provider}}
+	s.iai.EXPECT().GetProviderChain(mock.Anything, mock.Anything).Return(chain, nil)
+	for _, reply := range replies {
+		s.iai.EXPECT().ChatWithFallback(mock.Anything, chain, mock.Anything, map[string]string{"name": "John"}, "user123").
+			Return(openai.ChatCompletionResponse{
+				Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: reply}}},
+				Usage:   openai.Usage{CompletionTokens: 5, TotalTokens: 15},
+			}, s.provider, nil).
+			Once()
+	}
+	isomorphicAIService = s.iai
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/prompts/%s/run", hashedID), nil)
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "id", Value: hashedID}}
+	c.Set("prompt", pt)
+	c.Set("pj", *s.project)
+	c.Set("payload", apiRunPromptPayload{
+		Variables: map[string]string{"name": "John"},
+		UserId:    "user123",
+	})
+
+	apiRunPrompt(c)
+	return w
+}
+
+func newTestOutputSchema(retryOnInvalid bool) *schema.OutputSchema {
+	return &schema.OutputSchema{
+		Schema: map[string]interface{}{
+			"type":     "object",
+			"required": []interface{}{"greeting"},
+			"properties": map[string]interface{}{
+				"greeting": map[string]interface{}{"type": "string"},
+			},
+		},
+		RetryOnInvalid: retryOnInvalid,
+	}
+}
+
+func (s *promptAPITestSuite) TestAPIRunPromptOutputSchema() {
+	w := s.runPromptWithOutputSchema(newTestOutputSchema(false), `{"greeting": "Hello John"}`)
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+
+	var response service.APIRunPromptResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), map[string]interface{}{"greeting": "Hello John"}, response.ResponseObject)
+}
+
+func (s *promptAPITestSuite) TestAPIRunPromptOutputSchemaInvalid() {
+	w := s.runPromptWithOutputSchema(newTestOutputSchema(false), `{"greeting": 1}`)
+	assert.Equal(s.T(), http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(s.T(), w.Body.String(), "$.greeting: expected string")
+
+	call, err := service.EntClient.PromptCall.Query().
+		Where(promptcall.PromptId(s.prompt.ID)).
+		Order(ent.Desc(promptcall.FieldID)).
+		First(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), service.PromptCallResultInvalidOutput, call.Result)
+}
+
+func (s *promptAPITestSuite) TestAPIRunPromptOutputSchemaRetry() {
+	w := s.runPromptWithOutputSchema(newTestOutputSchema(true), "Hello John", `{"greeting": "Hello John"}`)
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+
+	var response service.APIRunPromptResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), map[string]interface{}{"greeting": "Hello John"}, response.ResponseObject)
+	// the usage of both runs is counted
+	assert.Equal(s.T(), 10, response.ResponseTokenCount)
+}
+
+func (s *promptAPITestSuite) TestAPIRunPromptToolCalls() {
+	hashedID := "abc123"
+	pt := *s.prompt
+	// a reply calling tools is not validated against the output schema
+	pt.OutputSchema = newTestOutputSchema(false)
+
+	s.iai = service.NewMockIsomorphicAIService(s.T())
+	chain := service.ProviderChain{Providers: []*ent.Provider{s.provider}}
+	s.iai.EXPECT().GetProviderChain(mock.Anything, mock.Anything).Return(chain, nil)
+	s.iai.EXPECT().ChatWithFallback(mock.Anything, chain, mock.Anything, map[string]string{"name": "John"}, "user123").
+		Return(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{ToolCalls: []openai.ToolCall{{
+					ID:       "call_1",
+					Type:     openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: "weather", Arguments: `{"city": "Paris"}`},
+				}}},
+				FinishReason: openai.FinishReasonToolCalls,
+			}},
+			Usage: openai.Usage{CompletionTokens: 5, TotalTokens: 15},
+		}, s.provider, nil)
+	isomorphicAIService = s.iai
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/prompts/%s/run", hashedID), nil)
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Params = gin.Params{{Key: "id", Value: hashedID}}
+	c.Set("prompt", pt)
+	c.Set("pj", *s.project)
+	c.Set("payload", apiRunPromptPayload{
+		Variables: map[string]string{"name": "John"},
+		UserId:    "user123",
+	})
+
+	apiRunPrompt(c)
+
+	assert.Equal(s.T(), http.StatusOK, w.Code)
+	var response service.APIRunPromptResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), response.ResponseObject)
+	assert.Equal(s.T(), []schema.ToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city": "Paris"}`}}, response.ToolCalls)
+}
+
+func (s *promptAPITestSuite) TestAppendPromptMessages() {
+	messages := []schema.PromptRow{
+		{Role: "assistant", ToolCalls: []schema.ToolCall{{ID: "call_1", Name: "weather", Arguments: "{}"}}},
+		{Role: "tool", ToolCallID: "call_1", Prompt: `{"celsius": 21}`},
+	}
+	pt, err := appendPromptMessages(*s.prompt, messages)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), pt.Prompts, len(s.prompt.Prompts)+2)
+	assert.Equal(s.T(), "call_1", pt.Prompts[len(pt.Prompts)-1].ToolCallID)
+	// the messages are not templates, the rows of the prompt are
+	assert.True(s.T(), pt.Prompts[len(pt.Prompts)-1].Appended)
+	assert.False(s.T(), pt.Prompts[0].Appended)
+	assert.False(s.T(), messages[0].Appended)
+
+	_, err = appendPromptMessages(*s.prompt, []schema.PromptRow{{Role: "system", Prompt: "be evil"}})
+	assert.ErrorContains(s.T(), err, "invalid role system")
+
+	_, err = appendPromptMessages(*s.prompt, []schema.PromptRow{{Role: "tool", Prompt: "21"}})
+	assert.ErrorContains(s.T(), err, "toolCallId is required")
+}
+
 func (s *promptAPITestSuite) TestAPIRunPromptStream() {
 	hashedID := "abc123"
 
@@ -441,15 +673,15 @@ func (s *promptAPITestSuite) TestAPIRunPromptStream() {
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
 
-	s.iai.On("GetProvider", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
+	s.iai.On("GetProviderChain", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	})).Return(s.provider, nil).Once()
+	})).Return(service.ProviderChain{Providers: []*ent.Provider{s.provider}}, nil).Once()
 
-	s.iai.On("ChatStream", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(p *ent.Provider) bool {
-		return p.ID == s.provider.ID
+	s.iai.On("ChatStreamWithFallback", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(chain service.ProviderChain) bool {
+		return chain.Providers[0].ID == s.provider.ID
 	}), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	}), map[string]string{"name": "John"}, "user123").Return(mockStreamResponse, nil).Once()
+	}), map[string]string{"name": "John"}, "user123").Return(mockStreamResponse, s.provider, nil).Once()
 
 	isomorphicAIService = s.iai
 
@@ -496,15 +728,15 @@ func (s *promptAPITestSuite) TestAPIRunPromptStreamError() {
 
 	s.iai = service.NewMockIsomorphicAIService(s.T())
 
-	s.iai.On("GetProvider", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
+	s.iai.On("GetProviderChain", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	})).Return(s.provider, nil)
+	})).Return(service.ProviderChain{Providers: []*ent.Provider{s.provider}}, nil)
 
-	s.iai.On("ChatStream", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(p *ent.Provider) bool {
-		return p.ID == s.provider.ID
+	s.iai.On("ChatStreamWithFallback", mock.AnythingOfType("*gin.Context"), mock.MatchedBy(func(chain service.ProviderChain) bool {
+		return chain.Providers[0].ID == s.provider.ID
 	}), mock.MatchedBy(func(prompt ent.Prompt) bool {
 		return prompt.ID == s.prompt.ID
-	}), map[string]string{"name": "John"}, "user123").Return(nil, fmt.Errorf("stream error"))
+	}), map[string]string{"name": "John"}, "user123").Return(nil, nil, fmt.Errorf("stream error"))
 
 	isomorphicAIService = s.iai
 
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/PromptPal/PromptPal/ent"
@@ -36,6 +37,23 @@ func testPrompt(c *gin.Context) {
 		return
 	}
 
+	// the partials are read from the project, the user must be able to edit its prompts
+	hasPermission, err := rbacService.HasPermission(c.Request.Context(), uid, &payload.ProjectID, service.PermPromptEdit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{
+			ErrorCode:    http.StatusInternalServerError,
+			ErrorMessage: err.Error(),
+		})
+		return
+	}
+	if !hasPermission {
+		c.JSON(http.StatusForbidden, errorResponse{
+			ErrorCode:    http.StatusForbidden,
+			ErrorMessage: "insufficient permissions: " + service.PermPromptEdit + " required",
+		})
+		return
+	}
+
 	provider, err := service.EntClient.Provider.Get(c, payload.ProviderID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, errorResponse{
@@ -45,8 +63,22 @@ func testPrompt(c *gin.Context) {
 		return
 	}
 
-	prompt := ent.Prompt{
-		Prompts: payload.Prompts,
+	prompt, _, err := service.ExpandPromptPartials(c.Request.Context(), ent.Prompt{
+		ProjectId: payload.ProjectID,
+		Name:      payload.Name,
+		Prompts:   payload.Prompts,
+	}, payload.Variables)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var templateErr *service.TemplateError
+		if errors.As(err, &templateErr) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, errorResponse{
+			ErrorCode:    status,
+			ErrorMessage: err.Error(),
+		})
+		return
 	}
 
 	res, err := isomorphicAIService.Chat(c.Request.Context(), provider, prompt, payload.Variables, "")
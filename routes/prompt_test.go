@@ -54,6 +54,9 @@ func (s *promptTestSuite) SetupSuite() {
 	web3Service = s.w3
 	isomorphicAIService = s.iai
 	hashidService = s.hashid
+	rbac := service.NewMockRBACService(s.T())
+	rbac.On("HasPermission", mock.Anything, mock.Anything, mock.Anything, service.PermPromptEdit).Return(true, nil).Maybe()
+	rbacService = rbac
 
 	gin.SetMode(gin.TestMode)
 	s.router = SetupGinRoutes("test", s.w3, s.iai, s.hashid, nil)
@@ -243,6 +246,48 @@ func (s *promptTestSuite) TestTestPromptUnauthorized() {
 	assert.Equal(s.T(), "invalid uid", response.ErrorMessage)
 }
 
+func (s *promptTestSuite) TestTestPromptForbidden() {
+	payload := testPromptPayload{
+		ProjectID:  s.project.ID,
+		ProviderID: s.provider.ID,
+		Name:       "Test Prompt",
+		Prompts: []schema.PromptRow{
+			{
+				Role:   "user",
+				Prompt: "{{> secret}}",
+			},
+		},
+		Variables: map[string]string{},
+	}
+
+	rbac := service.NewMockRBACService(s.T())
+	rbac.On("HasPermission", mock.Anything, s.user.ID, &s.project.ID, service.PermPromptEdit).Return(false, nil).Once()
+	previous := rbacService
+	rbacService = rbac
+	defer func() { rbacService = previous }()
+
+	payloadBytes, err := json.Marshal(payload)
+	assert.Nil(s.T(), err)
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/prompts/test", bytes.NewBuffer(payloadBytes))
+	req.Header.Set("Content-Type", "application/json")
+
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	c.Set("uid", s.user.ID)
+
+	testPrompt(c)
+
+	assert.Equal(s.T(), http.StatusForbidden, w.Code)
+
+	var response errorResponse
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "insufficient permissions: prompt:edit required", response.ErrorMessage)
+}
+
 func (s *promptTestSuite) TestTestPromptInvalidJSON() {
 	gin.SetMode(gin.TestMode)
 	w := httptest.NewRecorder()
@@ -33,6 +33,8 @@ var fileNames = []string{
 	"types/provider.gql",
 	"types/webhook.gql",
 	"types/webhook_call.gql",
+	"types/conversation.gql",
+	"types/modelPrice.gql",
 }
 
 func String() string {
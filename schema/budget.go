@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type projectBudgetInput struct {
+	SoftLimitCents *float64
+	HardLimitCents *float64
+	Period         *string
+	PeriodDays     *int32
+	StartAt        *string
+}
+
+func (b projectBudgetInput) toBudget() (*dbSchema.ProjectBudget, error) {
+	budget := &dbSchema.ProjectBudget{Period: service.BudgetPeriodMonthly}
+	if b.SoftLimitCents != nil {
+		budget.SoftLimitCents = *b.SoftLimitCents
+	}
+	if b.HardLimitCents != nil {
+		budget.HardLimitCents = *b.HardLimitCents
+	}
+	if budget.SoftLimitCents < 0 || budget.HardLimitCents < 0 {
+		return nil, NewGraphQLHttpError(http.StatusBadRequest, errors.New("budget limits can not be negative"))
+	}
+	if b.Period != nil {
+		budget.Period = *b.Period
+	}
+
+	switch budget.Period {
+	case service.BudgetPeriodMonthly:
+	case service.BudgetPeriodCustom:
+		if b.PeriodDays == nil || *b.PeriodDays <= 0 {
+			return nil, NewGraphQLHttpError(http.StatusBadRequest, errors.New("periodDays is required for a custom budget period"))
+		}
+		budget.PeriodDays = int(*b.PeriodDays)
+		budget.StartAt = time.Now().UTC().Truncate(24 * time.Hour)
+		if b.StartAt != nil {
+			startAt, err := time.Parse(time.RFC3339, *b.StartAt)
+			if err != nil {
+				return nil, NewGraphQLHttpError(http.StatusBadRequest, err)
+			}
+			budget.StartAt = startAt
+		}
+	default:
+		return nil, NewGraphQLHttpError(http.StatusBadRequest, errors.New("budget period must be monthly or custom"))
+	}
+	return budget, nil
+}
+
+type projectBudgetResponse struct {
+	b *dbSchema.ProjectBudget
+}
+
+func newProjectBudgetResponse(budget *dbSchema.ProjectBudget) *projectBudgetResponse {
+	if budget == nil {
+		return nil
+	}
+	return &projectBudgetResponse{b: budget}
+}
+
+func (p projectBudgetResponse) SoftLimitCents() float64 {
+	return p.b.SoftLimitCents
+}
+
+func (p projectBudgetResponse) HardLimitCents() float64 {
+	return p.b.HardLimitCents
+}
+
+func (p projectBudgetResponse) Period() string {
+	return p.b.Period
+}
+
+func (p projectBudgetResponse) PeriodDays() int32 {
+	return int32(p.b.PeriodDays)
+}
+
+func (p projectBudgetResponse) StartAt() *string {
+	if p.b.StartAt.IsZero() {
+		return nil
+	}
+	startAt := p.b.StartAt.Format(time.RFC3339)
+	return &startAt
+}
+
+type projectSpendResponse struct {
+	s      service.ProjectSpend
+	budget *dbSchema.ProjectBudget
+}
+
+func (p projectResponse) Spend(ctx context.Context) (projectSpendResponse, error) {
+	spend, err := service.GetProjectSpend(ctx, *p.p, time.Now())
+	if err != nil {
+		return projectSpendResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return projectSpendResponse{s: spend, budget: p.p.Budget}, nil
+}
+
+func (p projectSpendResponse) PeriodStart() string {
+	return p.s.PeriodStart.Format(time.RFC3339)
+}
+
+func (p projectSpendResponse) PeriodEnd() string {
+	return p.s.PeriodEnd.Format(time.RFC3339)
+}
+
+func (p projectSpendResponse) SpendCents() float64 {
+	return p.s.SpendCents
+}
+
+func (p projectSpendResponse) SoftLimitReached() bool {
+	return p.budget != nil && p.budget.SoftLimitCents > 0 && p.s.SpendCents >= p.budget.SoftLimitCents
+}
+
+func (p projectSpendResponse) HardLimitReached() bool {
+	return p.budget != nil && p.budget.HardLimitCents > 0 && p.s.SpendCents >= p.budget.HardLimitCents
+}
@@ -74,6 +74,12 @@ func (p promptCallResponse) ID() int32 {
 func (p promptCallResponse) UserId() string {
 	return p.pc.UserId
 }
+func (p promptCallResponse) PromptVersion() int32 {
+	return int32(p.pc.PromptVersion)
+}
+func (p promptCallResponse) PromptToken() int32 {
+	return int32(p.pc.PromptToken)
+}
 func (p promptCallResponse) ResponseToken() int32 {
 	return int32(p.pc.ResponseToken)
 }
@@ -84,9 +90,11 @@ func (p promptCallResponse) Duration() int32 {
 	return int32(p.pc.Duration)
 }
 func (p promptCallResponse) Result() string {
-	result := p.pc.Result
-	if result == 0 {
+	switch p.pc.Result {
+	case service.PromptCallResultSuccess:
 		return "success"
+	case service.PromptCallResultInvalidOutput:
+		return "invalidOutput"
 	}
 	return "fail"
 }
@@ -122,3 +130,18 @@ func (p promptCallResponse) UserAgent() string {
 func (p promptCallResponse) IP() string {
 	return p.pc.IP
 }
+
+func (p promptCallResponse) Model() string {
+	return p.pc.Model
+}
+
+func (p promptCallResponse) Provider(ctx context.Context) (*providerResponse, error) {
+	provider, err := p.pc.QueryProvider().Only(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return &providerResponse{p: provider}, nil
+}
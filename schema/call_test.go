@@ -130,23 +130,27 @@ func (s *callTestSuite) SetupSuite() {
 			ProjectID:   int32(s.pjID),
 			Name:        "test-openToken-call-test",
 			Description: "open token for call test",
+			TTL:         3600,
 		},
 	})
 
 	s.apiToken = ot.Token()
 
-	iai.On("GetProvider", mock.Anything, mock.Anything).
-		Return(&ent.Provider{
-			ID:       int(provider.ID()),
-			Name:     provider.Name(),
-			Source:   provider.Source(),
-			Endpoint: provider.Endpoint(),
-			ApiKey:   openAIToken,
-			Config:   map[string]interface{}{},
+	chainProvider := &ent.Provider{
+		ID:       int(provider.ID()),
+		Name:     provider.Name(),
+		Source:   provider.Source(),
+		Endpoint: provider.Endpoint(),
+		ApiKey:   openAIToken,
+		Config:   map[string]interface{}{},
+	}
+	iai.On("GetProviderChain", mock.Anything, mock.Anything).
+		Return(service.ProviderChain{
+			Providers: []*ent.Provider{chainProvider},
 		}, nil)
 
 	iai.On(
-		"Chat",
+		"ChatWithFallback",
 		mock.Anything,
 		mock.Anything,
 		mock.Anything,
@@ -159,6 +163,7 @@ func (s *callTestSuite) SetupSuite() {
 		Return(openai.ChatCompletionResponse{
 			ID:      "j",
 			Object:  "completion",
+			Model:   "gpt-4o-2024-08-06",
 			Created: time.Now().Unix(),
 			Choices: []openai.ChatCompletionChoice{
 				{
@@ -174,7 +179,7 @@ func (s *callTestSuite) SetupSuite() {
 				CompletionTokens: 8888,
 				TotalTokens:      1 << 16,
 			},
-		}, nil)
+		}, chainProvider, nil)
 
 	s.router = routes.SetupGinRoutes("test", w3, iai, hs, nil)
 }
@@ -191,7 +196,6 @@ func (s *callTestSuite) TestCreatePrompt() {
 			ProjectID:   int32(s.pjID),
 			Name:        "test-prompt",
 			Description: "test-prompt description",
-			TokenCount:  1,
 			Debug:       nil,
 			Enabled:     nil,
 			Prompts: []dbSchema.PromptRow{
@@ -215,7 +219,7 @@ func (s *callTestSuite) TestCreatePrompt() {
 
 	assert.Equal(s.T(), "test-prompt", result.Name())
 	assert.Equal(s.T(), "test-prompt description", result.Description())
-	assert.EqualValues(s.T(), 1, result.TokenCount())
+	assert.EqualValues(s.T(), 14, result.TokenCount())
 	assert.NotEmpty(s.T(), result.ID())
 	s.promptID = int(result.ID())
 	hid, _ := result.HashID()
@@ -326,6 +330,11 @@ func (s *callTestSuite) TestPerformCall() {
 	assert.GreaterOrEqual(s.T(), edge.Duration(), int32(100))
 	assert.EqualValues(s.T(), "34", edge.UserId())
 	assert.EqualValues(s.T(), 8888, edge.ResponseToken())
+	assert.EqualValues(s.T(), 18, edge.PromptToken())
+	assert.EqualValues(s.T(), "gpt-4o-2024-08-06", edge.Model())
+	callProvider, err := edge.Provider(ctx)
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), s.providerID, callProvider.ID())
 	assert.EqualValues(s.T(), "success", edge.Result())
 	assert.NotEmpty(s.T(), edge.CreatedAt())
 
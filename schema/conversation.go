@@ -0,0 +1,195 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/conversation"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type conversationArgs struct {
+	ID int32
+}
+
+type conversationsArgs struct {
+	ProjectID  int32
+	UserID     *string
+	Pagination paginationInput
+}
+
+func (q QueryResolver) Conversation(ctx context.Context, args conversationArgs) (conversationResponse, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	conv, err := service.EntClient.Conversation.Get(ctx, int(args.ID))
+	if err != nil {
+		return conversationResponse{}, NewGraphQLHttpError(http.StatusNotFound, err)
+	}
+
+	projectID := conv.ProjectId
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermProjectView)
+	if err != nil {
+		return conversationResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return conversationResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to view conversation"))
+	}
+
+	return conversationResponse{c: conv}, nil
+}
+
+type conversationsResponse struct {
+	stat       *ent.ConversationQuery
+	pagination paginationInput
+}
+
+func (q QueryResolver) Conversations(ctx context.Context, args conversationsArgs) (conversationsResponse, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	projectID := int(args.ProjectID)
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermProjectView)
+	if err != nil {
+		return conversationsResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return conversationsResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to view conversations"))
+	}
+
+	stat := service.EntClient.Conversation.Query().
+		Where(conversation.ProjectId(projectID))
+	if args.UserID != nil {
+		stat = stat.Where(conversation.UserId(*args.UserID))
+	}
+
+	return conversationsResponse{
+		stat:       stat.Order(ent.Desc(conversation.FieldID)),
+		pagination: args.Pagination,
+	}, nil
+}
+
+func (c conversationsResponse) Count(ctx context.Context) (int32, error) {
+	count, err := c.stat.Clone().Count(ctx)
+	if err != nil {
+		return 0, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return int32(count), nil
+}
+
+func (c conversationsResponse) Edges(ctx context.Context) (res []conversationResponse, err error) {
+	conversations, err := c.stat.Clone().
+		Limit(int(c.pagination.Limit)).
+		Offset(int(c.pagination.Offset)).
+		All(ctx)
+
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	for _, conv := range conversations {
+		res = append(res, conversationResponse{c: conv})
+	}
+	return
+}
+
+type deleteConversationArgs struct {
+	ID int32
+}
+
+func (q QueryResolver) DeleteConversation(ctx context.Context, args deleteConversationArgs) (bool, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	conv, err := service.EntClient.Conversation.Get(ctx, int(args.ID))
+	if err != nil {
+		return false, NewGraphQLHttpError(http.StatusNotFound, err)
+	}
+
+	projectID := conv.ProjectId
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermProjectEdit)
+	if err != nil {
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return false, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to delete conversation"))
+	}
+
+	err = service.EntClient.Conversation.DeleteOneID(conv.ID).Exec(ctx)
+	if err != nil {
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	return true, nil
+}
+
+type conversationResponse struct {
+	c *ent.Conversation
+}
+
+func (c conversationResponse) ID() int32 {
+	return int32(c.c.ID)
+}
+
+func (c conversationResponse) HashID() (string, error) {
+	hid, err := hashidService.Encode(c.c.ID)
+	if err != nil {
+		return "", NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return hid, nil
+}
+
+func (c conversationResponse) UserID() string {
+	return c.c.UserId
+}
+
+func (c conversationResponse) Messages() []promptRowResponse {
+	result := make([]promptRowResponse, len(c.c.Messages))
+	for i, v := range c.c.Messages {
+		result[i] = promptRowResponse{p: v}
+	}
+	return result
+}
+
+// History is what the next run sends after the rows of the prompt, the tokens
+// are counted with the provider of the project
+func (c conversationResponse) History(ctx context.Context) ([]promptRowResponse, error) {
+	provider, err := service.NewIsomorphicAIService().GetProvider(ctx, ent.Prompt{ProjectId: c.c.ProjectId})
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	history := service.ConversationHistory(c.c, provider, provider.DefaultModel)
+	result := make([]promptRowResponse, len(history))
+	for i, v := range history {
+		result[i] = promptRowResponse{p: v}
+	}
+	return result, nil
+}
+
+func (c conversationResponse) MaxMessages() int32 {
+	return int32(c.c.MaxMessages)
+}
+
+func (c conversationResponse) MaxTokens() int32 {
+	return int32(c.c.MaxTokens)
+}
+
+func (c conversationResponse) TTL() int32 {
+	return int32(c.c.TTL)
+}
+
+func (c conversationResponse) Expired() bool {
+	return service.IsConversationExpired(c.c)
+}
+
+func (c conversationResponse) ExpireAt() string {
+	return c.c.ExpireAt.Format(time.RFC3339)
+}
+
+func (c conversationResponse) CreatedAt() string {
+	return c.c.CreateTime.Format(time.RFC3339)
+}
+
+func (c conversationResponse) UpdatedAt() string {
+	return c.c.UpdateTime.Format(time.RFC3339)
+}
@@ -0,0 +1,112 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/PromptPal/PromptPal/ent/provider"
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+var fallbackErrorClasses = []string{
+	service.FallbackOn5xx,
+	service.FallbackOnRateLimit,
+	service.FallbackOnTimeout,
+	service.FallbackOnNetwork,
+}
+
+type fallbackRulesInput struct {
+	On               *[]string
+	AttemptTimeoutMs *int32
+	BudgetMs         *int32
+}
+
+func (f fallbackRulesInput) toRules() (*dbSchema.FallbackRules, error) {
+	rules := &dbSchema.FallbackRules{}
+	if f.On != nil {
+		for _, class := range *f.On {
+			if !slices.Contains(fallbackErrorClasses, class) {
+				return nil, NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("unknown fallback error class: %s", class))
+			}
+		}
+		rules.On = *f.On
+	}
+	if f.AttemptTimeoutMs != nil {
+		rules.AttemptTimeoutMs = int(*f.AttemptTimeoutMs)
+	}
+	if f.BudgetMs != nil {
+		rules.BudgetMs = int(*f.BudgetMs)
+	}
+	return rules, nil
+}
+
+// toFallbackProviderIds checks that the fallback providers exist, a wrong id would only be skipped by the runs
+func toFallbackProviderIds(ctx context.Context, ids []int32) ([]int, error) {
+	result := make([]int, len(ids))
+	for i, id := range ids {
+		result[i] = int(id)
+	}
+	if len(result) == 0 {
+		return result, nil
+	}
+	existing, err := service.EntClient.Provider.Query().Where(provider.IDIn(result...)).IDs(ctx)
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	for _, id := range result {
+		if !slices.Contains(existing, id) {
+			return nil, NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("fallback provider %d not found", id))
+		}
+	}
+	return result, nil
+}
+
+type fallbackRulesResponse struct {
+	r *dbSchema.FallbackRules
+}
+
+func (f fallbackRulesResponse) On() []string {
+	if f.r.On == nil {
+		return []string{}
+	}
+	return f.r.On
+}
+
+func (f fallbackRulesResponse) AttemptTimeoutMs() int32 {
+	return int32(f.r.AttemptTimeoutMs)
+}
+
+func (f fallbackRulesResponse) BudgetMs() int32 {
+	return int32(f.r.BudgetMs)
+}
+
+func newFallbackRulesResponse(rules *dbSchema.FallbackRules) *fallbackRulesResponse {
+	if rules == nil {
+		return nil
+	}
+	return &fallbackRulesResponse{r: rules}
+}
+
+// fallbackProvidersResponse loads the providers in the order of ids
+func fallbackProvidersResponse(ctx context.Context, ids []int) ([]providerResponse, error) {
+	result := []providerResponse{}
+	if len(ids) == 0 {
+		return result, nil
+	}
+	providers, err := service.EntClient.Provider.Query().Where(provider.IDIn(ids...)).All(ctx)
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	for _, id := range ids {
+		for _, p := range providers {
+			if p.ID == id {
+				result = append(result, providerResponse{p: p})
+				break
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package schema
+
+import (
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+)
+
+type modelParametersInput struct {
+	Model            *string
+	Temperature      *float64
+	TopP             *float64
+	MaxTokens        *int32
+	Stop             *[]string
+	Seed             *int32
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+}
+
+func (m modelParametersInput) toParameters() *dbSchema.ModelParameters {
+	params := &dbSchema.ModelParameters{
+		Temperature:      m.Temperature,
+		TopP:             m.TopP,
+		PresencePenalty:  m.PresencePenalty,
+		FrequencyPenalty: m.FrequencyPenalty,
+	}
+	if m.Model != nil {
+		params.Model = *m.Model
+	}
+	if m.MaxTokens != nil {
+		maxTokens := int(*m.MaxTokens)
+		params.MaxTokens = &maxTokens
+	}
+	if m.Stop != nil {
+		params.Stop = *m.Stop
+	}
+	if m.Seed != nil {
+		seed := int(*m.Seed)
+		params.Seed = &seed
+	}
+	return params
+}
+
+type modelParametersResponse struct {
+	p *dbSchema.ModelParameters
+}
+
+func newModelParametersResponse(params *dbSchema.ModelParameters) *modelParametersResponse {
+	if params == nil {
+		return nil
+	}
+	return &modelParametersResponse{p: params}
+}
+
+func (m modelParametersResponse) Model() *string {
+	if m.p.Model == "" {
+		return nil
+	}
+	return &m.p.Model
+}
+
+func (m modelParametersResponse) Temperature() *float64 {
+	return m.p.Temperature
+}
+
+func (m modelParametersResponse) TopP() *float64 {
+	return m.p.TopP
+}
+
+func (m modelParametersResponse) MaxTokens() *int32 {
+	if m.p.MaxTokens == nil {
+		return nil
+	}
+	maxTokens := int32(*m.p.MaxTokens)
+	return &maxTokens
+}
+
+func (m modelParametersResponse) Stop() []string {
+	if m.p.Stop == nil {
+		return []string{}
+	}
+	return m.p.Stop
+}
+
+func (m modelParametersResponse) Seed() *int32 {
+	if m.p.Seed == nil {
+		return nil
+	}
+	seed := int32(*m.p.Seed)
+	return &seed
+}
+
+func (m modelParametersResponse) PresencePenalty() *float64 {
+	return m.p.PresencePenalty
+}
+
+func (m modelParametersResponse) FrequencyPenalty() *float64 {
+	return m.p.FrequencyPenalty
+}
@@ -0,0 +1,230 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/modelprice"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type modelPricesArgs struct {
+	Model      *string
+	ProviderId *int32
+	Pagination paginationInput
+}
+
+type modelPricesResponse struct {
+	stat       *ent.ModelPriceQuery
+	pagination paginationInput
+}
+
+func (q QueryResolver) ModelPrices(ctx context.Context, args modelPricesArgs) (modelPricesResponse, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, nil, service.PermSystemAdmin)
+	if err != nil {
+		return modelPricesResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return modelPricesResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to view model prices"))
+	}
+
+	stat := service.EntClient.ModelPrice.Query()
+	if args.Model != nil {
+		stat = stat.Where(modelprice.Model(strings.ToLower(*args.Model)))
+	}
+	if args.ProviderId != nil {
+		stat = stat.Where(modelprice.ProviderId(int(*args.ProviderId)))
+	}
+
+	return modelPricesResponse{
+		stat: stat.Order(
+			ent.Asc(modelprice.FieldModel),
+			ent.Desc(modelprice.FieldStartFrom),
+		),
+		pagination: args.Pagination,
+	}, nil
+}
+
+func (m modelPricesResponse) Count(ctx context.Context) (int32, error) {
+	count, err := m.stat.Clone().Count(ctx)
+	if err != nil {
+		return 0, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return int32(count), nil
+}
+
+func (m modelPricesResponse) Edges(ctx context.Context) (res []modelPriceResponse, err error) {
+	prices, err := m.stat.Clone().
+		Limit(int(m.pagination.Limit)).
+		Offset(int(m.pagination.Offset)).
+		All(ctx)
+
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	for _, price := range prices {
+		res = append(res, modelPriceResponse{p: price})
+	}
+	return
+}
+
+type modelPriceInput struct {
+	Model                       string
+	ProviderId                  *int32
+	StartFrom                   string
+	InputTokenCostInCents       float64
+	OutputTokenCostInCents      float64
+	CachedInputTokenCostInCents *float64
+	ReasoningTokenCostInCents   *float64
+}
+
+type upsertModelPriceArgs struct {
+	Data modelPriceInput
+}
+
+func (m modelPriceInput) validate() (startFrom time.Time, err error) {
+	if strings.TrimSpace(m.Model) == "" {
+		return startFrom, errors.New("model is required")
+	}
+	startFrom, err = time.Parse(time.RFC3339, m.StartFrom)
+	if err != nil {
+		return startFrom, fmt.Errorf("startFrom must be an RFC3339 time: %w", err)
+	}
+	prices := []*float64{&m.InputTokenCostInCents, &m.OutputTokenCostInCents, m.CachedInputTokenCostInCents, m.ReasoningTokenCostInCents}
+	for _, price := range prices {
+		if price != nil && *price < 0 {
+			return startFrom, errors.New("prices can not be negative")
+		}
+	}
+	return startFrom, nil
+}
+
+// UpsertModelPrice sets the price of a model from a date on. the price of the same
+// model, provider and date is replaced, a new date adds a price.
+func (q QueryResolver) UpsertModelPrice(ctx context.Context, args upsertModelPriceArgs) (modelPriceResponse, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+	data := args.Data
+
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, nil, service.PermSystemAdmin)
+	if err != nil {
+		return modelPriceResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return modelPriceResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to edit model prices"))
+	}
+
+	startFrom, err := data.validate()
+	if err != nil {
+		return modelPriceResponse{}, NewGraphQLHttpError(http.StatusBadRequest, err)
+	}
+	model := strings.ToLower(strings.TrimSpace(data.Model))
+
+	query := service.EntClient.ModelPrice.Query().
+		Where(
+			modelprice.Model(model),
+			modelprice.StartFrom(startFrom),
+		)
+	if data.ProviderId != nil {
+		if _, err := service.EntClient.Provider.Get(ctx, int(*data.ProviderId)); err != nil {
+			return modelPriceResponse{}, NewGraphQLHttpError(http.StatusNotFound, err)
+		}
+		query = query.Where(modelprice.ProviderId(int(*data.ProviderId)))
+	} else {
+		query = query.Where(modelprice.ProviderIdIsNil())
+	}
+
+	existing, err := query.Only(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		return modelPriceResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	var price *ent.ModelPrice
+	if existing != nil {
+		updater := existing.Update().
+			SetInputTokenCostInCents(data.InputTokenCostInCents).
+			SetOutputTokenCostInCents(data.OutputTokenCostInCents).
+			ClearCachedInputTokenCostInCents().
+			ClearReasoningTokenCostInCents()
+		if data.CachedInputTokenCostInCents != nil {
+			updater = updater.SetCachedInputTokenCostInCents(*data.CachedInputTokenCostInCents)
+		}
+		if data.ReasoningTokenCostInCents != nil {
+			updater = updater.SetReasoningTokenCostInCents(*data.ReasoningTokenCostInCents)
+		}
+		price, err = updater.Save(ctx)
+	} else {
+		stat := service.EntClient.ModelPrice.Create().
+			SetModel(model).
+			SetStartFrom(startFrom).
+			SetInputTokenCostInCents(data.InputTokenCostInCents).
+			SetOutputTokenCostInCents(data.OutputTokenCostInCents).
+			SetNillableCachedInputTokenCostInCents(data.CachedInputTokenCostInCents).
+			SetNillableReasoningTokenCostInCents(data.ReasoningTokenCostInCents)
+		if data.ProviderId != nil {
+			stat = stat.SetProviderID(int(*data.ProviderId))
+		}
+		price, err = stat.Save(ctx)
+	}
+	if err != nil {
+		return modelPriceResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	service.ClearModelPricesCache(ctx, model)
+	return modelPriceResponse{p: price}, nil
+}
+
+type modelPriceResponse struct {
+	p *ent.ModelPrice
+}
+
+func (m modelPriceResponse) ID() int32 {
+	return int32(m.p.ID)
+}
+
+func (m modelPriceResponse) Model() string {
+	return m.p.Model
+}
+
+func (m modelPriceResponse) ProviderId() *int32 {
+	if m.p.ProviderId == nil {
+		return nil
+	}
+	id := int32(*m.p.ProviderId)
+	return &id
+}
+
+func (m modelPriceResponse) StartFrom() string {
+	return m.p.StartFrom.Format(time.RFC3339)
+}
+
+func (m modelPriceResponse) InputTokenCostInCents() float64 {
+	return m.p.InputTokenCostInCents
+}
+
+func (m modelPriceResponse) OutputTokenCostInCents() float64 {
+	return m.p.OutputTokenCostInCents
+}
+
+func (m modelPriceResponse) CachedInputTokenCostInCents() *float64 {
+	return m.p.CachedInputTokenCostInCents
+}
+
+func (m modelPriceResponse) ReasoningTokenCostInCents() *float64 {
+	return m.p.ReasoningTokenCostInCents
+}
+
+func (m modelPriceResponse) CreatedAt() string {
+	return m.p.CreateTime.Format(time.RFC3339)
+}
+
+func (m modelPriceResponse) UpdatedAt() string {
+	return m.p.UpdateTime.Format(time.RFC3339)
+}
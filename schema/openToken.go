@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/PromptPal/PromptPal/ent"
@@ -13,7 +12,6 @@ import (
 	"github.com/PromptPal/PromptPal/ent/project"
 	"github.com/PromptPal/PromptPal/service"
 	"github.com/go-redis/cache/v9"
-	"github.com/google/uuid"
 )
 
 type createOpenTokenData struct {
@@ -23,6 +21,10 @@ type createOpenTokenData struct {
 	TTL                int32 // in seconds
 	ApiValidateEnabled bool
 	ApiValidatePath    *string
+	RequestsPerMinute  *int32
+	TokensPerDay       *int32
+	CostCentsPerDay    *float64
+	Label              *string
 }
 
 type createOpenTokenArgs struct {
@@ -71,23 +73,44 @@ func (q QueryResolver) CreateOpenToken(ctx context.Context, args createOpenToken
 	}
 
 	payload := args.Data
+	if err = validateOpenTokenLimits(payload.RequestsPerMinute, payload.TokensPerDay, payload.CostCentsPerDay); err != nil {
+		return
+	}
+
+	if err = validateOpenTokenLabel(payload.Label); err != nil {
+		return
+	}
 
-	tk := strings.Replace(uuid.New().String(), "-", "", -1)
+	if payload.TTL <= 0 {
+		err = NewGraphQLHttpError(http.StatusBadRequest, errors.New("ttl must be positive"))
+		return
+	}
+
+	tk, prefix := service.NewOpenToken()
 	expireAt := time.Now().Add(time.Second * time.Duration(payload.TTL))
 
-	ot, err := service.
+	stat := service.
 		EntClient.
 		OpenToken.
 		Create().
 		SetName(payload.Name).
 		SetDescription(payload.Description).
-		SetToken(tk).
+		SetPrefix(prefix).
+		SetTokenHash(service.HashOpenToken(tk)).
 		SetApiValidateEnabled(payload.ApiValidateEnabled).
 		SetNillableApiValidatePath(payload.ApiValidatePath).
+		SetNillableCostCentsPerDay(payload.CostCentsPerDay).
+		SetNillableLabel(payload.Label).
 		SetUserID(ctxValue.UserID).
 		SetProjectID(pid).
-		SetExpireAt(expireAt).
-		Save(ctx)
+		SetExpireAt(expireAt)
+	if payload.RequestsPerMinute != nil {
+		stat = stat.SetRequestsPerMinute(int(*payload.RequestsPerMinute))
+	}
+	if payload.TokensPerDay != nil {
+		stat = stat.SetTokensPerDay(int(*payload.TokensPerDay))
+	}
+	ot, err := stat.Save(ctx)
 
 	if err != nil {
 		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
@@ -112,7 +135,32 @@ type openTokenUpdate struct {
 		TTL                *int32
 		ApiValidateEnabled *bool
 		ApiValidatePath    *string
+		RequestsPerMinute  *int32
+		TokensPerDay       *int32
+		CostCentsPerDay    *float64
+		Label              *string
+	}
+}
+
+// validateOpenTokenLimits rejects negative limits, 0 means unlimited
+func validateOpenTokenLimits(requestsPerMinute, tokensPerDay *int32, costCentsPerDay *float64) error {
+	if (requestsPerMinute != nil && *requestsPerMinute < 0) ||
+		(tokensPerDay != nil && *tokensPerDay < 0) ||
+		(costCentsPerDay != nil && *costCentsPerDay < 0) {
+		return NewGraphQLHttpError(http.StatusBadRequest, errors.New("the limits of the token can not be negative"))
+	}
+	return nil
+}
+
+// validateOpenTokenLabel accepts the names of the labels, empty runs the latest versions
+func validateOpenTokenLabel(label *string) error {
+	if label == nil || *label == "" {
+		return nil
+	}
+	if err := service.ValidatePromptLabel(*label); err != nil {
+		return NewGraphQLHttpError(http.StatusBadRequest, err)
 	}
+	return nil
 }
 
 func (q QueryResolver) UpdateOpenToken(ctx context.Context, args openTokenUpdate) (openTokenResponse, error) {
@@ -151,10 +199,30 @@ func (q QueryResolver) UpdateOpenToken(ctx context.Context, args openTokenUpdate
 	if args.Data.ApiValidatePath != nil {
 		stat = stat.SetApiValidatePath(*args.Data.ApiValidatePath)
 	}
+	if err := validateOpenTokenLimits(args.Data.RequestsPerMinute, args.Data.TokensPerDay, args.Data.CostCentsPerDay); err != nil {
+		return openTokenResponse{}, err
+	}
+	if args.Data.RequestsPerMinute != nil {
+		stat = stat.SetRequestsPerMinute(int(*args.Data.RequestsPerMinute))
+	}
+	if args.Data.TokensPerDay != nil {
+		stat = stat.SetTokensPerDay(int(*args.Data.TokensPerDay))
+	}
+	if args.Data.CostCentsPerDay != nil {
+		stat = stat.SetCostCentsPerDay(*args.Data.CostCentsPerDay)
+	}
+	if err := validateOpenTokenLabel(args.Data.Label); err != nil {
+		return openTokenResponse{}, err
+	}
+	if args.Data.Label != nil {
+		stat = stat.SetLabel(*args.Data.Label)
+	}
 	ot, err := stat.Save(ctx)
 	if err != nil {
 		return openTokenResponse{}, err
 	}
+	// the api middleware caches the token by its value, the new limits apply at once
+	service.ClearOpenTokenCache(ctx, ot)
 	service.Cache.Set(&cache.Item{
 		Ctx:   ctx,
 		Key:   fmt.Sprintf("openToken:%d", ot.ID),
@@ -198,9 +266,78 @@ func (q QueryResolver) DeleteOpenToken(ctx context.Context, args deleteOpenToken
 		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
 		return false, err
 	}
+	service.ClearOpenTokenCache(ctx, existingToken)
 	return true, nil
 }
 
+type rotateOpenTokenArgs struct {
+	ID int32
+	// seconds the old secret stays valid, an hour by default
+	GracePeriod *int32
+}
+
+const maxOpenTokenGracePeriod = 7 * 24 * 3600
+
+func (q QueryResolver) RotateOpenToken(ctx context.Context, args rotateOpenTokenArgs) (result createOpenTokenResponse, err error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	existingToken, err := service.EntClient.OpenToken.Get(ctx, int(args.ID))
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusNotFound, err)
+		return
+	}
+
+	projectID := existingToken.ProjectOpenTokens
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermProjectEdit)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	if !hasPermission {
+		err = NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to rotate open token"))
+		return
+	}
+
+	gracePeriod := int32(3600)
+	if args.GracePeriod != nil {
+		gracePeriod = *args.GracePeriod
+	}
+	if gracePeriod < 0 || gracePeriod > maxOpenTokenGracePeriod {
+		err = NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("gracePeriod must be between 0 and %d seconds", maxOpenTokenGracePeriod))
+		return
+	}
+
+	tk, prefix := service.NewOpenToken()
+	stat := service.
+		EntClient.
+		OpenToken.
+		UpdateOneID(existingToken.ID).
+		SetPrefix(prefix).
+		SetTokenHash(service.HashOpenToken(tk))
+	if gracePeriod > 0 {
+		stat = stat.
+			SetPreviousPrefix(existingToken.Prefix).
+			SetPreviousTokenHash(existingToken.TokenHash).
+			SetPreviousTokenExpireAt(time.Now().Add(time.Second * time.Duration(gracePeriod)))
+	} else {
+		stat = stat.
+			ClearPreviousPrefix().
+			ClearPreviousTokenHash().
+			ClearPreviousTokenExpireAt()
+	}
+	ot, err := stat.Save(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	// the old secret is loaded again with its grace period
+	service.ClearOpenTokenCache(ctx, existingToken)
+
+	result.openToken = ot
+	result.token = tk
+	return
+}
+
 type openTokenListResponse struct {
 	openTokens []*ent.OpenToken
 }
@@ -251,3 +388,39 @@ func (o openTokenResponse) ApiValidateEnabled() bool {
 func (o openTokenResponse) ApiValidatePath() string {
 	return o.openToken.ApiValidatePath
 }
+
+func (o openTokenResponse) RequestsPerMinute() int32 {
+	return int32(o.openToken.RequestsPerMinute)
+}
+
+func (o openTokenResponse) TokensPerDay() int32 {
+	return int32(o.openToken.TokensPerDay)
+}
+
+func (o openTokenResponse) CostCentsPerDay() float64 {
+	return o.openToken.CostCentsPerDay
+}
+
+func (o openTokenResponse) Label() string {
+	return o.openToken.Label
+}
+
+func (o openTokenResponse) PreviousTokenExpireAt() *string {
+	if o.openToken.PreviousTokenExpireAt == nil {
+		return nil
+	}
+	expireAt := o.openToken.PreviousTokenExpireAt.Format(time.RFC3339)
+	return &expireAt
+}
+
+func (o openTokenResponse) LastUsedAt() *string {
+	if o.openToken.LastUsedAt == nil {
+		return nil
+	}
+	lastUsedAt := o.openToken.LastUsedAt.Format(time.RFC3339)
+	return &lastUsedAt
+}
+
+func (o openTokenResponse) LastUsedIp() *string {
+	return o.openToken.LastUsedIp
+}
@@ -108,6 +108,13 @@ func (s *openTokenTestSuite) TestCreateOpenToken() {
 	assert.Equal(s.T(), desc, result.Data().Description())
 	assert.NotEmpty(s.T(), 1, result.Data().ExpireAt())
 
+	// only the hash of the token is stored
+	ot, err := service.EntClient.OpenToken.Get(ctx, int(result.Data().ID()))
+	assert.Nil(s.T(), err)
+	assert.Empty(s.T(), ot.Token)
+	assert.Equal(s.T(), service.HashOpenToken(result.Token()), ot.TokenHash)
+	assert.Equal(s.T(), service.OpenTokenPrefix(result.Token()), ot.Prefix)
+
 	s.otID = int(result.Data().ID())
 }
 
@@ -136,6 +143,38 @@ func (s *openTokenTestSuite) TestListOpenToken() {
 	assert.NotEmpty(s.T(), pt.Name())
 }
 
+func (s *openTokenTestSuite) TestOpenTokenRotation() {
+	q := QueryResolver{}
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	before, err := service.EntClient.OpenToken.Get(ctx, s.otID)
+	assert.Nil(s.T(), err)
+
+	gracePeriod := int32(60)
+	result, err := q.RotateOpenToken(ctx, rotateOpenTokenArgs{
+		ID:          int32(s.otID),
+		GracePeriod: &gracePeriod,
+	})
+	assert.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), result.Token())
+	assert.NotNil(s.T(), result.Data().PreviousTokenExpireAt())
+
+	after, err := service.EntClient.OpenToken.Get(ctx, s.otID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), service.HashOpenToken(result.Token()), after.TokenHash)
+	assert.Equal(s.T(), before.TokenHash, *after.PreviousTokenHash)
+	assert.NotEqual(s.T(), before.TokenHash, after.TokenHash)
+
+	gracePeriod = -1
+	_, err = q.RotateOpenToken(ctx, rotateOpenTokenArgs{
+		ID:          int32(s.otID),
+		GracePeriod: &gracePeriod,
+	})
+	assert.Error(s.T(), err)
+}
+
 func (s *openTokenTestSuite) TestPurgeOpenToken() {
 	q := QueryResolver{}
 	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
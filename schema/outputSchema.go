@@ -0,0 +1,64 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+)
+
+type outputSchemaInput struct {
+	Name           *string
+	Schema         string
+	Strict         *bool
+	RetryOnInvalid *bool
+}
+
+func (o outputSchemaInput) toOutputSchema() (*dbSchema.OutputSchema, error) {
+	output := &dbSchema.OutputSchema{}
+	if err := json.Unmarshal([]byte(o.Schema), &output.Schema); err != nil {
+		return nil, NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("invalid output schema: %w", err))
+	}
+	if o.Name != nil {
+		output.Name = *o.Name
+	}
+	if o.Strict != nil {
+		output.Strict = *o.Strict
+	}
+	if o.RetryOnInvalid != nil {
+		output.RetryOnInvalid = *o.RetryOnInvalid
+	}
+	return output, nil
+}
+
+type outputSchemaResponse struct {
+	o *dbSchema.OutputSchema
+}
+
+func newOutputSchemaResponse(output *dbSchema.OutputSchema) *outputSchemaResponse {
+	if output == nil || len(output.Schema) == 0 {
+		return nil
+	}
+	return &outputSchemaResponse{o: output}
+}
+
+func (o outputSchemaResponse) Name() string {
+	return o.o.Name
+}
+
+func (o outputSchemaResponse) Schema() string {
+	result, err := json.Marshal(o.o.Schema)
+	if err != nil {
+		return "{}"
+	}
+	return string(result)
+}
+
+func (o outputSchemaResponse) Strict() bool {
+	return o.o.Strict
+}
+
+func (o outputSchemaResponse) RetryOnInvalid() bool {
+	return o.o.RetryOnInvalid
+}
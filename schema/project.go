@@ -29,7 +29,10 @@ type createProjectData struct {
 	OpenAITopP        *float64
 	OpenAIMaxTokens   *int32
 
-	ProviderId int32
+	ProviderId          int32
+	FallbackProviderIds *[]int32
+	FallbackRules       *fallbackRulesInput
+	Budget              *projectBudgetInput
 }
 
 type createProjectArgs struct {
@@ -70,6 +73,27 @@ func (q QueryResolver) CreateProject(ctx context.Context, args createProjectArgs
 	if data.OpenAIMaxTokens != nil {
 		stat = stat.SetOpenAIMaxTokens(int(*data.OpenAIMaxTokens))
 	}
+	if data.FallbackProviderIds != nil {
+		ids, err := toFallbackProviderIds(ctx, *data.FallbackProviderIds)
+		if err != nil {
+			return projectResponse{}, err
+		}
+		stat = stat.SetFallbackProviderIds(ids)
+	}
+	if data.FallbackRules != nil {
+		rules, err := data.FallbackRules.toRules()
+		if err != nil {
+			return projectResponse{}, err
+		}
+		stat = stat.SetFallbackRules(rules)
+	}
+	if data.Budget != nil {
+		budget, err := data.Budget.toBudget()
+		if err != nil {
+			return projectResponse{}, err
+		}
+		stat = stat.SetBudget(budget)
+	}
 
 	pj, err := stat.
 		SetCreatorID(ctxValue.UserID).
@@ -133,6 +157,27 @@ func (q QueryResolver) UpdateProject(ctx context.Context, args updateProjectArgs
 	if args.Data.ProviderId > 0 {
 		updater = updater.SetProviderID(int(args.Data.ProviderId))
 	}
+	if args.Data.FallbackProviderIds != nil {
+		ids, err := toFallbackProviderIds(ctx, *args.Data.FallbackProviderIds)
+		if err != nil {
+			return projectResponse{}, err
+		}
+		updater = updater.SetFallbackProviderIds(ids)
+	}
+	if args.Data.FallbackRules != nil {
+		rules, err := args.Data.FallbackRules.toRules()
+		if err != nil {
+			return projectResponse{}, err
+		}
+		updater = updater.SetFallbackRules(rules)
+	}
+	if args.Data.Budget != nil {
+		budget, err := args.Data.Budget.toBudget()
+		if err != nil {
+			return projectResponse{}, err
+		}
+		updater = updater.SetBudget(budget)
+	}
 
 	pj, err := updater.Save(ctx)
 	if err != nil {
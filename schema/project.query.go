@@ -156,6 +156,18 @@ func (p projectResponse) Provider(ctx context.Context) (*providerResponse, error
 	return &providerResponse{p: pj}, nil
 }
 
+func (p projectResponse) FallbackProviders(ctx context.Context) ([]providerResponse, error) {
+	return fallbackProvidersResponse(ctx, p.p.FallbackProviderIds)
+}
+
+func (p projectResponse) FallbackRules() *fallbackRulesResponse {
+	return newFallbackRulesResponse(p.p.FallbackRules)
+}
+
+func (p projectResponse) Budget() *projectBudgetResponse {
+	return newProjectBudgetResponse(p.p.Budget)
+}
+
 func (p projectResponse) Creator(ctx context.Context) (res userResponse, err error) {
 	u, err := service.
 		EntClient.
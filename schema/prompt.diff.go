@@ -0,0 +1,211 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type promptDiffArgs struct {
+	PromptId int32
+	// the current prompt if nil
+	FromHistoryId *int32
+	ToHistoryId   *int32
+}
+
+// PromptDiff tells what changed from a version of the prompt to another one
+func (q QueryResolver) PromptDiff(ctx context.Context, args promptDiffArgs) (result promptDiffResponse, err error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	current, err := service.EntClient.Prompt.Get(ctx, int(args.PromptId))
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusNotFound, err)
+		return
+	}
+
+	projectID := current.ProjectId
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermPromptView)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	if !hasPermission {
+		err = NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to view prompt"))
+		return
+	}
+
+	snapshotOf := func(historyID *int32) (schema.PromptComplete, error) {
+		if historyID == nil {
+			return service.PromptSnapshot(*current), nil
+		}
+		h, err := service.EntClient.History.Get(ctx, int(*historyID))
+		if err != nil || h.PromptId != current.ID {
+			return schema.PromptComplete{}, NewGraphQLHttpError(http.StatusNotFound, errors.New("history not found"))
+		}
+		snapshot := h.Snapshot
+		snapshot.Version = h.Version
+		return snapshot, nil
+	}
+
+	from, err := snapshotOf(args.FromHistoryId)
+	if err != nil {
+		return
+	}
+	to, err := snapshotOf(args.ToHistoryId)
+	if err != nil {
+		return
+	}
+	result.d = service.DiffPromptSnapshots(from, to)
+	return
+}
+
+type promptDiffResponse struct {
+	d service.PromptDiff
+}
+
+func (p promptDiffResponse) FromVersion() int32 {
+	return int32(p.d.FromVersion)
+}
+
+func (p promptDiffResponse) ToVersion() int32 {
+	return int32(p.d.ToVersion)
+}
+
+func (p promptDiffResponse) Rows() []promptRowDiffResponse {
+	result := make([]promptRowDiffResponse, len(p.d.Rows))
+	for i, r := range p.d.Rows {
+		result[i] = promptRowDiffResponse{r: r}
+	}
+	return result
+}
+
+func (p promptDiffResponse) Variables() []promptVariableDiffResponse {
+	result := make([]promptVariableDiffResponse, len(p.d.Variables))
+	for i, v := range p.d.Variables {
+		result[i] = promptVariableDiffResponse{v: v}
+	}
+	return result
+}
+
+func (p promptDiffResponse) Fields() []promptFieldDiffResponse {
+	result := make([]promptFieldDiffResponse, len(p.d.Fields))
+	for i, f := range p.d.Fields {
+		result[i] = promptFieldDiffResponse{f: f}
+	}
+	return result
+}
+
+func toNullableInt32(v *int) *int32 {
+	if v == nil {
+		return nil
+	}
+	result := int32(*v)
+	return &result
+}
+
+type promptRowDiffResponse struct {
+	r service.PromptRowDiff
+}
+
+func (p promptRowDiffResponse) Op() string {
+	return string(p.r.Op)
+}
+
+func (p promptRowDiffResponse) FromIndex() *int32 {
+	return toNullableInt32(p.r.FromIndex)
+}
+
+func (p promptRowDiffResponse) ToIndex() *int32 {
+	return toNullableInt32(p.r.ToIndex)
+}
+
+func (p promptRowDiffResponse) FromRole() *string {
+	return p.r.FromRole
+}
+
+func (p promptRowDiffResponse) ToRole() *string {
+	return p.r.ToRole
+}
+
+func (p promptRowDiffResponse) Lines() []promptLineDiffResponse {
+	result := make([]promptLineDiffResponse, len(p.r.Lines))
+	for i, l := range p.r.Lines {
+		result[i] = promptLineDiffResponse{l: l}
+	}
+	return result
+}
+
+type promptLineDiffResponse struct {
+	l service.LineDiff
+}
+
+func (p promptLineDiffResponse) Op() string {
+	return string(p.l.Op)
+}
+
+func (p promptLineDiffResponse) From() *string {
+	return p.l.From
+}
+
+func (p promptLineDiffResponse) To() *string {
+	return p.l.To
+}
+
+func (p promptLineDiffResponse) Words() []promptWordDiffResponse {
+	result := make([]promptWordDiffResponse, len(p.l.Words))
+	for i, w := range p.l.Words {
+		result[i] = promptWordDiffResponse{w: w}
+	}
+	return result
+}
+
+type promptWordDiffResponse struct {
+	w service.WordDiff
+}
+
+func (p promptWordDiffResponse) Op() string {
+	return string(p.w.Op)
+}
+
+func (p promptWordDiffResponse) Text() string {
+	return p.w.Text
+}
+
+type promptVariableDiffResponse struct {
+	v service.PromptVariableDiff
+}
+
+func (p promptVariableDiffResponse) Op() string {
+	return string(p.v.Op)
+}
+
+func (p promptVariableDiffResponse) Name() string {
+	return p.v.Name
+}
+
+func (p promptVariableDiffResponse) FromType() *string {
+	return p.v.FromType
+}
+
+func (p promptVariableDiffResponse) ToType() *string {
+	return p.v.ToType
+}
+
+type promptFieldDiffResponse struct {
+	f service.PromptFieldDiff
+}
+
+func (p promptFieldDiffResponse) Field() string {
+	return p.f.Field
+}
+
+func (p promptFieldDiffResponse) From() string {
+	return p.f.From
+}
+
+func (p promptFieldDiffResponse) To() string {
+	return p.f.To
+}
@@ -7,25 +7,34 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/PromptPal/PromptPal/ent"
 	"github.com/PromptPal/PromptPal/ent/prompt"
-	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/ent/promptlabel"
 	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
 	"github.com/PromptPal/PromptPal/service"
 	"github.com/go-redis/cache/v9"
+	"github.com/sirupsen/logrus"
 )
 
 type createPromptData struct {
 	ProjectID   int32
 	Name        string
 	Description string
-	TokenCount  int32
+	// ignored, the count is computed from the rows
+	TokenCount  *int32
 	Debug       *bool
 	Enabled     *bool
 	Prompts     []dbSchema.PromptRow
 	Variables   []dbSchema.PromptVariable
 	PublicLevel prompt.PublicLevel
 
-	ProviderId int32
+	ProviderId          int32
+	FallbackProviderIds *[]int32
+	FallbackRules       *fallbackRulesInput
+	ModelParameters     *modelParametersInput
+	OutputSchema        *outputSchemaInput
+	Tools               *[]promptToolInput
+	ToolChoice          *string
 }
 
 type createPromptArgs struct {
@@ -45,6 +54,9 @@ func (q QueryResolver) CreatePrompt(ctx context.Context, args createPromptArgs)
 	if !hasPermission {
 		return promptResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to create prompt"))
 	}
+	if err := service.ValidatePromptTemplates(payload.Prompts); err != nil {
+		return promptResponse{}, NewGraphQLHttpError(http.StatusBadRequest, err)
+	}
 
 	stat := service.
 		EntClient.
@@ -57,12 +69,69 @@ func (q QueryResolver) CreatePrompt(ctx context.Context, args createPromptArgs)
 		SetPrompts(payload.Prompts).
 		SetVariables(payload.Variables).
 		SetPublicLevel(payload.PublicLevel).
-		SetTokenCount(int(payload.TokenCount)).
 		SetNillableDebug(payload.Debug).
-		SetNillableEnabled(payload.Enabled)
+		SetNillableEnabled(payload.Enabled).
+		SetVersion(1)
 
 	stat.SetProviderID(int(payload.ProviderId))
 
+	if payload.FallbackProviderIds != nil {
+		ids, err := toFallbackProviderIds(ctx, *payload.FallbackProviderIds)
+		if err != nil {
+			return promptResponse{}, err
+		}
+		stat.SetFallbackProviderIds(ids)
+	}
+	if payload.FallbackRules != nil {
+		rules, err := payload.FallbackRules.toRules()
+		if err != nil {
+			return promptResponse{}, err
+		}
+		stat.SetFallbackRules(rules)
+	}
+	if payload.ModelParameters != nil {
+		stat.SetModelParameters(payload.ModelParameters.toParameters())
+	}
+	if payload.OutputSchema != nil {
+		output, err := payload.OutputSchema.toOutputSchema()
+		if err != nil {
+			return promptResponse{}, err
+		}
+		stat.SetOutputSchema(output)
+	}
+	if payload.Tools != nil {
+		toolChoice := ""
+		if payload.ToolChoice != nil {
+			toolChoice = *payload.ToolChoice
+		}
+		tools, err := toPromptTools(*payload.Tools, toolChoice)
+		if err != nil {
+			return promptResponse{}, err
+		}
+		stat.SetTools(tools).SetToolChoice(toolChoice)
+	} else if payload.ToolChoice != nil {
+		if err := service.ValidateToolChoice(nil, *payload.ToolChoice); err != nil {
+			return promptResponse{}, NewGraphQLHttpError(http.StatusBadRequest, err)
+		}
+		stat.SetToolChoice(*payload.ToolChoice)
+	}
+
+	// the count is computed on the provider the prompt runs on
+	pt := ent.Prompt{
+		ProjectId:  projectID,
+		ProviderId: int(payload.ProviderId),
+		Prompts:    payload.Prompts,
+		Variables:  payload.Variables,
+	}
+	if payload.ModelParameters != nil {
+		pt.ModelParameters = payload.ModelParameters.toParameters()
+	}
+	tokenCount, err := service.CountPromptTokens(ctx, pt)
+	if err != nil {
+		return promptResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	stat.SetTokenCount(tokenCount)
+
 	p, err := stat.Save(ctx)
 
 	if err != nil {
@@ -112,7 +181,26 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 		err = NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to update prompt"))
 		return
 	}
+	if exp := service.ValidatePromptTemplates(payload.Prompts); exp != nil {
+		err = NewGraphQLHttpError(http.StatusBadRequest, exp)
+		return
+	}
 	
+	pt := *oldPrompt
+	pt.Prompts = payload.Prompts
+	pt.Variables = payload.Variables
+	if payload.ProviderId > 0 {
+		pt.ProviderId = int(payload.ProviderId)
+	}
+	if payload.ModelParameters != nil {
+		pt.ModelParameters = payload.ModelParameters.toParameters()
+	}
+	tokenCount, err := service.CountPromptTokens(ctx, pt)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
 	tx, err := service.EntClient.Tx(ctx)
 
 	if err != nil {
@@ -122,22 +210,13 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 
 	// We already have oldPrompt, so no need to get it again
 
-	snapshotData := schema.PromptComplete{
-		Name:        oldPrompt.Name,
-		Enabled:     oldPrompt.Enabled,
-		Debug:       oldPrompt.Debug,
-		Description: oldPrompt.Description,
-		TokenCount:  oldPrompt.TokenCount,
-		Prompts:     oldPrompt.Prompts,
-		Variables:   oldPrompt.Variables,
-		PublicLevel: oldPrompt.PublicLevel.String(),
-	}
-
+	// the previous version is kept as it was, the updated prompt is the next one
 	err = tx.History.
 		Create().
 		SetModifierID(ctxValue.UserID).
 		SetPromptID(int(args.ID)).
-		SetSnapshot(snapshotData).
+		SetSnapshot(service.PromptSnapshot(*oldPrompt)).
+		SetVersion(oldPrompt.Version).
 		Exec(ctx)
 
 	if err != nil {
@@ -147,8 +226,11 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 	}
 
 	updater := tx.Prompt.UpdateOneID(int(args.ID)).
+		// another update took the version in the meantime
+		Where(prompt.Version(oldPrompt.Version)).
+		SetVersion(oldPrompt.Version + 1).
 		SetDescription(payload.Description).
-		SetTokenCount(int(payload.TokenCount)).
+		SetTokenCount(tokenCount).
 		SetPrompts(payload.Prompts).
 		SetVariables(payload.Variables).
 		SetPublicLevel(payload.PublicLevel)
@@ -159,6 +241,59 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 		updater = updater.SetProviderID(int(args.Data.ProviderId))
 	}
 
+	if args.Data.FallbackProviderIds != nil {
+		ids, exp := toFallbackProviderIds(ctx, *args.Data.FallbackProviderIds)
+		if exp != nil {
+			tx.Rollback()
+			err = exp
+			return
+		}
+		updater = updater.SetFallbackProviderIds(ids)
+	}
+	if args.Data.FallbackRules != nil {
+		rules, exp := args.Data.FallbackRules.toRules()
+		if exp != nil {
+			tx.Rollback()
+			err = exp
+			return
+		}
+		updater = updater.SetFallbackRules(rules)
+	}
+	if args.Data.ModelParameters != nil {
+		updater = updater.SetModelParameters(args.Data.ModelParameters.toParameters())
+	}
+	if args.Data.OutputSchema != nil {
+		output, exp := args.Data.OutputSchema.toOutputSchema()
+		if exp != nil {
+			tx.Rollback()
+			err = exp
+			return
+		}
+		updater = updater.SetOutputSchema(output)
+	}
+	if args.Data.Tools != nil || args.Data.ToolChoice != nil {
+		// the unchanged one is validated along, the choice may refer to a removed tool
+		tools, toolChoice := oldPrompt.Tools, oldPrompt.ToolChoice
+		if args.Data.ToolChoice != nil {
+			toolChoice = *args.Data.ToolChoice
+		}
+		if args.Data.Tools != nil {
+			inputs, exp := toPromptTools(*args.Data.Tools, "")
+			if exp != nil {
+				tx.Rollback()
+				err = exp
+				return
+			}
+			tools = inputs
+		}
+		if exp := service.ValidateToolChoice(tools, toolChoice); exp != nil {
+			tx.Rollback()
+			err = NewGraphQLHttpError(http.StatusBadRequest, exp)
+			return
+		}
+		updater = updater.SetTools(tools).SetToolChoice(toolChoice)
+	}
+
 	if args.Data.Enabled != nil {
 		updater = updater.SetEnabled(*args.Data.Enabled)
 	}
@@ -170,6 +305,10 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 
 	if err != nil {
 		tx.Rollback()
+		if ent.IsNotFound(err) {
+			err = NewGraphQLHttpError(http.StatusConflict, errors.New("the prompt was updated by someone else, reload it and try again"))
+			return
+		}
 		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
 		return
 	}
@@ -193,6 +332,10 @@ func (q QueryResolver) UpdatePrompt(ctx context.Context, args updatePromptArgs)
 		Value: *updatedPrompt,
 		TTL:   time.Hour * 24,
 	})
+	// the labels keep their versions, the settings which are not versioned changed
+	if exp := service.ClearPromptLabelsCache(ctx, hid, updatedPrompt.ID); exp != nil {
+		logrus.Warnln("prompt labels", exp)
+	}
 	result.prompt = updatedPrompt
 	return
 }
@@ -220,6 +363,14 @@ func (q QueryResolver) DeletePrompt(ctx context.Context, args deletePromptArgs)
 		return false, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to delete prompt"))
 	}
 	
+	if hid, exp := hashidService.Encode(prompt.ID); exp == nil {
+		service.ClearPromptLabelsCache(ctx, hid, prompt.ID)
+	}
+	_, err = service.EntClient.PromptLabel.Delete().Where(promptlabel.PromptId(prompt.ID)).Exec(ctx)
+	if err != nil {
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
 	// Delete the prompt
 	err = service.EntClient.Prompt.DeleteOneID(int(args.ID)).Exec(ctx)
 	if err != nil {
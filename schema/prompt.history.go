@@ -71,6 +71,10 @@ func (p promptHistory) ID() int32 {
 	return int32(p.snapshot.ID)
 }
 
+func (p promptHistory) Version() int32 {
+	return int32(p.snapshot.Version)
+}
+
 func (p promptHistory) Name() string {
 	return p.snapshot.Snapshot.Name
 }
@@ -99,6 +103,22 @@ func (p promptHistory) Variables() []promptVariableResponse {
 	return result
 }
 
+func (p promptHistory) ModelParameters() *modelParametersResponse {
+	return newModelParametersResponse(p.snapshot.Snapshot.ModelParameters)
+}
+
+func (p promptHistory) OutputSchema() *outputSchemaResponse {
+	return newOutputSchemaResponse(p.snapshot.Snapshot.OutputSchema)
+}
+
+func (p promptHistory) Tools() []promptToolResponse {
+	return newPromptToolsResponse(p.snapshot.Snapshot.Tools)
+}
+
+func (p promptHistory) ToolChoice() string {
+	return p.snapshot.Snapshot.ToolChoice
+}
+
 func (p promptHistory) ModifiedBy(ctx context.Context) (userResponse, error) {
 	uid := p.snapshot.ModifierId
 	u, err := service.EntClient.User.Get(ctx, uid)
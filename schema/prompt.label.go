@@ -0,0 +1,332 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/activity"
+	"github.com/PromptPal/PromptPal/ent/promptlabel"
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type setPromptLabelArgs struct {
+	PromptId int32
+	Name     string
+	Version  int32
+}
+
+type deletePromptLabelArgs struct {
+	PromptId int32
+	Name     string
+}
+
+// getEditablePrompt loads the prompt of a label mutation and checks the user may edit it
+func getEditablePrompt(ctx context.Context, promptID int32) (*ent.Prompt, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	p, err := service.EntClient.Prompt.Get(ctx, int(promptID))
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusNotFound, err)
+	}
+
+	projectID := p.ProjectId
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermPromptEdit)
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return nil, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to change prompt labels"))
+	}
+	return p, nil
+}
+
+// SetPromptLabel points the label at the version, to promote a version or roll it back
+func (q QueryResolver) SetPromptLabel(ctx context.Context, args setPromptLabelArgs) (result promptLabelResponse, err error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	p, err := getEditablePrompt(ctx, args.PromptId)
+	if err != nil {
+		return
+	}
+	if exp := service.ValidatePromptLabel(args.Name); exp != nil {
+		err = NewGraphQLHttpError(http.StatusBadRequest, exp)
+		return
+	}
+	if args.Version <= 0 {
+		err = NewGraphQLHttpError(http.StatusBadRequest, service.ErrorInvalidPromptVersion)
+		return
+	}
+	if _, exp := service.GetPromptVersion(ctx, *p, int(args.Version)); exp != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(exp, service.ErrorPromptVersionNotFound) {
+			status = http.StatusNotFound
+		}
+		err = NewGraphQLHttpError(status, exp)
+		return
+	}
+
+	tx, err := service.EntClient.Tx(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	previous, err := tx.PromptLabel.
+		Query().
+		Where(promptlabel.PromptId(p.ID), promptlabel.Name(args.Name)).
+		Only(ctx)
+	if err != nil && !ent.IsNotFound(err) {
+		tx.Rollback()
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	change := dbSchema.PromptLabelChange{
+		Label:   args.Name,
+		Version: int(args.Version),
+	}
+	var label *ent.PromptLabel
+	if previous != nil {
+		if previous.Version == int(args.Version) {
+			tx.Rollback()
+			result.l = previous
+			err = nil
+			return
+		}
+		change.PreviousVersion = previous.Version
+		label, err = tx.PromptLabel.
+			UpdateOne(previous).
+			SetVersion(int(args.Version)).
+			SetModifierID(ctxValue.UserID).
+			Save(ctx)
+	} else {
+		label, err = tx.PromptLabel.
+			Create().
+			SetPromptID(p.ID).
+			SetName(args.Name).
+			SetVersion(int(args.Version)).
+			SetModifierID(ctxValue.UserID).
+			Save(ctx)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err = commitPromptLabelChange(ctx, tx, p, ctxValue.UserID, change); err != nil {
+		return
+	}
+	result.l = label
+	return
+}
+
+// DeletePromptLabel removes the label, the runs with it fail until it is set again
+func (q QueryResolver) DeletePromptLabel(ctx context.Context, args deletePromptLabelArgs) (bool, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	p, err := getEditablePrompt(ctx, args.PromptId)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := service.EntClient.Tx(ctx)
+	if err != nil {
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	label, err := tx.PromptLabel.
+		Query().
+		Where(promptlabel.PromptId(p.ID), promptlabel.Name(args.Name)).
+		Only(ctx)
+	if err != nil {
+		tx.Rollback()
+		if ent.IsNotFound(err) {
+			return false, NewGraphQLHttpError(http.StatusNotFound, service.ErrorPromptLabelNotFound)
+		}
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if err := tx.PromptLabel.DeleteOne(label).Exec(ctx); err != nil {
+		tx.Rollback()
+		return false, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	err = commitPromptLabelChange(ctx, tx, p, ctxValue.UserID, dbSchema.PromptLabelChange{
+		Label:           args.Name,
+		PreviousVersion: label.Version,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// commitPromptLabelChange audits the change with the label, then evicts the
+// cached prompt of the label and notifies the webhooks of the project
+func commitPromptLabelChange(ctx context.Context, tx *ent.Tx, p *ent.Prompt, userID int, change dbSchema.PromptLabelChange) error {
+	err := tx.Activity.
+		Create().
+		SetEvent(service.ActivityPromptLabelChanged).
+		SetPromptId(p.ID).
+		SetLabelChange(&change).
+		SetProjectID(p.ProjectId).
+		SetUserID(userID).
+		Exec(ctx)
+	if err != nil {
+		tx.Rollback()
+		return NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+
+	hid, err := hashidService.Encode(p.ID)
+	if err != nil {
+		return NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	service.Cache.Delete(ctx, service.PromptCacheKey(hid, change.Label))
+
+	now := time.Now()
+	go service.TriggerPromptLabelWebhooks(context.Background(), service.PromptLabelWebhookPayload{
+		ProjectID:       p.ProjectId,
+		PromptID:        p.ID,
+		Label:           change.Label,
+		PreviousVersion: change.PreviousVersion,
+		Version:         change.Version,
+		ChangedBy:       userID,
+		Timestamp:       now.Format(time.RFC3339),
+	})
+	return nil
+}
+
+func (p promptResponse) Labels(ctx context.Context) ([]promptLabelResponse, error) {
+	labels, err := service.EntClient.PromptLabel.
+		Query().
+		Where(promptlabel.PromptId(p.prompt.ID)).
+		Order(ent.Asc(promptlabel.FieldName)).
+		All(ctx)
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	result := make([]promptLabelResponse, len(labels))
+	for i, l := range labels {
+		result[i] = promptLabelResponse{l: l}
+	}
+	return result, nil
+}
+
+func (p promptResponse) LabelChanges(ctx context.Context) (res promptLabelChangeListResponse, err error) {
+	res.stat = service.EntClient.Activity.
+		Query().
+		Where(
+			activity.PromptId(p.prompt.ID),
+			activity.Event(service.ActivityPromptLabelChanged),
+		).
+		Order(ent.Desc(activity.FieldID))
+	res.pagination = paginationInput{
+		Offset: 0,
+		Limit:  20,
+	}
+	return
+}
+
+type promptLabelResponse struct {
+	l *ent.PromptLabel
+}
+
+func (p promptLabelResponse) Name() string {
+	return p.l.Name
+}
+
+func (p promptLabelResponse) Version() int32 {
+	return int32(p.l.Version)
+}
+
+func (p promptLabelResponse) ModifiedBy(ctx context.Context) (userResponse, error) {
+	u, err := service.EntClient.User.Get(ctx, p.l.ModifierId)
+	if err != nil {
+		return userResponse{}, err
+	}
+	return userResponse{
+		u: u,
+	}, nil
+}
+
+func (p promptLabelResponse) CreatedAt() string {
+	return p.l.CreateTime.Format(time.RFC3339)
+}
+
+func (p promptLabelResponse) UpdatedAt() string {
+	return p.l.UpdateTime.Format(time.RFC3339)
+}
+
+type promptLabelChangeListResponse struct {
+	stat       *ent.ActivityQuery
+	pagination paginationInput
+}
+
+func (p promptLabelChangeListResponse) Count(ctx context.Context) (int32, error) {
+	count, err := p.stat.Clone().Count(ctx)
+	if err != nil {
+		return 0, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return int32(count), nil
+}
+
+func (p promptLabelChangeListResponse) Edges(ctx context.Context) (res []promptLabelChangeResponse, err error) {
+	activities, err := p.stat.
+		Clone().
+		Limit(int(p.pagination.Limit)).
+		Offset(int(p.pagination.Offset)).
+		All(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	for _, a := range activities {
+		res = append(res, promptLabelChangeResponse{a: a})
+	}
+	return
+}
+
+type promptLabelChangeResponse struct {
+	a *ent.Activity
+}
+
+func (p promptLabelChangeResponse) change() dbSchema.PromptLabelChange {
+	if p.a.LabelChange == nil {
+		return dbSchema.PromptLabelChange{}
+	}
+	return *p.a.LabelChange
+}
+
+func (p promptLabelChangeResponse) Label() string {
+	return p.change().Label
+}
+
+func (p promptLabelChangeResponse) PreviousVersion() int32 {
+	return int32(p.change().PreviousVersion)
+}
+
+func (p promptLabelChangeResponse) Version() int32 {
+	return int32(p.change().Version)
+}
+
+func (p promptLabelChangeResponse) ChangedBy(ctx context.Context) (userResponse, error) {
+	u, err := service.EntClient.Activity.QueryUser(p.a).Only(ctx)
+	if err != nil {
+		return userResponse{}, err
+	}
+	return userResponse{
+		u: u,
+	}, nil
+}
+
+func (p promptLabelChangeResponse) CreatedAt() string {
+	return p.a.CreateTime.Format(time.RFC3339)
+}
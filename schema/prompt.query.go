@@ -136,6 +136,10 @@ func (p promptResponse) TokenCount() int32 {
 	return int32(p.prompt.TokenCount)
 }
 
+func (p promptResponse) Version() int32 {
+	return int32(p.prompt.Version)
+}
+
 func (p promptResponse) CreatedAt() string {
 	return p.prompt.CreateTime.Format(time.RFC3339)
 }
@@ -188,11 +192,25 @@ func (p promptRowResponse) Role() string {
 		return "assistant"
 	case "user":
 		return "user"
+	case "tool":
+		return "tool"
 	default:
 		return "unknown"
 	}
 }
 
+func (p promptRowResponse) ToolCalls() []toolCallResponse {
+	result := make([]toolCallResponse, len(p.p.ToolCalls))
+	for i, v := range p.p.ToolCalls {
+		result[i] = toolCallResponse{c: v}
+	}
+	return result
+}
+
+func (p promptRowResponse) ToolCallID() string {
+	return p.p.ToolCallID
+}
+
 type promptVariableResponse struct {
 	p dbSchema.PromptVariable
 }
@@ -252,6 +270,30 @@ func (p promptResponse) Provider(ctx context.Context) (res *providerResponse, er
 	return
 }
 
+func (p promptResponse) FallbackProviders(ctx context.Context) ([]providerResponse, error) {
+	return fallbackProvidersResponse(ctx, p.prompt.FallbackProviderIds)
+}
+
+func (p promptResponse) FallbackRules() *fallbackRulesResponse {
+	return newFallbackRulesResponse(p.prompt.FallbackRules)
+}
+
+func (p promptResponse) ModelParameters() *modelParametersResponse {
+	return newModelParametersResponse(p.prompt.ModelParameters)
+}
+
+func (p promptResponse) OutputSchema() *outputSchemaResponse {
+	return newOutputSchemaResponse(p.prompt.OutputSchema)
+}
+
+func (p promptResponse) Tools() []promptToolResponse {
+	return newPromptToolsResponse(p.prompt.Tools)
+}
+
+func (p promptResponse) ToolChoice() string {
+	return p.prompt.ToolChoice
+}
+
 func (p promptResponse) LatestCalls(ctx context.Context) (res promptCallListResponse) {
 	stat := service.EntClient.PromptCall.Query().
 		Where(
@@ -0,0 +1,284 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/activity"
+	"github.com/PromptPal/PromptPal/ent/prompt"
+	"github.com/PromptPal/PromptPal/ent/provider"
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+	"github.com/go-redis/cache/v9"
+	"github.com/sirupsen/logrus"
+)
+
+const maxRestoreReasonLength = 1000
+
+type restorePromptVersionArgs struct {
+	PromptId  int32
+	HistoryId int32
+	Reason    *string
+}
+
+// RestorePromptVersion applies the snapshot of the history as the next version of the prompt.
+// the current version is kept in the histories like an update does.
+func (q QueryResolver) RestorePromptVersion(ctx context.Context, args restorePromptVersionArgs) (result promptResponse, err error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+
+	current, err := service.EntClient.Prompt.Get(ctx, int(args.PromptId))
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusNotFound, err)
+		return
+	}
+
+	projectID := current.ProjectId
+	hasPermission, err := rbacService.HasPermission(ctx, ctxValue.UserID, &projectID, service.PermPromptEdit)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	if !hasPermission {
+		err = NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to restore prompt"))
+		return
+	}
+
+	reason := ""
+	if args.Reason != nil {
+		reason = *args.Reason
+	}
+	if len(reason) > maxRestoreReasonLength {
+		err = NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("the reason is longer than %d characters", maxRestoreReasonLength))
+		return
+	}
+
+	h, err := service.EntClient.History.Get(ctx, int(args.HistoryId))
+	if err != nil || h.PromptId != current.ID {
+		err = NewGraphQLHttpError(http.StatusNotFound, errors.New("history not found"))
+		return
+	}
+
+	snapshot := h.Snapshot
+	snapshot.Version = h.Version
+	// a snapshot whose provider was deleted since keeps the current providers, like the legacy ones
+	if snapshot.ProviderId > 0 {
+		exists, exp := service.EntClient.Provider.Query().Where(provider.ID(snapshot.ProviderId)).Exist(ctx)
+		if exp != nil {
+			err = NewGraphQLHttpError(http.StatusInternalServerError, exp)
+			return
+		}
+		if !exists {
+			snapshot.ProviderId = 0
+		}
+	}
+	restored := service.ApplyPromptSnapshot(*current, snapshot)
+
+	// the count of the snapshot may be the one of the client or of another tokenizer
+	tokenCount, err := service.CountPromptTokens(ctx, restored)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	tx, err := service.EntClient.Tx(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = tx.History.
+		Create().
+		SetModifierID(ctxValue.UserID).
+		SetPromptID(current.ID).
+		SetSnapshot(service.PromptSnapshot(*current)).
+		SetVersion(current.Version).
+		Exec(ctx)
+	if err != nil {
+		tx.Rollback()
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	updater := tx.Prompt.UpdateOneID(current.ID).
+		// another update took the version in the meantime
+		Where(prompt.Version(current.Version)).
+		SetVersion(current.Version + 1).
+		SetDescription(restored.Description).
+		SetTokenCount(tokenCount).
+		SetPrompts(restored.Prompts).
+		SetVariables(restored.Variables).
+		SetToolChoice(restored.ToolChoice)
+	if restored.ModelParameters != nil {
+		updater = updater.SetModelParameters(restored.ModelParameters)
+	} else {
+		updater = updater.ClearModelParameters()
+	}
+	if restored.OutputSchema != nil {
+		updater = updater.SetOutputSchema(restored.OutputSchema)
+	} else {
+		updater = updater.ClearOutputSchema()
+	}
+	if restored.Tools != nil {
+		updater = updater.SetTools(restored.Tools)
+	} else {
+		updater = updater.ClearTools()
+	}
+	// the snapshots taken before the provider was recorded keep the current providers too
+	if snapshot.ProviderId > 0 {
+		updater = updater.SetProviderID(restored.ProviderId)
+		if restored.FallbackProviderIds != nil {
+			updater = updater.SetFallbackProviderIds(restored.FallbackProviderIds)
+		} else {
+			updater = updater.ClearFallbackProviderIds()
+		}
+		if restored.FallbackRules != nil {
+			updater = updater.SetFallbackRules(restored.FallbackRules)
+		} else {
+			updater = updater.ClearFallbackRules()
+		}
+	}
+
+	updatedPrompt, err := updater.Save(ctx)
+	if err != nil {
+		tx.Rollback()
+		if ent.IsNotFound(err) {
+			err = NewGraphQLHttpError(http.StatusConflict, errors.New("the prompt was updated by someone else, reload it and try again"))
+			return
+		}
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = tx.Activity.
+		Create().
+		SetEvent(service.ActivityPromptVersionRestored).
+		SetPromptId(current.ID).
+		SetRestore(&dbSchema.PromptRestore{
+			HistoryID:   h.ID,
+			FromVersion: h.Version,
+			Version:     updatedPrompt.Version,
+			Reason:      reason,
+		}).
+		SetProjectID(current.ProjectId).
+		SetUserID(ctxValue.UserID).
+		Exec(ctx)
+	if err != nil {
+		tx.Rollback()
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if exp := tx.Commit(); exp != nil {
+		tx.Rollback()
+		err = NewGraphQLHttpError(http.StatusInternalServerError, exp)
+		return
+	}
+
+	hid, err := hashidService.Encode(current.ID)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// refresh cache
+	service.Cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   service.PromptCacheKey(hid, ""),
+		Value: *updatedPrompt,
+		TTL:   time.Hour * 24,
+	})
+	if exp := service.ClearPromptLabelsCache(ctx, hid, current.ID); exp != nil {
+		logrus.Warnln("prompt labels", exp)
+	}
+	result.prompt = updatedPrompt
+	return
+}
+
+func (p promptResponse) Restores(ctx context.Context) (res promptRestoreListResponse, err error) {
+	res.stat = service.EntClient.Activity.
+		Query().
+		Where(
+			activity.PromptId(p.prompt.ID),
+			activity.Event(service.ActivityPromptVersionRestored),
+		).
+		Order(ent.Desc(activity.FieldID))
+	res.pagination = paginationInput{
+		Offset: 0,
+		Limit:  20,
+	}
+	return
+}
+
+type promptRestoreListResponse struct {
+	stat       *ent.ActivityQuery
+	pagination paginationInput
+}
+
+func (p promptRestoreListResponse) Count(ctx context.Context) (int32, error) {
+	count, err := p.stat.Clone().Count(ctx)
+	if err != nil {
+		return 0, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	return int32(count), nil
+}
+
+func (p promptRestoreListResponse) Edges(ctx context.Context) (res []promptRestoreResponse, err error) {
+	activities, err := p.stat.
+		Clone().
+		Limit(int(p.pagination.Limit)).
+		Offset(int(p.pagination.Offset)).
+		All(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	for _, a := range activities {
+		res = append(res, promptRestoreResponse{a: a})
+	}
+	return
+}
+
+type promptRestoreResponse struct {
+	a *ent.Activity
+}
+
+func (p promptRestoreResponse) restore() dbSchema.PromptRestore {
+	if p.a.Restore == nil {
+		return dbSchema.PromptRestore{}
+	}
+	return *p.a.Restore
+}
+
+func (p promptRestoreResponse) HistoryId() int32 {
+	return int32(p.restore().HistoryID)
+}
+
+func (p promptRestoreResponse) FromVersion() int32 {
+	return int32(p.restore().FromVersion)
+}
+
+func (p promptRestoreResponse) Version() int32 {
+	return int32(p.restore().Version)
+}
+
+func (p promptRestoreResponse) Reason() string {
+	return p.restore().Reason
+}
+
+func (p promptRestoreResponse) RestoredBy(ctx context.Context) (userResponse, error) {
+	u, err := service.EntClient.Activity.QueryUser(p.a).Only(ctx)
+	if err != nil {
+		return userResponse{}, err
+	}
+	return userResponse{
+		u: u,
+	}, nil
+}
+
+func (p promptRestoreResponse) CreatedAt() string {
+	return p.a.CreateTime.Format(time.RFC3339)
+}
@@ -0,0 +1,100 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/project"
+	"github.com/PromptPal/PromptPal/ent/prompt"
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type estimateTokensArgs struct {
+	Prompts []dbSchema.PromptRow
+	// a JSON object of the values of the variables
+	Variables  *string
+	ProviderId int32
+}
+
+type tokenEstimateResponse struct {
+	e service.TokenEstimate
+}
+
+// EstimateTokens counts the tokens of the rows on the provider, like the runs do.
+// the user needs to view the prompts of a project the provider serves.
+func (q QueryResolver) EstimateTokens(ctx context.Context, args estimateTokensArgs) (tokenEstimateResponse, error) {
+	ctxValue := ctx.Value(service.GinGraphQLContextKey).(service.GinGraphQLContextType)
+	if ctxValue.UserID == 0 {
+		return tokenEstimateResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("unauthorized"))
+	}
+
+	variables := map[string]string{}
+	if args.Variables != nil && *args.Variables != "" {
+		if err := json.Unmarshal([]byte(*args.Variables), &variables); err != nil {
+			return tokenEstimateResponse{}, NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("invalid variables: %w", err))
+		}
+	}
+
+	p, err := service.EntClient.Provider.Get(ctx, int(args.ProviderId))
+	if err != nil {
+		return tokenEstimateResponse{}, NewGraphQLHttpError(http.StatusNotFound, err)
+	}
+
+	hasPermission, err := canViewProvider(ctx, ctxValue.UserID, p.ID)
+	if err != nil {
+		return tokenEstimateResponse{}, NewGraphQLHttpError(http.StatusInternalServerError, err)
+	}
+	if !hasPermission {
+		return tokenEstimateResponse{}, NewGraphQLHttpError(http.StatusUnauthorized, errors.New("insufficient permissions to use provider"))
+	}
+
+	result := service.EstimatePromptTokens(p, ent.Prompt{Prompts: args.Prompts}, variables)
+	return tokenEstimateResponse{e: result}, nil
+}
+
+// canViewProvider tells if the user is an admin, or may view the prompts of a project
+// the provider is assigned to or which has a prompt running on it
+func canViewProvider(ctx context.Context, userID int, providerID int) (bool, error) {
+	isAdmin, err := rbacService.HasPermission(ctx, userID, nil, service.PermSystemAdmin)
+	if err != nil || isAdmin {
+		return isAdmin, err
+	}
+	projectIDs, err := service.EntClient.Project.
+		Query().
+		Where(project.Or(
+			project.ProviderId(providerID),
+			project.HasPromptsWith(prompt.ProviderId(providerID)),
+		)).
+		IDs(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, projectID := range projectIDs {
+		hasPermission, err := rbacService.HasPermission(ctx, userID, &projectID, service.PermPromptView)
+		if err != nil || hasPermission {
+			return hasPermission, err
+		}
+	}
+	return false, nil
+}
+
+func (t tokenEstimateResponse) Count() int32 {
+	return int32(t.e.Count)
+}
+
+func (t tokenEstimateResponse) Model() string {
+	return t.e.Model
+}
+
+func (t tokenEstimateResponse) ContextWindow() int32 {
+	return int32(t.e.ContextWindow)
+}
+
+func (t tokenEstimateResponse) Exact() bool {
+	return t.e.Exact
+}
@@ -2,12 +2,15 @@ package schema
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/PromptPal/PromptPal/config"
 	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/activity"
 	"github.com/PromptPal/PromptPal/ent/history"
 	"github.com/PromptPal/PromptPal/ent/prompt"
+	"github.com/PromptPal/PromptPal/ent/promptlabel"
 	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
 	"github.com/PromptPal/PromptPal/service"
 	"github.com/PromptPal/PromptPal/utils"
@@ -98,7 +101,6 @@ func (s *promptTestSuite) TestCreatePrompt() {
 			ProjectID:   int32(s.pjID),
 			Name:        "test-prompt",
 			Description: "test-prompt description",
-			TokenCount:  1,
 			Debug:       nil,
 			Enabled:     nil,
 			Prompts: []dbSchema.PromptRow{
@@ -122,11 +124,37 @@ func (s *promptTestSuite) TestCreatePrompt() {
 
 	assert.Equal(s.T(), "test-prompt", result.Name())
 	assert.Equal(s.T(), "test-prompt description", result.Description())
-	assert.EqualValues(s.T(), 1, result.TokenCount())
+	assert.EqualValues(s.T(), 14, result.TokenCount())
+	assert.EqualValues(s.T(), 1, result.Version())
 	assert.NotEmpty(s.T(), result.ID())
 	s.promptID = int(result.ID())
 }
 
+func (s *promptTestSuite) TestCreatePromptFallbackProviders() {
+	q := QueryResolver{}
+
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	// a provider which does not exist would only be skipped by the runs
+	fallbackIDs := []int32{int32(s.providerID), 1 << 30}
+	_, err := q.CreatePrompt(ctx, createPromptArgs{
+		Data: createPromptData{
+			ProjectID: int32(s.pjID),
+			Name:      "test-prompt-fallback",
+			Prompts: []dbSchema.PromptRow{
+				{Prompt: "hello", Role: "system"},
+			},
+			Variables:           []dbSchema.PromptVariable{},
+			PublicLevel:         prompt.PublicLevelPublic,
+			ProviderId:          int32(s.providerID),
+			FallbackProviderIds: &fallbackIDs,
+		},
+	})
+	assert.EqualError(s.T(), err, fmt.Sprintf("[400]: fallback provider %d not found", 1<<30))
+}
+
 func (s *promptTestSuite) TestListPrompt() {
 	q := QueryResolver{}
 	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
@@ -179,7 +207,6 @@ func (s *promptTestSuite) TestUpdatePrompt() {
 			ProjectID:   int32(s.pjID),
 			Name:        "test-prompt-podcast-AsyncTalk",
 			Description: "welcome to listen the podcast: `AsyncTalk`",
-			TokenCount:  9231,
 			Enabled:     &truthy,
 			Debug:       &truthy,
 			PublicLevel: prompt.PublicLevelPrivate,
@@ -202,7 +229,8 @@ func (s *promptTestSuite) TestUpdatePrompt() {
 	assert.True(s.T(), result.Debug())
 	assert.True(s.T(), result.Enabled())
 	assert.EqualValues(s.T(), "test-prompt", result.Name())
-	assert.EqualValues(s.T(), 9231, result.TokenCount())
+	assert.EqualValues(s.T(), 24, result.TokenCount())
+	assert.EqualValues(s.T(), 2, result.Version())
 	assert.EqualValues(s.T(), s.promptID, result.ID())
 	assert.EqualValues(s.T(), "private", result.PublicLevel())
 
@@ -232,6 +260,7 @@ func (s *promptTestSuite) TestUpdatePrompt() {
 	hsEdge := hsEdges[0]
 	assert.GreaterOrEqual(s.T(), hsEdge.ID(), int32(1))
 	assert.EqualValues(s.T(), "test-prompt", hsEdge.Name())
+	assert.EqualValues(s.T(), 1, hsEdge.Version())
 	assert.NotEmpty(s.T(), hsEdge.CreatedAt())
 	assert.NotEmpty(s.T(), hsEdge.UpdatedAt())
 	// todo: make sure the history is same as the original one
@@ -249,9 +278,256 @@ func (s *promptTestSuite) TestUpdatePrompt() {
 	modifier, err := hsEdge.ModifiedBy(ctx)
 	assert.Nil(s.T(), err)
 	assert.EqualValues(s.T(), s.user.ID, modifier.ID())
+
+	// the first version runs as it was created
+	pinned, err := service.GetPromptVersion(ctx, *result.prompt, 1)
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), 1, pinned.Version)
+	assert.Equal(s.T(), "a-simple prompt {{ var1 }}", pinned.Prompts[0].Prompt)
+	assert.Equal(s.T(), "var1", pinned.Variables[0].Name)
+	assert.Equal(s.T(), s.providerID, pinned.ProviderId)
+}
+
+// runs after TestUpdatePrompt, the prompt has two versions
+func (s *promptTestSuite) TestUpdatePromptDiff() {
+	q := QueryResolver{}
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	pt, err := q.Prompt(ctx, promptArgs{ID: int32(s.promptID)})
+	assert.Nil(s.T(), err)
+	hs, err := pt.Histories(ctx)
+	assert.Nil(s.T(), err)
+	hsEdges, err := hs.Edges(ctx)
+	assert.Nil(s.T(), err)
+	historyID := hsEdges[0].ID()
+
+	diff, err := q.PromptDiff(ctx, promptDiffArgs{PromptId: int32(s.promptID), FromHistoryId: &historyID})
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), 1, diff.FromVersion())
+	assert.EqualValues(s.T(), 2, diff.ToVersion())
+
+	rows := diff.Rows()
+	assert.Len(s.T(), rows, 1)
+	assert.Equal(s.T(), "change", rows[0].Op())
+	lines := rows[0].Lines()
+	assert.Len(s.T(), lines, 1)
+	assert.Equal(s.T(), "a-simple prompt {{ var1 }}", *lines[0].From())
+	assert.NotEmpty(s.T(), lines[0].Words())
+
+	variables := diff.Variables()
+	assert.Len(s.T(), variables, 2)
+	assert.Equal(s.T(), "delete", variables[0].Op())
+	assert.Equal(s.T(), "var1", variables[0].Name())
+	assert.Equal(s.T(), "insert", variables[1].Op())
+	assert.Equal(s.T(), "var88", variables[1].Name())
+
+	fields := map[string]string{}
+	for _, f := range diff.Fields() {
+		fields[f.Field()] = f.From() + "->" + f.To()
+	}
+	assert.Equal(s.T(), map[string]string{
+		"description": "test-prompt description->welcome to listen the podcast: `AsyncTalk`",
+		"publicLevel": "public->private",
+		"debug":       "false->true",
+	}, fields)
+
+	// the same sides have no changes
+	diff, err = q.PromptDiff(ctx, promptDiffArgs{PromptId: int32(s.promptID)})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "equal", diff.Rows()[0].Op())
+	assert.Empty(s.T(), diff.Fields())
+
+	missing := int32(0)
+	_, err = q.PromptDiff(ctx, promptDiffArgs{PromptId: int32(s.promptID), ToHistoryId: &missing})
+	assert.NotNil(s.T(), err)
+}
+
+func (s *promptTestSuite) TestUpdatePromptLabels() {
+	q := QueryResolver{}
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	_, err := q.SetPromptLabel(ctx, setPromptLabelArgs{PromptId: int32(s.promptID), Name: "Production", Version: 1})
+	assert.NotNil(s.T(), err)
+	_, err = q.SetPromptLabel(ctx, setPromptLabelArgs{PromptId: int32(s.promptID), Name: "production", Version: 3})
+	assert.NotNil(s.T(), err)
+
+	label, err := q.SetPromptLabel(ctx, setPromptLabelArgs{PromptId: int32(s.promptID), Name: "production", Version: 2})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "production", label.Name())
+	assert.EqualValues(s.T(), 2, label.Version())
+
+	// roll back
+	label, err = q.SetPromptLabel(ctx, setPromptLabelArgs{PromptId: int32(s.promptID), Name: "production", Version: 1})
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), 1, label.Version())
+	version, err := service.ResolvePromptLabel(ctx, s.promptID, "production")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, version)
+
+	_, err = q.SetPromptLabel(ctx, setPromptLabelArgs{PromptId: int32(s.promptID), Name: "staging", Version: 2})
+	assert.Nil(s.T(), err)
+
+	pt, err := q.Prompt(ctx, promptArgs{ID: int32(s.promptID)})
+	assert.Nil(s.T(), err)
+	labels, err := pt.Labels(ctx)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), labels, 2)
+	assert.Equal(s.T(), "production", labels[0].Name())
+	assert.Equal(s.T(), "staging", labels[1].Name())
+
+	ok, err := q.DeletePromptLabel(ctx, deletePromptLabelArgs{PromptId: int32(s.promptID), Name: "staging"})
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), ok)
+	_, err = service.ResolvePromptLabel(ctx, s.promptID, "staging")
+	assert.ErrorIs(s.T(), err, service.ErrorPromptLabelNotFound)
+
+	// the changes are audited, newest first
+	changes, err := pt.LabelChanges(ctx)
+	assert.Nil(s.T(), err)
+	count, err := changes.Count(ctx)
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), 4, count)
+	edges, err := changes.Edges(ctx)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "staging", edges[0].Label())
+	assert.EqualValues(s.T(), 2, edges[0].PreviousVersion())
+	assert.EqualValues(s.T(), 0, edges[0].Version())
+	assert.Equal(s.T(), "production", edges[2].Label())
+	assert.EqualValues(s.T(), 2, edges[2].PreviousVersion())
+	assert.EqualValues(s.T(), 1, edges[2].Version())
+	changedBy, err := edges[2].ChangedBy(ctx)
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), s.user.ID, changedBy.ID())
+}
+
+// runs after TestUpdatePromptLabels, production points at the first version
+func (s *promptTestSuite) TestUpdatePromptRestore() {
+	q := QueryResolver{}
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	pt, err := q.Prompt(ctx, promptArgs{ID: int32(s.promptID)})
+	assert.Nil(s.T(), err)
+	hs, err := pt.Histories(ctx)
+	assert.Nil(s.T(), err)
+	hsEdges, err := hs.Edges(ctx)
+	assert.Nil(s.T(), err)
+	first := hsEdges[len(hsEdges)-1]
+	assert.EqualValues(s.T(), 1, first.Version())
+
+	_, err = q.RestorePromptVersion(ctx, restorePromptVersionArgs{PromptId: int32(s.promptID), HistoryId: 0})
+	assert.NotNil(s.T(), err)
+
+	reason := "the podcast prompt is too long"
+	result, err := q.RestorePromptVersion(ctx, restorePromptVersionArgs{
+		PromptId:  int32(s.promptID),
+		HistoryId: first.ID(),
+		Reason:    &reason,
+	})
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), 3, result.Version())
+	assert.Equal(s.T(), "a-simple prompt {{ var1 }}", result.Prompts()[0].Prompt())
+	assert.Equal(s.T(), "var1", result.Variables()[0].Name())
+	assert.EqualValues(s.T(), 14, result.TokenCount())
+	// not versioned
+	assert.EqualValues(s.T(), "private", result.PublicLevel())
+
+	// the second version is kept
+	pinned, err := service.GetPromptVersion(ctx, *result.prompt, 2)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "var88", pinned.Variables[0].Name)
+
+	restores, err := result.Restores(ctx)
+	assert.Nil(s.T(), err)
+	edges, err := restores.Edges(ctx)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), edges, 1)
+	assert.Equal(s.T(), first.ID(), edges[0].HistoryId())
+	assert.EqualValues(s.T(), 1, edges[0].FromVersion())
+	assert.EqualValues(s.T(), 3, edges[0].Version())
+	assert.Equal(s.T(), reason, edges[0].Reason())
+	restoredBy, err := edges[0].RestoredBy(ctx)
+	assert.Nil(s.T(), err)
+	assert.EqualValues(s.T(), s.user.ID, restoredBy.ID())
+}
+
+func (s *promptTestSuite) TestUpdatePromptRestoreDeletedProvider() {
+	q := QueryResolver{}
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+
+	removed, err := q.CreateProvider(ctx, createProviderArgs{
+		Data: createProviderData{
+			Name:     "annatarhe_provider_schema_prompt_restore",
+			Source:   "openai",
+			Endpoint: "https://api.openai.com/v1",
+			ApiKey:   "key",
+			Config:   "{}",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	data := createPromptData{
+		ProjectID:   int32(s.pjID),
+		Name:        "test-prompt-restore-provider",
+		PublicLevel: prompt.PublicLevelPrivate,
+		Prompts: []dbSchema.PromptRow{
+			{Prompt: "hello {{ name }}", Role: "system"},
+		},
+		Variables:  []dbSchema.PromptVariable{{Name: "name", Type: "string"}},
+		ProviderId: removed.ID(),
+	}
+	created, err := q.CreatePrompt(ctx, createPromptArgs{Data: data})
+	assert.Nil(s.T(), err)
+
+	data.ProviderId = int32(s.providerID)
+	data.Prompts = []dbSchema.PromptRow{{Prompt: "bye", Role: "system"}}
+	_, err = q.UpdatePrompt(ctx, updatePromptArgs{ID: created.ID(), Data: data})
+	assert.Nil(s.T(), err)
+	assert.Nil(s.T(), service.EntClient.Provider.DeleteOneID(int(removed.ID())).Exec(ctx))
+
+	first, err := service.EntClient.History.Query().Where(history.PromptId(int(created.ID())), history.Version(1)).Only(ctx)
+	assert.Nil(s.T(), err)
+	// the count of the snapshot is not trusted
+	first.Snapshot.TokenCount = 999
+	assert.Nil(s.T(), service.EntClient.History.UpdateOne(first).SetSnapshot(first.Snapshot).Exec(ctx))
+
+	result, err := q.RestorePromptVersion(ctx, restorePromptVersionArgs{PromptId: created.ID(), HistoryId: int32(first.ID)})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "hello {{ name }}", result.Prompts()[0].Prompt())
+	assert.Equal(s.T(), s.providerID, result.prompt.ProviderId)
+	assert.Equal(s.T(), created.TokenCount(), result.TokenCount())
+}
+
+func (s *promptTestSuite) TestEstimateTokens() {
+	q := QueryResolver{}
+	args := estimateTokensArgs{
+		Prompts:    []dbSchema.PromptRow{{Role: "system", Prompt: "Translate to {{lang}}"}},
+		ProviderId: int32(s.providerID),
+	}
+
+	anonymous := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{})
+	_, err := q.EstimateTokens(anonymous, args)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "[401]: unauthorized", err.Error())
+
+	ctx := context.WithValue(context.Background(), service.GinGraphQLContextKey, service.GinGraphQLContextType{
+		UserID: s.user.ID,
+	})
+	result, err := q.EstimateTokens(ctx, args)
+	assert.Nil(s.T(), err)
+	assert.Greater(s.T(), result.Count(), int32(0))
 }
 
 func (s *promptTestSuite) TearDownSuite() {
+	service.EntClient.Activity.Delete().Where(activity.PromptId(s.promptID)).ExecX(context.Background())
+	service.EntClient.PromptLabel.Delete().Where(promptlabel.PromptId(s.promptID)).ExecX(context.Background())
 	service.EntClient.History.Delete().Where(history.PromptId(s.promptID)).ExecX(context.Background())
 	service.EntClient.Prompt.DeleteOneID(s.promptID).ExecX(context.Background())
 	service.EntClient.Project.DeleteOneID(s.pjID).ExecX(context.Background())
@@ -0,0 +1,69 @@
+package schema
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type providerHealthResponse struct {
+	health service.ProviderHealth
+	policy service.RetryPolicy
+}
+
+func (p providerResponse) Health(ctx context.Context) (res providerHealthResponse, err error) {
+	if p.p == nil {
+		res.health.State = service.CircuitClosed
+		return
+	}
+	health, err := service.NewCircuitBreaker(p.p).Health(ctx)
+	if err != nil {
+		err = NewGraphQLHttpError(http.StatusInternalServerError, err)
+		return
+	}
+	res.health = health
+	res.policy = service.NewRetryPolicy(p.p)
+	return
+}
+
+func (p providerHealthResponse) State() string {
+	return string(p.health.State)
+}
+
+func (p providerHealthResponse) Failures() int32 {
+	return int32(p.health.Failures)
+}
+
+func (p providerHealthResponse) OpenUntil() *string {
+	if p.health.OpenUntil == nil {
+		return nil
+	}
+	openUntil := p.health.OpenUntil.Format(time.RFC3339)
+	return &openUntil
+}
+
+func (p providerHealthResponse) RetryPolicy() providerRetryPolicyResponse {
+	return providerRetryPolicyResponse{policy: p.policy}
+}
+
+type providerRetryPolicyResponse struct {
+	policy service.RetryPolicy
+}
+
+func (p providerRetryPolicyResponse) MaxAttempts() int32 {
+	return int32(p.policy.MaxAttempts)
+}
+
+func (p providerRetryPolicyResponse) BackoffMs() int32 {
+	return int32(p.policy.Backoff.Milliseconds())
+}
+
+func (p providerRetryPolicyResponse) MaxBackoffMs() int32 {
+	return int32(p.policy.MaxBackoff.Milliseconds())
+}
+
+func (p providerRetryPolicyResponse) RespectRetryAfter() bool {
+	return p.policy.RespectRetryAfter
+}
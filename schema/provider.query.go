@@ -260,6 +260,21 @@ func (p providerResponse) Headers() string {
 	return string(headers)
 }
 
+// Models lists the models available on the provider, null if its source can't list them
+func (p providerResponse) Models(ctx context.Context) (*[]string, error) {
+	if p.p == nil {
+		return nil, nil
+	}
+	models, err := service.ListProviderModels(ctx, p.p)
+	if errors.Is(err, service.ErrorModelListingNotSupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewGraphQLHttpError(http.StatusBadGateway, err)
+	}
+	return &models, nil
+}
+
 func (p providerResponse) CreatedAt() string {
 	if p.p == nil {
 		return ""
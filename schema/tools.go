@@ -0,0 +1,82 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	dbSchema "github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/PromptPal/PromptPal/service"
+)
+
+type promptToolInput struct {
+	Name        string
+	Description *string
+	Parameters  *string
+}
+
+func toPromptTools(tools []promptToolInput, toolChoice string) ([]dbSchema.PromptTool, error) {
+	result := make([]dbSchema.PromptTool, len(tools))
+	for i, tool := range tools {
+		result[i] = dbSchema.PromptTool{Name: tool.Name}
+		if tool.Description != nil {
+			result[i].Description = *tool.Description
+		}
+		if tool.Parameters != nil && *tool.Parameters != "" {
+			if err := json.Unmarshal([]byte(*tool.Parameters), &result[i].Parameters); err != nil {
+				return nil, NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("invalid parameters of tool %s: %w", tool.Name, err))
+			}
+		}
+	}
+	if err := service.ValidateToolChoice(result, toolChoice); err != nil {
+		return nil, NewGraphQLHttpError(http.StatusBadRequest, err)
+	}
+	return result, nil
+}
+
+type promptToolResponse struct {
+	t dbSchema.PromptTool
+}
+
+func newPromptToolsResponse(tools []dbSchema.PromptTool) []promptToolResponse {
+	result := make([]promptToolResponse, len(tools))
+	for i, tool := range tools {
+		result[i] = promptToolResponse{t: tool}
+	}
+	return result
+}
+
+func (t promptToolResponse) Name() string {
+	return t.t.Name
+}
+
+func (t promptToolResponse) Description() string {
+	return t.t.Description
+}
+
+func (t promptToolResponse) Parameters() string {
+	if len(t.t.Parameters) == 0 {
+		return "{}"
+	}
+	result, err := json.Marshal(t.t.Parameters)
+	if err != nil {
+		return "{}"
+	}
+	return string(result)
+}
+
+type toolCallResponse struct {
+	c dbSchema.ToolCall
+}
+
+func (t toolCallResponse) ID() string {
+	return t.c.ID
+}
+
+func (t toolCallResponse) Name() string {
+	return t.c.Name
+}
+
+func (t toolCallResponse) Arguments() string {
+	return t.c.Arguments
+}
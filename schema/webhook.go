@@ -3,9 +3,12 @@ package schema
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/PromptPal/PromptPal/ent"
@@ -16,8 +19,21 @@ import (
 // Event constants
 const (
 	EventOnPromptFinished = "onPromptFinished"
+	// the project reached the soft limit of its budget
+	EventOnBudgetSoftLimitReached = "onBudgetSoftLimitReached"
+	// a label of a prompt is set or removed
+	EventOnPromptLabelChanged = "onPromptLabelChanged"
 )
 
+var webhookEvents = []string{EventOnPromptFinished, EventOnBudgetSoftLimitReached, EventOnPromptLabelChanged}
+
+func validateWebhookEvent(event string) error {
+	if !slices.Contains(webhookEvents, event) {
+		return NewGraphQLHttpError(http.StatusBadRequest, fmt.Errorf("unsupported event: %s, the events are %s", event, strings.Join(webhookEvents, ", ")))
+	}
+	return nil
+}
+
 // validateWebhookURL validates webhook URL and prevents SSRF attacks
 func validateWebhookURL(urlStr string) error {
 	if urlStr == "" {
@@ -78,8 +94,8 @@ func (q QueryResolver) CreateWebhook(ctx context.Context, args createWebhookArgs
 	}
 
 	// Validate event type
-	if data.Event != EventOnPromptFinished {
-		return webhookResponse{}, NewGraphQLHttpError(http.StatusBadRequest, errors.New("only onPromptFinished event is supported"))
+	if err := validateWebhookEvent(data.Event); err != nil {
+		return webhookResponse{}, err
 	}
 
 	// Validate URL
@@ -142,8 +158,10 @@ func (q QueryResolver) UpdateWebhook(ctx context.Context, args updateWebhookArgs
 	}
 
 	// Validate event type if provided
-	if args.Data.Event != nil && *args.Data.Event != EventOnPromptFinished {
-		return webhookResponse{}, NewGraphQLHttpError(http.StatusBadRequest, errors.New("only onPromptFinished event is supported"))
+	if args.Data.Event != nil {
+		if err := validateWebhookEvent(*args.Data.Event); err != nil {
+			return webhookResponse{}, err
+		}
 	}
 
 	// Validate URL if provided
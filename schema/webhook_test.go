@@ -132,7 +132,7 @@ func (s *webhookTestSuite) TestCreateWebhook_InvalidEvent() {
 	ge, ok := err.(GraphQLHttpError)
 	assert.True(s.T(), ok)
 	assert.Equal(s.T(), http.StatusBadRequest, ge.code)
-	assert.Contains(s.T(), err.Error(), "only onPromptFinished event is supported")
+	assert.Contains(s.T(), err.Error(), "unsupported event: invalidEvent")
 }
 
 func (s *webhookTestSuite) TestCreateWebhook_InvalidURL() {
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const azureDefaultAPIVersion = "2024-10-21"
+
+// azureOpenAIService calls the deployments of Azure OpenAI. the endpoint is the
+// resource url (https://{resource}.openai.azure.com) and the settings are read
+// from `Provider.Config`:
+//
+//	deployment the deployment name, defaults to the model of the request
+//	apiVersion the api-version query parameter, defaults to 2024-10-21
+//	model      the model behind the deployment, used for the cost lookup
+//	azureAD    authenticate with an Entra ID token as `apiKey` instead of an api key
+type azureOpenAIService struct {
+}
+
+func (o azureOpenAIService) getAzureClient(provider *ent.Provider, req openai.ChatCompletionRequest) (*openai.Client, error) {
+	deployment := providerConfigString(provider.Config, "deployment", req.Model)
+	if deployment == "" {
+		return nil, errors.New("azure-openai: deployment is required")
+	}
+
+	cfg := openai.DefaultAzureConfig(provider.ApiKey, provider.Endpoint)
+	cfg.APIVersion = providerConfigString(provider.Config, "apiVersion", azureDefaultAPIVersion)
+	if providerConfigBool(provider.Config, "azureAD", false) {
+		cfg.APIType = openai.APITypeAzureAD
+	}
+	cfg.AzureModelMapperFunc = func(model string) string {
+		return deployment
+	}
+
+	httpClient, err := GetProviderHTTPClient(provider)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HTTPClient = openAIHTTPDoer(httpClient, req)
+	return openai.NewClientWithConfig(cfg), nil
+}
+
+func (o azureOpenAIService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	if err = requireMediaSupport("azure", req, openAISupportsMedia); err != nil {
+		return
+	}
+	client, err := o.getAzureClient(provider, req)
+	if err != nil {
+		return
+	}
+
+	logrus.Debugln("azure:chat: prompts need to send", req.Messages)
+	return client.CreateChatCompletion(ctx, req)
+}
+
+func (o azureOpenAIService) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	if err := requireMediaSupport("azure", req, openAISupportsMedia); err != nil {
+		return nil, err
+	}
+	client, err := o.getAzureClient(provider, req)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugln("azure:stream: prompts need to send", req.Messages)
+	return openAIChatStream(ctx, client, req)
+}
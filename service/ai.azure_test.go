@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAzureTestProvider(endpoint string) *ent.Provider {
+	return &ent.Provider{
+		Source:       "azure-openai",
+		Endpoint:     endpoint,
+		ApiKey:       "azure-key",
+		DefaultModel: "gpt-4o",
+		Config: map[string]interface{}{
+			"deployment": "prod-gpt4o",
+			"apiVersion": "2024-06-01",
+		},
+	}
+}
+
+func TestAzureChat(t *testing.T) {
+	var received openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/deployments/prod-gpt4o/chat/completions", r.URL.Path)
+		assert.Equal(t, "2024-06-01", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "azure-key", r.Header.Get("api-key"))
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(context.Background(), newAzureTestProvider(server.URL), newClaudeTestPrompt(), map[string]string{"lang": "English", "name": "John"}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", res.Choices[0].Message.Content)
+	assert.Equal(t, "Hello John", received.Messages[2].Content)
+}
+
+func TestAzureChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/deployments/prod-gpt4o/chat/completions", r.URL.Path)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"id": "1", "choices": [{"index": 0, "delta": {"role": "assistant", "content": "Hello"}}]}`,
+			`{"id": "1", "choices": [{"index": 0, "delta": {"content": " John"}, "finish_reason": "stop"}]}`,
+			`{"id": "1", "choices": [], "usage": {"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}}`,
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	reply, err := svc.ChatStream(context.Background(), newAzureTestProvider(server.URL), newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	chunks, usage, err := collectMockStream(t, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Hello", " John"}, chunks)
+	assert.Equal(t, 7, usage.TotalTokens)
+}
+
+func TestAzureDeploymentRequired(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	_, err := svc.Chat(context.Background(), &ent.Provider{Source: "azure-openai"}, newClaudeTestPrompt(), nil, "")
+	assert.ErrorContains(t, err, "deployment is required")
+}
+
+func TestAzureCostModel(t *testing.T) {
+	provider := newAzureTestProvider("")
+	assert.Equal(t, "gpt-4o", GetProviderCostModel(provider))
+
+	provider.DefaultModel = "prod-gpt4o"
+	provider.Config["model"] = "gpt-4o-mini"
+	assert.Equal(t, "gpt-4o-mini", GetProviderCostModel(provider))
+
+	assert.Equal(t, "claude-sonnet-4", GetProviderCostModel(newClaudeTestProvider("")))
+}
@@ -0,0 +1,499 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	claudeDefaultEndpoint  = "https://api.anthropic.com"
+	claudeAPIVersion       = "2023-06-01"
+	claudeDefaultMaxTokens = 4096
+)
+
+// claudeService talks to the Anthropic Messages API and translates
+// everything from and into the openai types used across the project.
+type claudeService struct {
+}
+
+// claudeMessage content is a string, or the blocks for tool calls and their results
+type claudeMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type claudeTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type claudeToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type claudeMetadata struct {
+	UserID string `json:"user_id,omitempty"`
+}
+
+type claudeRequest struct {
+	Model         string            `json:"model"`
+	System        string            `json:"system,omitempty"`
+	Messages      []claudeMessage   `json:"messages"`
+	MaxTokens     int               `json:"max_tokens"`
+	Temperature   *float32          `json:"temperature,omitempty"`
+	TopP          *float32          `json:"top_p,omitempty"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+	Metadata      *claudeMetadata   `json:"metadata,omitempty"`
+	Tools         []claudeTool      `json:"tools,omitempty"`
+	ToolChoice    *claudeToolChoice `json:"tool_choice,omitempty"`
+}
+
+type claudeContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+	// image
+	Source *claudeImageSource `json:"source,omitempty"`
+}
+
+type claudeImageSource struct {
+	// base64 or url
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type claudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type claudeResponse struct {
+	ID         string               `json:"id"`
+	Model      string               `json:"model"`
+	Role       string               `json:"role"`
+	Content    []claudeContentBlock `json:"content"`
+	StopReason string               `json:"stop_reason"`
+	Usage      claudeUsage          `json:"usage"`
+}
+
+type claudeErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// claudeStreamEvent covers every event type of the messages stream,
+// only the fields relevant to the event type are filled.
+type claudeStreamEvent struct {
+	Type         string              `json:"type"`
+	Message      *claudeResponse     `json:"message"`
+	Index        int                 `json:"index"`
+	ContentBlock *claudeContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *claudeUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ExplicitTopPOnly tells the models take either the temperature or top_p,
+// the top_p of the provider is not sent
+func (o claudeService) ExplicitTopPOnly() bool {
+	return true
+}
+
+func (o claudeService) buildRequest(req openai.ChatCompletionRequest, stream bool) claudeRequest {
+	result := claudeRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		StopSequences: req.Stop,
+		Stream:        stream,
+	}
+	if result.MaxTokens <= 0 {
+		// max_tokens is required by the messages API
+		result.MaxTokens = claudeDefaultMaxTokens
+	}
+	if req.Temperature > 0 {
+		result.Temperature = &req.Temperature
+	}
+	// only the top_p set by the prompt is in the request, see ExplicitTopPOnly
+	if req.TopP > 0 {
+		result.TopP = &req.TopP
+	}
+	if req.User != "" {
+		result.Metadata = &claudeMetadata{UserID: req.User}
+	}
+
+	systems := []string{}
+	for _, msg := range req.Messages {
+		switch {
+		case msg.Role == openai.ChatMessageRoleSystem:
+			systems = append(systems, messageText(msg))
+		case msg.Role == openai.ChatMessageRoleTool:
+			// the results are sent by the user, consecutive ones in a single message
+			block := claudeContentBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content}
+			last := len(result.Messages) - 1
+			if last >= 0 {
+				if blocks, ok := result.Messages[last].Content.([]claudeContentBlock); ok && result.Messages[last].Role == openai.ChatMessageRoleUser {
+					result.Messages[last].Content = append(blocks, block)
+					continue
+				}
+			}
+			result.Messages = append(result.Messages, claudeMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: []claudeContentBlock{block},
+			})
+		case len(msg.ToolCalls) > 0:
+			blocks := []claudeContentBlock{}
+			if msg.Content != "" {
+				blocks = append(blocks, claudeContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input, _ := json.Marshal(toolArguments(call.Function.Arguments))
+				blocks = append(blocks, claudeContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+			result.Messages = append(result.Messages, claudeMessage{
+				Role:    msg.Role,
+				Content: blocks,
+			})
+		case len(msg.MultiContent) > 0:
+			result.Messages = append(result.Messages, claudeMessage{
+				Role:    msg.Role,
+				Content: claudeMultiContent(msg.MultiContent),
+			})
+		default:
+			result.Messages = append(result.Messages, claudeMessage{
+				Role:    msg.Role,
+				Content: msg.Content,
+			})
+		}
+	}
+	result.System = strings.Join(systems, "\n\n")
+
+	for _, tool := range req.Tools {
+		result.Tools = append(result.Tools, claudeTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: tool.Function.Parameters,
+		})
+	}
+	if len(result.Tools) > 0 && req.ToolChoice != nil {
+		policy, name := toolChoicePolicy(req.ToolChoice)
+		switch {
+		case name != "":
+			result.ToolChoice = &claudeToolChoice{Type: "tool", Name: name}
+		case policy == ToolChoiceRequired:
+			result.ToolChoice = &claudeToolChoice{Type: "any"}
+		default:
+			result.ToolChoice = &claudeToolChoice{Type: policy}
+		}
+	}
+	return result
+}
+
+func (o claudeService) doRequest(ctx context.Context, provider *ent.Provider, payload claudeRequest) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := claudeDefaultEndpoint
+	if provider.Endpoint != "" {
+		endpoint = provider.Endpoint
+	}
+	endpoint = strings.TrimSuffix(strings.TrimSuffix(endpoint, "/"), "/v1")
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", provider.ApiKey)
+	httpReq.Header.Set("anthropic-version", claudeAPIVersion)
+
+	client, err := GetProviderHTTPClient(provider)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, claudeAPIError(resp)
+	}
+	return resp, nil
+}
+
+// claudeAPIError converts the anthropic error body into an openai.APIError so
+// the callers can handle every provider the same way.
+func claudeAPIError(resp *http.Response) error {
+	buf, _ := io.ReadAll(resp.Body)
+	var errResp claudeErrorResponse
+	if err := json.Unmarshal(buf, &errResp); err != nil || errResp.Error.Message == "" {
+		errResp.Error.Message = strings.TrimSpace(string(buf))
+	}
+	return &openai.APIError{
+		Code:           errResp.Error.Type,
+		Type:           errResp.Error.Type,
+		Message:        errResp.Error.Message,
+		HTTPStatus:     resp.Status,
+		HTTPStatusCode: resp.StatusCode,
+	}
+}
+
+func claudeToolCall(id, name, input string) openai.ToolCall {
+	return openai.ToolCall{
+		ID:   id,
+		Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{
+			Name:      name,
+			Arguments: input,
+		},
+	}
+}
+
+func claudeFinishReason(stopReason string) openai.FinishReason {
+	switch stopReason {
+	case "max_tokens":
+		return openai.FinishReasonLength
+	case "tool_use":
+		return openai.FinishReasonToolCalls
+	case "refusal":
+		return openai.FinishReasonContentFilter
+	default:
+		return openai.FinishReasonStop
+	}
+}
+
+// claudeMultiContent converts text and image parts into blocks, claude takes no audio or video
+func claudeMultiContent(parts []openai.ChatMessagePart) []claudeContentBlock {
+	blocks := []claudeContentBlock{}
+	for _, part := range parts {
+		media, ok := mediaFromPart(part)
+		if !ok {
+			blocks = append(blocks, claudeContentBlock{Type: "text", Text: part.Text})
+			continue
+		}
+		source := &claudeImageSource{Type: "url", URL: media.URL}
+		if media.URL == "" {
+			source = &claudeImageSource{
+				Type:      "base64",
+				MediaType: media.MIMEType,
+				Data:      base64.StdEncoding.EncodeToString(media.Data),
+			}
+		}
+		blocks = append(blocks, claudeContentBlock{Type: "image", Source: source})
+	}
+	return blocks
+}
+
+func claudeSupportsMedia(media mediaValue) bool {
+	return media.Type == schema.PromptVariableTypesImage
+}
+
+func (o claudeService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	if err = requireMediaSupport("claude", req, claudeSupportsMedia); err != nil {
+		return
+	}
+	payload := o.buildRequest(req, false)
+	logrus.Debugln("claude:chat: prompts need to send", payload.Messages)
+
+	resp, err := o.doRequest(ctx, provider, payload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var result claudeResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return
+	}
+
+	var content strings.Builder
+	var toolCalls []openai.ToolCall
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, claudeToolCall(block.ID, block.Name, string(block.Input)))
+		default:
+			logrus.Warnln("not a text block in claude api", block.Type)
+		}
+	}
+
+	reply = openai.ChatCompletionResponse{
+		ID:      result.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   result.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Index: 0,
+				Message: openai.ChatCompletionMessage{
+					Role:      openai.ChatMessageRoleAssistant,
+					Content:   content.String(),
+					ToolCalls: toolCalls,
+				},
+				FinishReason: claudeFinishReason(result.StopReason),
+			},
+		},
+		Usage: openai.Usage{
+			PromptTokens:     result.Usage.InputTokens,
+			CompletionTokens: result.Usage.OutputTokens,
+			TotalTokens:      result.Usage.InputTokens + result.Usage.OutputTokens,
+		},
+	}
+	return
+}
+
+func (o claudeService) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	reply = &ChatStreamResponse{
+		Done:    make(chan bool),
+		Err:     make(chan error),
+		Info:    make(chan openai.Usage),
+		Message: make(chan []openai.ChatCompletionChoice),
+	}
+
+	if err := requireMediaSupport("claude", req, claudeSupportsMedia); err != nil {
+		return reply, err
+	}
+	payload := o.buildRequest(req, true)
+	logrus.Debugln("claude:stream: prompts need to send", payload.Messages)
+
+	resp, err := o.doRequest(ctx, provider, payload)
+	if err != nil {
+		return reply, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+
+		usage := openai.Usage{}
+		// tool_use blocks by index, their input arrives in pieces
+		toolCalls := []openai.ToolCall{}
+		toolBlocks := map[int]int{}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+			var event claudeStreamEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				reply.Err <- err
+				return
+			}
+
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					usage.PromptTokens = event.Message.Usage.InputTokens
+				}
+			case "content_block_start":
+				if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+					toolBlocks[event.Index] = len(toolCalls)
+					toolCalls = append(toolCalls, claudeToolCall(event.ContentBlock.ID, event.ContentBlock.Name, ""))
+				}
+			case "content_block_delta":
+				if event.Delta.Type == "input_json_delta" {
+					if i, ok := toolBlocks[event.Index]; ok {
+						toolCalls[i].Function.Arguments += event.Delta.PartialJSON
+					}
+					continue
+				}
+				if event.Delta.Type != "text_delta" {
+					continue
+				}
+				reply.Message <- []openai.ChatCompletionChoice{
+					{
+						Index:        0,
+						FinishReason: openai.FinishReasonStop,
+						Message: openai.ChatCompletionMessage{
+							Role:    openai.ChatMessageRoleAssistant,
+							Content: event.Delta.Text,
+						},
+					},
+				}
+			case "message_delta":
+				if event.Usage != nil {
+					usage.CompletionTokens = event.Usage.OutputTokens
+				}
+			case "error":
+				message := "unknown claude stream error"
+				if event.Error != nil {
+					message = fmt.Sprintf("%s: %s", event.Error.Type, event.Error.Message)
+				}
+				reply.Err <- fmt.Errorf("claude: %s", message)
+				return
+			case "message_stop":
+				if len(toolCalls) > 0 {
+					for i := range toolCalls {
+						if toolCalls[i].Function.Arguments == "" {
+							toolCalls[i].Function.Arguments = "{}"
+						}
+					}
+					reply.Message <- []openai.ChatCompletionChoice{toolCallsChoice(toolCalls)}
+				}
+				usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+				reply.Info <- usage
+				reply.Done <- true
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			reply.Err <- err
+			return
+		}
+		reply.Err <- io.ErrUnexpectedEOF
+	}()
+
+	return reply, nil
+}
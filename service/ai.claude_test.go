@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClaudeTestProvider(endpoint string) *ent.Provider {
+	return &ent.Provider{
+		Source:       "claude",
+		Endpoint:     endpoint,
+		ApiKey:       "test-key",
+		DefaultModel: "claude-sonnet-4",
+		Temperature:  0.5,
+	}
+}
+
+func newClaudeTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{
+			{Role: "system", Prompt: "You are a helpful assistant."},
+			{Role: "system", Prompt: "Reply in {{lang}}."},
+			{Role: "user", Prompt: "Hello {{name}}"},
+		},
+	}
+}
+
+func TestClaudeChat(t *testing.T) {
+	var received claudeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+		assert.Equal(t, claudeAPIVersion, r.Header.Get("anthropic-version"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "msg_01",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-sonnet-4",
+			"content": [{"type": "text", "text": "Bonjour "}, {"type": "text", "text": "John"}],
+			"stop_reason": "max_tokens",
+			"usage": {"input_tokens": 12, "output_tokens": 4}
+		}`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(
+		context.Background(),
+		newClaudeTestProvider(server.URL+"/v1"),
+		newClaudeTestPrompt(),
+		map[string]string{"lang": "French", "name": "John"},
+		"user-1",
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "You are a helpful assistant.\n\nReply in French.", received.System)
+	assert.Equal(t, []claudeMessage{{Role: "user", Content: "Hello John"}}, received.Messages)
+	assert.Equal(t, claudeDefaultMaxTokens, received.MaxTokens)
+	assert.Equal(t, "user-1", received.Metadata.UserID)
+	assert.False(t, received.Stream)
+
+	assert.Equal(t, "msg_01", res.ID)
+	assert.Len(t, res.Choices, 1)
+	assert.Equal(t, "Bonjour John", res.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonLength, res.Choices[0].FinishReason)
+	assert.Equal(t, 12, res.Usage.PromptTokens)
+	assert.Equal(t, 4, res.Usage.CompletionTokens)
+	assert.Equal(t, 16, res.Usage.TotalTokens)
+}
+
+func TestClaudeChatAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"type": "error", "error": {"type": "rate_limit_error", "message": "slow down"}}`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	_, err := svc.Chat(context.Background(), newClaudeTestProvider(server.URL), newClaudeTestPrompt(), nil, "")
+
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.HTTPStatusCode)
+	assert.Equal(t, "rate_limit_error", apiErr.Type)
+	assert.Equal(t, "slow down", apiErr.Message)
+}
+
+func TestClaudeChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var received claudeRequest
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		assert.True(t, received.Stream)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type": "message_start", "message": {"id": "msg_01", "usage": {"input_tokens": 10, "output_tokens": 1}}}`,
+			`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+			`{"type": "ping"}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello"}}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": " John"}}`,
+			`{"type": "content_block_stop", "index": 0}`,
+			`{"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 3}}`,
+			`{"type": "message_stop"}`,
+		}
+		for _, event := range events {
+			var typed struct {
+				Type string `json:"type"`
+			}
+			json.Unmarshal([]byte(event), &typed)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typed.Type, event)
+		}
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	reply, err := svc.ChatStream(
+		context.Background(),
+		newClaudeTestProvider(server.URL),
+		newClaudeTestPrompt(),
+		map[string]string{"lang": "English", "name": "John"},
+		"",
+	)
+	assert.Nil(t, err)
+
+	result := ""
+	var usage openai.Usage
+	for done := false; !done; {
+		select {
+		case msg := <-reply.Message:
+			result += msg[0].Message.Content
+		case usage = <-reply.Info:
+		case err := <-reply.Err:
+			t.Fatal(err)
+		case <-reply.Done:
+			done = true
+		}
+	}
+
+	assert.Equal(t, "Hello John", result)
+	assert.Equal(t, 10, usage.PromptTokens)
+	assert.Equal(t, 3, usage.CompletionTokens)
+	assert.Equal(t, 13, usage.TotalTokens)
+}
+
+func TestClaudeChatSampling(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "msg_01", "role": "assistant", "content": [{"type": "text", "text": "ok"}], "usage": {}}`)
+	}))
+	defer server.Close()
+
+	// the defaults of a new provider
+	p := newClaudeTestProvider(server.URL)
+	p.Temperature = 1
+	p.TopP = 0.9
+	_, err := NewIsomorphicAIService().Chat(context.Background(), p, newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, received["temperature"])
+	assert.NotContains(t, received, "top_p")
+
+	topP := 0.5
+	prompt := newClaudeTestPrompt()
+	prompt.ModelParameters = &schema.ModelParameters{TopP: &topP}
+	_, err = NewIsomorphicAIService().Chat(context.Background(), p, prompt, nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, received["top_p"])
+
+	// the value of the default is sent too once the prompt sets it
+	topP = 0.9
+	_, err = NewIsomorphicAIService().Chat(context.Background(), p, prompt, nil, "")
+	assert.Nil(t, err)
+	assert.InDelta(t, 0.9, received["top_p"], 1e-6)
+
+	// the top_p of the provider always has a value, it is not sent
+	p.TopP = 0.7
+	_, err = NewIsomorphicAIService().Chat(context.Background(), p, newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.NotContains(t, received, "top_p")
+}
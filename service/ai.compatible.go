@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// openAICompatibleService serves every provider which speaks the openai
+// chat completions protocol.
+type openAICompatibleService struct {
+}
+
+func (o openAICompatibleService) getIsomorphicClient(ctx context.Context, provider *ent.Provider, req openai.ChatCompletionRequest) (*openai.Client, error) {
+	cfg := openai.DefaultConfig(provider.ApiKey)
+	if provider.Endpoint != "" {
+		baseUrl, err := url.Parse(provider.Endpoint)
+		if err != nil {
+			logrus.Errorln(err)
+			return nil, err
+		}
+		cfg.BaseURL = baseUrl.String()
+	}
+	httpClient, err := GetProviderHTTPClient(provider)
+	if err != nil {
+		return nil, err
+	}
+	cfg.HTTPClient = openAIHTTPDoer(httpClient, req)
+	client := openai.NewClientWithConfig(cfg)
+	return client, nil
+}
+
+func (o openAICompatibleService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	if err = requireMediaSupport("openai", req, openAISupportsMedia); err != nil {
+		return
+	}
+	client, err := o.getIsomorphicClient(ctx, provider, req)
+	if err != nil {
+		return
+	}
+
+	logrus.Debugln("openai:chat: prompts need to send", req.Messages)
+	return client.CreateChatCompletion(ctx, req)
+}
+
+func (o openAICompatibleService) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	if err := requireMediaSupport("openai", req, openAISupportsMedia); err != nil {
+		return nil, err
+	}
+	client, err := o.getIsomorphicClient(ctx, provider, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.Debugln("openai:stream: prompts need to send", req.Messages)
+	return openAIChatStream(ctx, client, req)
+}
+
+// openAIChatStream streams the reply of every client speaking the openai protocol
+func openAIChatStream(
+	ctx context.Context,
+	client *openai.Client,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	reply = &ChatStreamResponse{
+		Done:    make(chan bool),
+		Err:     make(chan error),
+		Info:    make(chan openai.Usage),
+		Message: make(chan []openai.ChatCompletionChoice),
+	}
+
+	req.StreamOptions = &openai.StreamOptions{
+		IncludeUsage: true,
+	}
+
+	stream, err := client.CreateChatCompletionStream(ctx, req)
+
+	if err != nil {
+		return reply, err
+	}
+
+	go func() {
+		toolCalls := &toolCallsAccumulator{}
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				err = nil
+				// the tool calls are sent once their arguments are complete
+				if choices := toolCalls.choices(); choices != nil {
+					reply.Message <- choices
+				}
+				reply.Done <- true
+				stream.Close()
+				break
+			}
+			if err != nil {
+				reply.Err <- err
+				stream.Close()
+				break
+			}
+
+			if resp.Usage != nil {
+				reply.Info <- *resp.Usage
+			}
+
+			if len(resp.Choices) == 0 {
+				continue
+			}
+
+			temp := make([]openai.ChatCompletionChoice, 0, len(resp.Choices))
+
+			for _, cand := range resp.Choices {
+				if len(cand.Delta.ToolCalls) > 0 {
+					toolCalls.add(cand.Delta.ToolCalls)
+					continue
+				}
+				content := cand.Delta.Content
+				chunk := openai.ChatCompletionChoice{
+					Index:        cand.Index,
+					FinishReason: openai.FinishReasonStop,
+					Message: openai.ChatCompletionMessage{
+						Role:    cand.Delta.Role,
+						Content: content,
+					},
+				}
+				temp = append(temp, chunk)
+			}
+
+			if len(temp) > 0 {
+				reply.Message <- temp
+			}
+		}
+	}()
+	return reply, nil
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/provider"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// error classes of `FallbackRules.On`
+const (
+	FallbackOn5xx       = "5xx"
+	FallbackOnRateLimit = "rateLimit"
+	FallbackOnTimeout   = "timeout"
+	FallbackOnNetwork   = "network"
+)
+
+// ProviderChain is the provider of a prompt followed by its fallback providers
+type ProviderChain struct {
+	Providers []*ent.Provider
+	Rules     schema.FallbackRules
+}
+
+func (o isomorphicAIService) GetProviderChain(ctx context.Context, prompt ent.Prompt) (chain ProviderChain, err error) {
+	primary, err := o.GetProvider(ctx, prompt)
+	if err != nil {
+		return
+	}
+	chain.Providers = []*ent.Provider{primary}
+
+	ids := prompt.FallbackProviderIds
+	if prompt.FallbackRules != nil {
+		chain.Rules = *prompt.FallbackRules
+	}
+	if len(ids) == 0 || prompt.FallbackRules == nil {
+		pj, err := EntClient.Project.Get(ctx, prompt.ProjectId)
+		if err != nil {
+			return chain, err
+		}
+		if len(ids) == 0 {
+			ids = pj.FallbackProviderIds
+		}
+		if prompt.FallbackRules == nil && pj.FallbackRules != nil {
+			chain.Rules = *pj.FallbackRules
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	providers, err := EntClient.Provider.Query().Where(provider.IDIn(ids...), provider.Enabled(true)).All(ctx)
+	if err != nil {
+		return
+	}
+	// keep the order of ids, deleted and disabled providers are just skipped
+	for _, id := range ids {
+		if id == primary.ID {
+			continue
+		}
+		idx := slices.IndexFunc(providers, func(p *ent.Provider) bool {
+			return p.ID == id
+		})
+		if idx < 0 {
+			continue
+		}
+		if slices.Contains(chain.Providers, providers[idx]) {
+			continue
+		}
+		chain.Providers = append(chain.Providers, providers[idx])
+	}
+	return
+}
+
+func fallbackStatusClass(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return FallbackOnRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return FallbackOnTimeout
+	case status >= http.StatusInternalServerError:
+		return FallbackOn5xx
+	}
+	return ""
+}
+
+// fallbackErrorClass tells which error class of the fallback rules the error
+// belongs to. empty if the error should not be retried by another provider
+func fallbackErrorClass(err error) string {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return fallbackStatusClass(apiErr.HTTPStatusCode)
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return fallbackStatusClass(reqErr.HTTPStatusCode)
+	}
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return fallbackStatusClass(googleErr.Code)
+	}
+	var httpCoder interface{ HTTPCode() int }
+	if errors.As(err, &httpCoder) && httpCoder.HTTPCode() > 0 {
+		return fallbackStatusClass(httpCoder.HTTPCode())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FallbackOnTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return FallbackOnTimeout
+		}
+		return FallbackOnNetwork
+	}
+	return ""
+}
+
+func shouldFallback(ctx context.Context, rules schema.FallbackRules, startTime time.Time, err error) bool {
+	// the caller is gone, there is nobody to answer
+	if ctx.Err() != nil {
+		return false
+	}
+	if rules.BudgetMs > 0 && time.Since(startTime) > time.Duration(rules.BudgetMs)*time.Millisecond {
+		return false
+	}
+	class := fallbackErrorClass(err)
+	if class == "" {
+		return false
+	}
+	return len(rules.On) == 0 || slices.Contains(rules.On, class)
+}
+
+func (o isomorphicAIService) attemptContext(ctx context.Context, rules schema.FallbackRules) (context.Context, context.CancelFunc) {
+	if rules.AttemptTimeoutMs <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(rules.AttemptTimeoutMs)*time.Millisecond)
+}
+
+func logFallback(current, next *ent.Provider, err error) {
+	logrus.Warnf(
+		"provider %d(%s) failed, fallback to provider %d(%s): %v",
+		current.ID, current.Source, next.ID, next.Source, err,
+	)
+}
+
+// ChatWithFallback runs the prompt on the providers of the chain in order and
+// returns the reply of the first one answered.
+func (o isomorphicAIService) ChatWithFallback(
+	ctx context.Context,
+	chain ProviderChain,
+	prompt ent.Prompt,
+	variables map[string]string,
+	userId string,
+) (reply openai.ChatCompletionResponse, provider *ent.Provider, err error) {
+	if len(chain.Providers) == 0 {
+		return reply, nil, errors.New("no provider available")
+	}
+	startTime := time.Now()
+	for i, p := range chain.Providers {
+		attemptCtx, cancel := o.attemptContext(ctx, chain.Rules)
+		req := o.buildChatRequest(p, prompt, variables, userId)
+		reply, err = o.chat(attemptCtx, p, req)
+		cancel()
+		if err == nil {
+			return reply, p, nil
+		}
+		if i == len(chain.Providers)-1 || !shouldFallback(ctx, chain.Rules, startTime, err) {
+			return reply, p, err
+		}
+		logFallback(p, chain.Providers[i+1], err)
+	}
+	return
+}
+
+// ChatStreamWithFallback works like ChatWithFallback, but a provider can only
+// be replaced until it sends the first token.
+func (o isomorphicAIService) ChatStreamWithFallback(
+	ctx context.Context,
+	chain ProviderChain,
+	prompt ent.Prompt,
+	variables map[string]string,
+	userId string,
+) (reply *ChatStreamResponse, provider *ent.Provider, err error) {
+	if len(chain.Providers) == 0 {
+		return nil, nil, errors.New("no provider available")
+	}
+	startTime := time.Now()
+	for i, p := range chain.Providers {
+		req := o.buildChatRequest(p, prompt, variables, userId)
+		// nothing to fall back to, hand over the stream as it is
+		if i == len(chain.Providers)-1 {
+			reply, err = o.chatStream(ctx, p, req)
+			return reply, p, err
+		}
+
+		reply, err = o.streamAttempt(ctx, p, req, chain.Rules)
+		if err == nil {
+			return reply, p, nil
+		}
+		if !shouldFallback(ctx, chain.Rules, startTime, err) {
+			return nil, p, err
+		}
+		logFallback(p, chain.Providers[i+1], err)
+	}
+	return
+}
+
+// streamAttempt starts the stream and waits for its first event. the stream
+// is handed over in a new ChatStreamResponse if it started well in time,
+// otherwise the attempt is cancelled and the error is returned.
+func (o isomorphicAIService) streamAttempt(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+	rules schema.FallbackRules,
+) (*ChatStreamResponse, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+
+	// the timeout only covers the time to the first token, the stream itself
+	// may take longer. so the context is cancelled by a timer instead of a deadline
+	timedOut := atomic.Bool{}
+	stopTimer := func() bool { return true }
+	if rules.AttemptTimeoutMs > 0 {
+		timer := time.AfterFunc(time.Duration(rules.AttemptTimeoutMs)*time.Millisecond, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+		stopTimer = timer.Stop
+	}
+	errTimeout := fmt.Errorf("no response in %dms: %w", rules.AttemptTimeoutMs, context.DeadlineExceeded)
+	timeoutErr := func(err error) error {
+		if timedOut.Load() {
+			return errTimeout
+		}
+		return err
+	}
+
+	inner, err := o.chatStream(attemptCtx, provider, req)
+	if err != nil {
+		stopTimer()
+		cancel()
+		return nil, timeoutErr(err)
+	}
+
+	var first func(reply *ChatStreamResponse) bool
+	ended := false
+	select {
+	case msg := <-inner.Message:
+		first = func(reply *ChatStreamResponse) bool {
+			reply.Message <- msg
+			return true
+		}
+	case usage := <-inner.Info:
+		first = func(reply *ChatStreamResponse) bool {
+			reply.Info <- usage
+			return true
+		}
+	case <-inner.Done:
+		ended = true
+		first = func(reply *ChatStreamResponse) bool {
+			reply.Done <- true
+			return false
+		}
+	case err := <-inner.Err:
+		stopTimer()
+		cancel()
+		return nil, timeoutErr(err)
+	case <-attemptCtx.Done():
+		cancel()
+		go drainChatStream(inner)
+		return nil, timeoutErr(attemptCtx.Err())
+	}
+
+	// the timer fired while the first event arrived, the stream is cancelled already
+	if !stopTimer() {
+		cancel()
+		if !ended {
+			go drainChatStream(inner)
+		}
+		return nil, errTimeout
+	}
+
+	reply := &ChatStreamResponse{
+		Done:    make(chan bool),
+		Err:     make(chan error),
+		Info:    make(chan openai.Usage),
+		Message: make(chan []openai.ChatCompletionChoice),
+	}
+	go func() {
+		defer cancel()
+		if !first(reply) {
+			return
+		}
+		for {
+			select {
+			case msg := <-inner.Message:
+				reply.Message <- msg
+			case usage := <-inner.Info:
+				reply.Info <- usage
+			case <-inner.Done:
+				reply.Done <- true
+				return
+			case err := <-inner.Err:
+				reply.Err <- err
+				return
+			}
+		}
+	}()
+	return reply, nil
+}
+
+// drainChatStream reads an abandoned stream until it ends, so the goroutine
+// of the driver is not blocked forever.
+func drainChatStream(stream *ChatStreamResponse) {
+	for {
+		select {
+		case <-stream.Message:
+		case <-stream.Info:
+		case <-stream.Done:
+			return
+		case <-stream.Err:
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFallbackTestProvider(id int, config map[string]interface{}) *ent.Provider {
+	return &ent.Provider{ID: id, Source: "mock", Config: config}
+}
+
+func TestFallbackErrorClass(t *testing.T) {
+	cases := []struct {
+		err   error
+		class string
+	}{
+		{&openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable}, FallbackOn5xx},
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, FallbackOnRateLimit},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, ""},
+		{&openai.RequestError{HTTPStatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}, FallbackOn5xx},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), FallbackOnTimeout},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, FallbackOnNetwork},
+		{errors.New("unknown"), ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.class, fallbackErrorClass(c.err), c.err.Error())
+	}
+}
+
+func TestFallbackChat(t *testing.T) {
+	svc := isomorphicAIService{}
+	chain := ProviderChain{
+		Providers: []*ent.Provider{
+			newFallbackTestProvider(1, map[string]interface{}{"errorStatus": float64(http.StatusServiceUnavailable)}),
+			newFallbackTestProvider(2, map[string]interface{}{"errorStatus": float64(http.StatusTooManyRequests)}),
+			newFallbackTestProvider(3, map[string]interface{}{"reply": "from the third"}),
+		},
+	}
+
+	res, provider, err := svc.ChatWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, provider.ID)
+	assert.Equal(t, "from the third", res.Choices[0].Message.Content)
+}
+
+func TestFallbackChatRules(t *testing.T) {
+	svc := isomorphicAIService{}
+	providers := []*ent.Provider{
+		newFallbackTestProvider(1, map[string]interface{}{"errorStatus": float64(http.StatusServiceUnavailable)}),
+		newFallbackTestProvider(2, map[string]interface{}{"reply": "from the second"}),
+	}
+
+	// the error class is not in the rules
+	chain := ProviderChain{
+		Providers: providers,
+		Rules:     schema.FallbackRules{On: []string{FallbackOnRateLimit}},
+	}
+	_, provider, err := svc.ChatWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.HTTPStatusCode)
+	assert.Equal(t, 1, provider.ID)
+
+	// client errors never fall back
+	providers[0].Config = map[string]interface{}{"errorStatus": float64(http.StatusBadRequest)}
+	_, provider, err = svc.ChatWithFallback(context.Background(), ProviderChain{Providers: providers}, newMockTestPrompt(), nil, "")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, provider.ID)
+}
+
+func TestFallbackChatAttemptTimeout(t *testing.T) {
+	svc := isomorphicAIService{}
+	chain := ProviderChain{
+		Providers: []*ent.Provider{
+			newFallbackTestProvider(1, map[string]interface{}{"latencyMs": float64(60_000)}),
+			newFallbackTestProvider(2, map[string]interface{}{"reply": "in time"}),
+		},
+		Rules: schema.FallbackRules{AttemptTimeoutMs: 20},
+	}
+
+	res, provider, err := svc.ChatWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, provider.ID)
+	assert.Equal(t, "in time", res.Choices[0].Message.Content)
+}
+
+func TestFallbackChatStream(t *testing.T) {
+	svc := isomorphicAIService{}
+	chain := ProviderChain{
+		Providers: []*ent.Provider{
+			// fails before the stream starts
+			newFallbackTestProvider(1, map[string]interface{}{"errorStatus": float64(http.StatusBadGateway)}),
+			// fails before the first token
+			newFallbackTestProvider(2, map[string]interface{}{
+				"errorStatus":      float64(http.StatusServiceUnavailable),
+				"errorAfterChunks": float64(0),
+			}),
+			newFallbackTestProvider(3, map[string]interface{}{"reply": "one two"}),
+			newFallbackTestProvider(4, map[string]interface{}{"reply": "never"}),
+		},
+	}
+
+	reply, provider, err := svc.ChatStreamWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, provider.ID)
+
+	chunks, usage, err := collectMockStream(t, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"one ", "two"}, chunks)
+	assert.Equal(t, 2, usage.CompletionTokens)
+}
+
+func TestFallbackChatStreamAfterFirstToken(t *testing.T) {
+	svc := isomorphicAIService{}
+	chain := ProviderChain{
+		Providers: []*ent.Provider{
+			newFallbackTestProvider(1, map[string]interface{}{
+				"reply":            "one two",
+				"errorStatus":      float64(http.StatusServiceUnavailable),
+				"errorAfterChunks": float64(1),
+			}),
+			newFallbackTestProvider(2, map[string]interface{}{"reply": "never"}),
+		},
+	}
+
+	reply, provider, err := svc.ChatStreamWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, provider.ID)
+
+	chunks, _, err := collectMockStream(t, reply)
+	assert.Equal(t, []string{"one "}, chunks)
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.HTTPStatusCode)
+}
+
+func TestFallbackChatStreamAttemptTimeout(t *testing.T) {
+	svc := isomorphicAIService{}
+	chain := ProviderChain{
+		Providers: []*ent.Provider{
+			newFallbackTestProvider(1, map[string]interface{}{
+				"reply":     "slow reply",
+				"latencyMs": float64(60_000),
+				// the first chunk waits for the latency in the driver goroutine
+				"errorAfterChunks": float64(99),
+			}),
+			newFallbackTestProvider(2, map[string]interface{}{"reply": "fast"}),
+		},
+		Rules: schema.FallbackRules{AttemptTimeoutMs: 20},
+	}
+
+	reply, provider, err := svc.ChatStreamWithFallback(context.Background(), chain, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, provider.ID)
+
+	chunks, _, err := collectMockStream(t, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fast"}, chunks)
+}
+
+func TestGetProviderChainDisabledProviders(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	user, err := client.User.Create().
+		SetName("Fallback User").
+		SetAddr("test_service_fallback_chain").
+		SetEmail("test_service_fallback_chain@annatarhe.com").
+		SetPhone("").
+		SetLang("en").
+		SetLevel(1).
+		Save(ctx)
+	assert.Nil(t, err)
+	newProvider := func(name string, enabled bool) *ent.Provider {
+		p, err := client.Provider.Create().
+			SetName(name).
+			SetSource("mock").
+			SetApiKey("key").
+			SetEnabled(enabled).
+			SetCreatorID(user.ID).
+			Save(ctx)
+		assert.Nil(t, err)
+		return p
+	}
+	primary := newProvider("fallback chain primary", true)
+	disabled := newProvider("fallback chain disabled", false)
+	enabled := newProvider("fallback chain enabled", true)
+
+	chain, err := isomorphicAIService{}.GetProviderChain(ctx, ent.Prompt{
+		ProviderId:          primary.ID,
+		FallbackProviderIds: []int{disabled.ID, enabled.ID},
+		FallbackRules:       &schema.FallbackRules{},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, chain.Providers, 2)
+	assert.Equal(t, primary.ID, chain.Providers[0].ID)
+	assert.Equal(t, enabled.ID, chain.Providers[1].ID)
+}
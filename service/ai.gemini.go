@@ -2,12 +2,13 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/PromptPal/PromptPal/ent"
-	"github.com/PromptPal/PromptPal/ent/schema"
 	"github.com/google/generative-ai-go/genai"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/sirupsen/logrus"
@@ -18,109 +19,322 @@ import (
 type geminiService struct {
 }
 
-func NewGeminiService() BaseAIService {
-	return &geminiService{}
-}
-
-func (o geminiService) getGeminiClient(ctx context.Context, project ent.Project) (*genai.Client, *genai.GenerativeModel, error) {
-	if !strings.HasPrefix(project.OpenAIModel, "gemini") {
-		return nil, nil, errors.New("not gemini model")
+func (o geminiService) getGeminiClient(ctx context.Context, provider *ent.Provider, req openai.ChatCompletionRequest) (*genai.Client, *genai.GenerativeModel, error) {
+	httpClient, err := GetProviderHTTPClient(provider)
+	if err != nil {
+		return nil, nil, err
 	}
-	client, err := genai.NewClient(
-		ctx,
-		option.WithAPIKey(project.GeminiToken),
-		option.WithEndpoint(project.GeminiBaseURL),
-	)
+	// the api key is sent by the http client of the provider
+	opts := []option.ClientOption{
+		option.WithAPIKey(provider.ApiKey),
+		option.WithHTTPClient(httpClient),
+	}
+	if provider.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(provider.Endpoint))
+	}
+	client, err := genai.NewClient(ctx, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
-	genModel := client.GenerativeModel(project.OpenAIModel)
-	genModel.SetTemperature(float32(project.OpenAITemperature))
-	genModel.SetTopP(float32(project.OpenAITopP))
-	genModel.SetTopK(20)
-	if project.OpenAIMaxTokens > 0 {
-		genModel.SetMaxOutputTokens(int32(project.OpenAIMaxTokens))
+	genModel := client.GenerativeModel(req.Model)
+	genModel.SetTemperature(req.Temperature)
+	if req.TopP > 0 {
+		genModel.SetTopP(req.TopP)
+	}
+	if req.MaxTokens > 0 {
+		genModel.SetMaxOutputTokens(int32(req.MaxTokens))
 	}
+	if len(req.Stop) > 0 {
+		genModel.StopSequences = req.Stop
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type != openai.ChatCompletionResponseFormatTypeText {
+		// the schema itself is validated after the reply
+		genModel.ResponseMIMEType = "application/json"
+	}
+	o.setTools(genModel, req)
 
 	return client, genModel, nil
 }
 
-func (o geminiService) Chat(
-	ctx context.Context,
-	project ent.Project,
-	prompts []schema.PromptRow,
-	variables map[string]string,
-	userId string,
-) (reply openai.ChatCompletionResponse, err error) {
-	if !strings.HasPrefix(project.OpenAIModel, "gemini") {
-		return reply, errors.New("not gemini model")
+// buildContents splits the messages into the system instruction and the chat
+// history. the last content is the one should be sent to the model.
+func (o geminiService) buildContents(messages []openai.ChatCompletionMessage) (system *genai.Content, contents []*genai.Content) {
+	systems := []genai.Part{}
+	for _, msg := range messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			systems = append(systems, genai.Text(messageText(msg)))
+			continue
+		}
+		if msg.Role == openai.ChatMessageRoleTool {
+			// gemini matches the results by the name of the function, consecutive ones in a single content
+			part := genai.FunctionResponse{
+				Name:     toolCallName(messages, msg.ToolCallID),
+				Response: toolResult(msg.Content),
+			}
+			last := len(contents) - 1
+			if last >= 0 && contents[last].Role == "user" {
+				if _, ok := contents[last].Parts[0].(genai.FunctionResponse); ok {
+					contents[last].Parts = append(contents[last].Parts, part)
+					continue
+				}
+			}
+			contents = append(contents, &genai.Content{
+				Role:  "user",
+				Parts: []genai.Part{part},
+			})
+			continue
+		}
+		role := "user"
+		if msg.Role == openai.ChatMessageRoleAssistant {
+			role = "model"
+		}
+		parts := []genai.Part{}
+		if len(msg.MultiContent) > 0 {
+			parts = append(parts, geminiMultiContent(msg.MultiContent)...)
+		} else if msg.Content != "" || len(msg.ToolCalls) == 0 {
+			parts = append(parts, genai.Text(msg.Content))
+		}
+		for _, call := range msg.ToolCalls {
+			parts = append(parts, genai.FunctionCall{
+				Name: call.Function.Name,
+				Args: toolArguments(call.Function.Arguments),
+			})
+		}
+		contents = append(contents, &genai.Content{
+			Role:  role,
+			Parts: parts,
+		})
 	}
+	if len(systems) > 0 {
+		system = &genai.Content{Parts: systems}
+	}
+	return
+}
 
-	client, genModel, err := o.getGeminiClient(ctx, project)
-	if err != nil {
-		log.Fatal(err)
-		return reply, err
+// geminiMultiContent sends inline data as blobs and urls as file data
+func geminiMultiContent(parts []openai.ChatMessagePart) []genai.Part {
+	result := []genai.Part{}
+	for _, part := range parts {
+		media, ok := mediaFromPart(part)
+		switch {
+		case !ok:
+			result = append(result, genai.Text(part.Text))
+		case media.URL != "":
+			result = append(result, genai.FileData{MIMEType: media.MIMEType, URI: media.URL})
+		default:
+			result = append(result, genai.Blob{MIMEType: media.MIMEType, Data: media.Data})
+		}
 	}
-	defer client.Close()
+	return result
+}
 
-	pts := []genai.Part{}
-	for _, prompt := range prompts {
-		content := replacePlaceholders(prompt.Prompt, variables)
-		txt := genai.Text(content)
-		pts = append(pts, txt)
+func (o geminiService) startChat(genModel *genai.GenerativeModel, req openai.ChatCompletionRequest) (*genai.ChatSession, []genai.Part, error) {
+	system, contents := o.buildContents(req.Messages)
+	if len(contents) == 0 {
+		return nil, nil, errors.New("gemini: at least one user message is required")
 	}
+	genModel.SystemInstruction = system
 
-	promptTokenCount, err := genModel.CountTokens(ctx, pts...)
+	cs := genModel.StartChat()
+	cs.History = contents[:len(contents)-1]
+	return cs, contents[len(contents)-1].Parts, nil
+}
 
-	if err != nil {
-		return reply, err
+func geminiFinishReason(reason genai.FinishReason) openai.FinishReason {
+	switch reason {
+	case genai.FinishReasonMaxTokens:
+		return openai.FinishReasonLength
+	case genai.FinishReasonSafety, genai.FinishReasonRecitation:
+		return openai.FinishReasonContentFilter
+	default:
+		return openai.FinishReasonStop
 	}
+}
 
-	resp, err := genModel.GenerateContent(ctx, pts...)
-	if err != nil {
-		return reply, err
+func geminiUsage(usage *genai.UsageMetadata) openai.Usage {
+	if usage == nil {
+		return openai.Usage{}
 	}
+	return openai.Usage{
+		PromptTokens:     int(usage.PromptTokenCount),
+		CompletionTokens: int(usage.CandidatesTokenCount),
+		TotalTokens:      int(usage.TotalTokenCount),
+	}
+}
 
+// geminiChoices converts the candidates. gemini function calls have no id,
+// `calls` counts the calls of the reply to number them.
+func geminiChoices(resp *genai.GenerateContentResponse, calls *int) []openai.ChatCompletionChoice {
 	result := []openai.ChatCompletionChoice{}
-
-	completionTokenCount := int32(0)
 	for _, cand := range resp.Candidates {
 		if cand.Content == nil {
 			continue
 		}
-		completionTokenCount += cand.TokenCount
+		var content strings.Builder
+		var toolCalls []openai.ToolCall
 		for _, part := range cand.Content.Parts {
-			content, ok := part.(genai.Text)
-			if !ok {
-				logrus.Warnln("not a text part in gemini api")
-				continue
+			switch p := part.(type) {
+			case genai.Text:
+				content.WriteString(string(p))
+			case genai.FunctionCall:
+				args, _ := json.Marshal(p.Args)
+				toolCalls = append(toolCalls, openai.ToolCall{
+					ID:   fmt.Sprintf("call_%d", *calls),
+					Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{
+						Name:      p.Name,
+						Arguments: string(args),
+					},
+				})
+				*calls++
+			default:
+				logrus.Warnln("unsupported part in gemini api", part)
 			}
-			result = append(result, openai.ChatCompletionChoice{
-				Index: int(cand.Index),
-				Message: openai.ChatCompletionMessage{
-					Role:    cand.Content.Role,
-					Content: string(content),
-				},
-				FinishReason: openai.FinishReasonStop,
-			})
 		}
+		finishReason := geminiFinishReason(cand.FinishReason)
+		if len(toolCalls) > 0 {
+			finishReason = openai.FinishReasonToolCalls
+		}
+		result = append(result, openai.ChatCompletionChoice{
+			Index: int(cand.Index),
+			Message: openai.ChatCompletionMessage{
+				Role:      openai.ChatMessageRoleAssistant,
+				Content:   content.String(),
+				ToolCalls: toolCalls,
+			},
+			FinishReason: finishReason,
+		})
+	}
+	return result
+}
+
+func (o geminiService) setTools(genModel *genai.GenerativeModel, req openai.ChatCompletionRequest) {
+	if len(req.Tools) == 0 {
+		return
+	}
+	declarations := []*genai.FunctionDeclaration{}
+	for _, tool := range req.Tools {
+		parameters, _ := tool.Function.Parameters.(map[string]interface{})
+		declarations = append(declarations, &genai.FunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  geminiSchema(parameters),
+		})
+	}
+	genModel.Tools = []*genai.Tool{{FunctionDeclarations: declarations}}
+
+	if req.ToolChoice == nil {
+		return
+	}
+	config := &genai.FunctionCallingConfig{Mode: genai.FunctionCallingAuto}
+	policy, name := toolChoicePolicy(req.ToolChoice)
+	switch policy {
+	case ToolChoiceNone:
+		config.Mode = genai.FunctionCallingNone
+	case ToolChoiceRequired:
+		config.Mode = genai.FunctionCallingAny
+	}
+	if name != "" {
+		config.AllowedFunctionNames = []string{name}
+	}
+	genModel.ToolConfig = &genai.ToolConfig{FunctionCallingConfig: config}
+}
+
+// geminiSchema converts a JSON Schema into the subset supported by gemini
+func geminiSchema(def map[string]interface{}) *genai.Schema {
+	if len(def) == 0 {
+		return nil
+	}
+	result := &genai.Schema{}
+	result.Description, _ = def["description"].(string)
+	result.Format, _ = def["format"].(string)
+	result.Nullable, _ = def["nullable"].(bool)
+
+	types := []interface{}{def["type"]}
+	if list, ok := def["type"].([]interface{}); ok {
+		types = list
+	}
+	for _, t := range types {
+		switch t {
+		case "string":
+			result.Type = genai.TypeString
+		case "number":
+			result.Type = genai.TypeNumber
+		case "integer":
+			result.Type = genai.TypeInteger
+		case "boolean":
+			result.Type = genai.TypeBoolean
+		case "array":
+			result.Type = genai.TypeArray
+		case "object":
+			result.Type = genai.TypeObject
+		case "null":
+			result.Nullable = true
+		}
+	}
+
+	if enum, ok := def["enum"].([]interface{}); ok {
+		for _, v := range enum {
+			result.Enum = append(result.Enum, fmt.Sprint(v))
+		}
+	}
+	if items, ok := def["items"].(map[string]interface{}); ok {
+		result.Items = geminiSchema(items)
+	}
+	if properties, ok := def["properties"].(map[string]interface{}); ok {
+		result.Properties = map[string]*genai.Schema{}
+		for name, prop := range properties {
+			if propDef, ok := prop.(map[string]interface{}); ok {
+				result.Properties[name] = geminiSchema(propDef)
+			}
+		}
+	}
+	if required, ok := def["required"].([]interface{}); ok {
+		for _, v := range required {
+			if name, ok := v.(string); ok {
+				result.Required = append(result.Required, name)
+			}
+		}
+	}
+	return result
+}
+
+func (o geminiService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	client, genModel, err := o.getGeminiClient(ctx, provider, req)
+	if err != nil {
+		return reply, err
+	}
+	defer client.Close()
+
+	cs, parts, err := o.startChat(genModel, req)
+	if err != nil {
+		return reply, err
 	}
+
+	logrus.Debugln("gemini:chat: prompts need to send", req.Messages)
+	resp, err := cs.SendMessage(ctx, parts...)
+	if err != nil {
+		return reply, err
+	}
+
+	calls := 0
 	return openai.ChatCompletionResponse{
-		Choices: result,
-		Usage: openai.Usage{
-			CompletionTokens: int(completionTokenCount),
-			PromptTokens:     int(promptTokenCount.TotalTokens),
-			TotalTokens:      int(completionTokenCount),
-		},
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+		Choices: geminiChoices(resp, &calls),
+		Usage:   geminiUsage(resp.UsageMetadata),
 	}, nil
 }
 
 func (o geminiService) ChatStream(
 	ctx context.Context,
-	project ent.Project,
-	prompts []schema.PromptRow,
-	variables map[string]string,
-	userId string,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
 ) (reply *ChatStreamResponse, err error) {
 	reply = &ChatStreamResponse{
 		Done:    make(chan bool),
@@ -129,41 +343,28 @@ func (o geminiService) ChatStream(
 		Message: make(chan []openai.ChatCompletionChoice),
 	}
 
-	if !strings.HasPrefix(project.OpenAIModel, "gemini") {
-		return reply, errors.New("not gemini model")
-	}
-
-	client, genModel, err := o.getGeminiClient(ctx, project)
+	client, genModel, err := o.getGeminiClient(ctx, provider, req)
 	if err != nil {
 		return reply, err
 	}
-	defer client.Close()
-
-	pts := []genai.Part{}
-	for _, prompt := range prompts {
-		content := replacePlaceholders(prompt.Prompt, variables)
-		txt := genai.Text(content)
-		pts = append(pts, txt)
-	}
-
-	promptTokenCount, err := genModel.CountTokens(ctx, pts...)
 
+	cs, parts, err := o.startChat(genModel, req)
 	if err != nil {
+		client.Close()
 		return reply, err
 	}
 
-	iter := genModel.GenerateContentStream(ctx, pts...)
-	completionTokenCount := int32(0)
+	logrus.Debugln("gemini:stream: prompts need to send", req.Messages)
+	iter := cs.SendMessageStream(ctx, parts...)
 	go func() {
+		defer client.Close()
+
+		var usage *genai.UsageMetadata
+		calls := 0
 		for {
 			resp, err := iter.Next()
 			if err == iterator.Done {
-				usage := openai.Usage{
-					CompletionTokens: int(completionTokenCount),
-					PromptTokens:     int(promptTokenCount.TotalTokens),
-					TotalTokens:      int(completionTokenCount) + int(promptTokenCount.TotalTokens),
-				}
-				reply.Info <- usage
+				reply.Info <- geminiUsage(usage)
 				reply.Done <- true
 				break
 			}
@@ -172,28 +373,14 @@ func (o geminiService) ChatStream(
 				break
 			}
 
-			result := []openai.ChatCompletionChoice{}
+			// the usage metadata of the last chunk contains the whole usage
+			if resp.UsageMetadata != nil {
+				usage = resp.UsageMetadata
+			}
 
-			for _, cand := range resp.Candidates {
-				if cand.Content == nil {
-					continue
-				}
-				completionTokenCount += cand.TokenCount
-				for _, part := range cand.Content.Parts {
-					content, ok := part.(genai.Text)
-					if !ok {
-						logrus.Warnln("not a text part in gemini api")
-						continue
-					}
-					result = append(result, openai.ChatCompletionChoice{
-						Index: int(cand.Index),
-						Message: openai.ChatCompletionMessage{
-							Role:    cand.Content.Role,
-							Content: string(content),
-						},
-						FinishReason: openai.FinishReasonStop,
-					})
-				}
+			result := geminiChoices(resp, &calls)
+			if len(result) == 0 {
+				continue
 			}
 			reply.Message <- result
 		}
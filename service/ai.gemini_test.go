@@ -0,0 +1,152 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type geminiTestRequest struct {
+	Contents []struct {
+		Role  string `json:"role"`
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"contents"`
+	SystemInstruction struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"systemInstruction"`
+	GenerationConfig struct {
+		MaxOutputTokens int     `json:"maxOutputTokens"`
+		Temperature     float32 `json:"temperature"`
+	} `json:"generationConfig"`
+}
+
+// writeGeminiStream writes the chunks as a compact json array, which is the
+// framing the genai REST stream reader expects.
+func writeGeminiStream(w http.ResponseWriter, chunks string) {
+	var buf bytes.Buffer
+	json.Compact(&buf, []byte(chunks))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+func newGeminiTestProvider(endpoint string) *ent.Provider {
+	return &ent.Provider{
+		Source:       "gemini",
+		Endpoint:     endpoint,
+		ApiKey:       "test-key",
+		DefaultModel: "gemini-2.0-flash",
+		Temperature:  0.5,
+		TopP:         0.9,
+		MaxTokens:    256,
+	}
+}
+
+func newGeminiTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{
+			{Role: "system", Prompt: "You are a helpful assistant."},
+			{Role: "user", Prompt: "Hi"},
+			{Role: "assistant", Prompt: "Hello, what is your name?"},
+			{Role: "user", Prompt: "I am {{name}}"},
+		},
+	}
+}
+
+func TestGeminiChat(t *testing.T) {
+	var received geminiTestRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the genai client always uses the streaming endpoint and merges the chunks
+		assert.True(t, strings.HasSuffix(r.URL.Path, "/models/gemini-2.0-flash:streamGenerateContent"))
+		assert.Equal(t, "test-key", r.Header.Get("x-goog-api-key"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+
+		writeGeminiStream(w, `[{
+			"candidates": [{
+				"content": {"role": "model", "parts": [{"text": "Nice to meet you, "}, {"text": "John"}]},
+				"finishReason": 2,
+				"index": 0
+			}],
+			"usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 6, "totalTokenCount": 26}
+		}]`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(
+		context.Background(),
+		newGeminiTestProvider(server.URL),
+		newGeminiTestPrompt(),
+		map[string]string{"name": "John"},
+		"",
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "You are a helpful assistant.", received.SystemInstruction.Parts[0].Text)
+	assert.Len(t, received.Contents, 3)
+	assert.Equal(t, "model", received.Contents[1].Role)
+	assert.Equal(t, "I am John", received.Contents[2].Parts[0].Text)
+	assert.Equal(t, 256, received.GenerationConfig.MaxOutputTokens)
+	assert.Equal(t, float32(0.5), received.GenerationConfig.Temperature)
+
+	assert.Len(t, res.Choices, 1)
+	assert.Equal(t, "Nice to meet you, John", res.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonLength, res.Choices[0].FinishReason)
+	assert.Equal(t, 20, res.Usage.PromptTokens)
+	assert.Equal(t, 6, res.Usage.CompletionTokens)
+	assert.Equal(t, 26, res.Usage.TotalTokens)
+}
+
+func TestGeminiChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasSuffix(r.URL.Path, ":streamGenerateContent"))
+
+		writeGeminiStream(w, `[
+			{"candidates": [{"content": {"role": "model", "parts": [{"text": "Nice to "}]}, "index": 0}]},
+			{"candidates": [{"content": {"role": "model", "parts": [{"text": "meet you"}]}, "finishReason": 1, "index": 0}],
+			 "usageMetadata": {"promptTokenCount": 20, "candidatesTokenCount": 4, "totalTokenCount": 24}}
+		]`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	reply, err := svc.ChatStream(
+		context.Background(),
+		newGeminiTestProvider(server.URL),
+		newGeminiTestPrompt(),
+		map[string]string{"name": "John"},
+		"",
+	)
+	assert.Nil(t, err)
+
+	result := ""
+	var usage openai.Usage
+	for done := false; !done; {
+		select {
+		case msg := <-reply.Message:
+			result += msg[0].Message.Content
+		case usage = <-reply.Info:
+		case err := <-reply.Err:
+			t.Fatal(err)
+		case <-reply.Done:
+			done = true
+		}
+	}
+
+	assert.Equal(t, "Nice to meet you", result)
+	assert.Equal(t, 20, usage.PromptTokens)
+	assert.Equal(t, 4, usage.CompletionTokens)
+	assert.Equal(t, 24, usage.TotalTokens)
+}
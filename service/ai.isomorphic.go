@@ -2,16 +2,13 @@ package service
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 
 	"github.com/PromptPal/PromptPal/ent"
 	openai "github.com/sashabaranov/go-openai"
-	"github.com/sirupsen/logrus"
 )
 
 type isomorphicAIService struct {
@@ -49,26 +46,27 @@ type IsomorphicAIService interface {
 		variables map[string]string,
 		userId string,
 	) (reply *ChatStreamResponse, err error)
+	GetProviderChain(ctx context.Context, prompt ent.Prompt) (chain ProviderChain, err error)
+	ChatWithFallback(
+		ctx context.Context,
+		chain ProviderChain,
+		prompt ent.Prompt,
+		variables map[string]string,
+		userId string,
+	) (reply openai.ChatCompletionResponse, provider *ent.Provider, err error)
+	ChatStreamWithFallback(
+		ctx context.Context,
+		chain ProviderChain,
+		prompt ent.Prompt,
+		variables map[string]string,
+		userId string,
+	) (reply *ChatStreamResponse, provider *ent.Provider, err error)
 }
 
 func NewIsomorphicAIService() IsomorphicAIService {
 	return &isomorphicAIService{}
 }
 
-func (o isomorphicAIService) getIsomorphicClient(ctx context.Context, provider *ent.Provider) (*openai.Client, error) {
-	cfg := openai.DefaultConfig(provider.ApiKey)
-	if provider.Endpoint != "" {
-		baseUrl, err := url.Parse(provider.Endpoint)
-		if err != nil {
-			logrus.Errorln(err)
-			return nil, err
-		}
-		cfg.BaseURL = baseUrl.String()
-	}
-	client := openai.NewClientWithConfig(cfg)
-	return client, nil
-}
-
 func (o isomorphicAIService) GetProvider(ctx context.Context, prompt ent.Prompt) (provider *ent.Provider, err error) {
 	var promptProvider *ent.Provider
 	if prompt.ProviderId > 0 {
@@ -114,25 +112,19 @@ func (o isomorphicAIService) GetProvider(ctx context.Context, prompt ent.Prompt)
 
 	if pj.GeminiToken != "" {
 		dummyProvider.Source = "gemini"
+		dummyProvider.Endpoint = pj.GeminiBaseURL
 		dummyProvider.ApiKey = pj.GeminiToken
 	}
 	provider = dummyProvider
 	return
 }
 
-// just for mock
-func (o isomorphicAIService) Chat(
-	ctx context.Context,
+func (o isomorphicAIService) buildChatRequest(
 	provider *ent.Provider,
 	prompt ent.Prompt,
 	variables map[string]string,
 	userId string,
-) (reply openai.ChatCompletionResponse, err error) {
-	client, err := o.getIsomorphicClient(ctx, provider)
-	if err != nil {
-		return
-	}
-
+) openai.ChatCompletionRequest {
 	req := openai.ChatCompletionRequest{
 		Model:       provider.DefaultModel,
 		Temperature: float32(provider.Temperature),
@@ -144,115 +136,83 @@ func (o isomorphicAIService) Chat(
 	if provider.MaxTokens > 0 {
 		req.MaxTokens = provider.MaxTokens
 	}
+	if driver, ok := GetProviderDriver(provider.Source).(ProviderExplicitTopP); ok && driver.ExplicitTopPOnly() {
+		req.TopP = 0
+	}
+	applyModelParameters(&req, provider, prompt)
+	req.ResponseFormat = outputResponseFormat(prompt)
 
-	prompts := prompt.Prompts
-
-	logrus.Debugln("openai:chat: prompts need to send", prompts)
-	for _, prompt := range prompts {
-		content := replacePlaceholdersLegacy(prompt.Prompt, variables)
-		pt := openai.ChatCompletionMessage{
-			Role:    prompt.Role,
-			Content: content,
-		}
-		req.Messages = append(req.Messages, pt)
+	mediaTypes := mediaVariableTypes(prompt.Variables)
+	for _, row := range prompt.Prompts {
+		req.Messages = append(req.Messages, promptRowMessage(row, variables, mediaTypes))
 	}
+	req.Tools, req.ToolChoice = promptTools(prompt)
+	return req
+}
 
-	return client.CreateChatCompletion(ctx, req)
+// RequestModel returns the model the prompt asks the provider for
+func RequestModel(provider *ent.Provider, prompt ent.Prompt) string {
+	req := openai.ChatCompletionRequest{Model: provider.DefaultModel}
+	applyModelParameters(&req, provider, prompt)
+	return req.Model
 }
 
-func (o isomorphicAIService) ChatStream(
-	ctx context.Context,
-	provider *ent.Provider,
-	prompt ent.Prompt,
-	variables map[string]string,
-	userId string,
-) (reply *ChatStreamResponse, err error) {
-	reply = &ChatStreamResponse{
-		Done:    make(chan bool),
-		Err:     make(chan error),
-		Info:    make(chan openai.Usage),
-		Message: make(chan []openai.ChatCompletionChoice),
+// applyModelParameters merges the overrides of the prompt over the provider defaults
+func applyModelParameters(req *openai.ChatCompletionRequest, provider *ent.Provider, prompt ent.Prompt) {
+	params := prompt.ModelParameters
+	if params == nil {
+		return
 	}
-
-	client, err := o.getIsomorphicClient(ctx, provider)
-
-	if err != nil {
-		return reply, err
+	// the model belongs to the provider of the prompt, a fallback provider may not serve it
+	if params.Model != "" && (prompt.ProviderId == 0 || prompt.ProviderId == provider.ID) {
+		req.Model = params.Model
 	}
-
-	req := openai.ChatCompletionRequest{
-		Model:       provider.DefaultModel,
-		Temperature: float32(provider.Temperature),
-		TopP:        float32(provider.TopP),
+	if params.Temperature != nil {
+		req.Temperature = float32(*params.Temperature)
+		if req.Temperature == 0 {
+			// a zero temperature is dropped by omitempty, send the closest value instead
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
 	}
-	if userId != "" {
-		req.User = userId
+	if params.TopP != nil {
+		req.TopP = float32(*params.TopP)
 	}
-	if provider.MaxTokens > 0 {
-		req.MaxTokens = provider.MaxTokens
+	if params.MaxTokens != nil {
+		req.MaxTokens = *params.MaxTokens
 	}
-
-	prompts := prompt.Prompts
-
-	logrus.Debugln("openai:stream: prompts need to send", prompts, variables)
-	for _, prompt := range prompts {
-		content := replacePlaceholdersLegacy(prompt.Prompt, variables)
-		pt := openai.ChatCompletionMessage{
-			Role:    prompt.Role,
-			Content: content,
-		}
-		req.Messages = append(req.Messages, pt)
+	if len(params.Stop) > 0 {
+		req.Stop = params.Stop
 	}
-	req.StreamOptions = &openai.StreamOptions{
-		IncludeUsage: true,
+	if params.Seed != nil {
+		req.Seed = params.Seed
 	}
-
-	stream, err := client.CreateChatCompletionStream(ctx, req)
-
-	if err != nil {
-		return reply, err
+	if params.PresencePenalty != nil {
+		req.PresencePenalty = float32(*params.PresencePenalty)
 	}
+	if params.FrequencyPenalty != nil {
+		req.FrequencyPenalty = float32(*params.FrequencyPenalty)
+	}
+}
 
-	go func() {
-		for {
-			resp, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
-				err = nil
-				reply.Done <- true
-				stream.Close()
-				break
-			}
-			if err != nil {
-				reply.Err <- err
-				stream.Close()
-				break
-			}
-
-			if resp.Usage != nil {
-				reply.Info <- *resp.Usage
-			}
-
-			if len(resp.Choices) == 0 {
-				continue
-			}
-
-			temp := make([]openai.ChatCompletionChoice, len(resp.Choices))
-
-			for i, cand := range resp.Choices {
-				content := cand.Delta.Content
-				chunk := openai.ChatCompletionChoice{
-					Index:        cand.Index,
-					FinishReason: openai.FinishReasonStop,
-					Message: openai.ChatCompletionMessage{
-						Role:    cand.Delta.Role,
-						Content: content,
-					},
-				}
-				temp[i] = chunk
-			}
+// just for mock
+func (o isomorphicAIService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	prompt ent.Prompt,
+	variables map[string]string,
+	userId string,
+) (reply openai.ChatCompletionResponse, err error) {
+	req := o.buildChatRequest(provider, prompt, variables, userId)
+	return o.chat(ctx, provider, req)
+}
 
-			reply.Message <- temp
-		}
-	}()
-	return reply, nil
+func (o isomorphicAIService) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	prompt ent.Prompt,
+	variables map[string]string,
+	userId string,
+) (reply *ChatStreamResponse, err error) {
+	req := o.buildChatRequest(provider, prompt, variables, userId)
+	return o.chatStream(ctx, provider, req)
 }
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelParametersDefaults(t *testing.T) {
+	provider := &ent.Provider{ID: 1, DefaultModel: "gpt-4o", Temperature: 0.7, TopP: 0.9, MaxTokens: 256}
+
+	req := isomorphicAIService{}.buildChatRequest(provider, newTransportTestPrompt(), nil, "")
+	assert.Equal(t, "gpt-4o", req.Model)
+	assert.Equal(t, float32(0.7), req.Temperature)
+	assert.Equal(t, float32(0.9), req.TopP)
+	assert.Equal(t, 256, req.MaxTokens)
+	assert.Nil(t, req.Seed)
+}
+
+func TestModelParametersOverrides(t *testing.T) {
+	provider := &ent.Provider{ID: 1, DefaultModel: "gpt-4o", Temperature: 0.7, TopP: 0.9, MaxTokens: 256}
+	temperature, topP, maxTokens, seed := 0.2, 0.5, 64, 42
+	presencePenalty, frequencyPenalty := 0.3, -0.4
+
+	prompt := newTransportTestPrompt()
+	prompt.ProviderId = 1
+	prompt.ModelParameters = &schema.ModelParameters{
+		Model:            "gpt-4o-mini",
+		Temperature:      &temperature,
+		TopP:             &topP,
+		MaxTokens:        &maxTokens,
+		Stop:             []string{"\n\n"},
+		Seed:             &seed,
+		PresencePenalty:  &presencePenalty,
+		FrequencyPenalty: &frequencyPenalty,
+	}
+
+	req := isomorphicAIService{}.buildChatRequest(provider, prompt, nil, "")
+	assert.Equal(t, "gpt-4o-mini", req.Model)
+	assert.Equal(t, float32(0.2), req.Temperature)
+	assert.Equal(t, float32(0.5), req.TopP)
+	assert.Equal(t, 64, req.MaxTokens)
+	assert.Equal(t, []string{"\n\n"}, req.Stop)
+	assert.Equal(t, 42, *req.Seed)
+	assert.Equal(t, float32(0.3), req.PresencePenalty)
+	assert.Equal(t, float32(-0.4), req.FrequencyPenalty)
+
+	// a fallback provider keeps its own model, the other parameters still apply
+	fallback := &ent.Provider{ID: 2, DefaultModel: "claude-sonnet-4", Temperature: 1}
+	req = isomorphicAIService{}.buildChatRequest(fallback, prompt, nil, "")
+	assert.Equal(t, "claude-sonnet-4", req.Model)
+	assert.Equal(t, float32(0.2), req.Temperature)
+}
+
+func TestModelParametersZeroTemperature(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	temperature := 0.0
+	prompt := newTransportTestPrompt()
+	prompt.ModelParameters = &schema.ModelParameters{Temperature: &temperature}
+	provider := &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", DefaultModel: "gpt-4o", Temperature: 0.7}
+
+	_, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, nil, "")
+	assert.Nil(t, err)
+	assert.Contains(t, received, "temperature")
+	assert.InDelta(t, 0, received["temperature"], 1e-6)
+}
@@ -0,0 +1,414 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// the media variables are sent as content parts. go-openai only knows image_url,
+// audio and video parts carry the value in ImageURL too and are rewritten by openAIMediaDoer.
+const (
+	mediaPartTypeInputAudio openai.ChatMessagePartType = "input_audio"
+	mediaPartTypeVideoURL   openai.ChatMessagePartType = "video_url"
+)
+
+// the largest inline data of a media variable, in bytes
+var mediaMaxSize = map[schema.PromptVariableTypes]int{
+	schema.PromptVariableTypesImage: 20 << 20,
+	schema.PromptVariableTypesAudio: 25 << 20,
+	schema.PromptVariableTypesVideo: 50 << 20,
+}
+
+var mediaMIMETypes = map[schema.PromptVariableTypes][]string{
+	schema.PromptVariableTypesImage: {"image/png", "image/jpeg", "image/gif", "image/webp"},
+	schema.PromptVariableTypesAudio: {"audio/wav", "audio/x-wav", "audio/mpeg", "audio/mp3", "audio/aac", "audio/ogg", "audio/flac", "audio/webm"},
+	schema.PromptVariableTypesVideo: {"video/mp4", "video/mpeg", "video/webm", "video/quicktime"},
+}
+
+// used for urls without a known extension, some providers require the type
+var mediaDefaultMIMEType = map[schema.PromptVariableTypes]string{
+	schema.PromptVariableTypesImage: "image/jpeg",
+	schema.PromptVariableTypesAudio: "audio/mpeg",
+	schema.PromptVariableTypesVideo: "video/mp4",
+}
+
+var mediaPartTypes = map[schema.PromptVariableTypes]openai.ChatMessagePartType{
+	schema.PromptVariableTypesImage: openai.ChatMessagePartTypeImageURL,
+	schema.PromptVariableTypesAudio: mediaPartTypeInputAudio,
+	schema.PromptVariableTypesVideo: mediaPartTypeVideoURL,
+}
+
+// mediaValue is the value of a media variable, either a url or inline data
+type mediaValue struct {
+	Type     schema.PromptVariableTypes
+	MIMEType string
+	URL      string
+	Data     []byte
+}
+
+func isMediaVariableType(t schema.PromptVariableTypes) bool {
+	_, ok := mediaPartTypes[t]
+	return ok
+}
+
+// parseMediaValue parses the value of a media variable, which is an http(s) url
+// or a base64 data uri like `data:image/png;base64,...`
+func parseMediaValue(t schema.PromptVariableTypes, value string) (media mediaValue, err error) {
+	media.Type = t
+	if strings.HasPrefix(value, "data:") {
+		header, data, ok := strings.Cut(strings.TrimPrefix(value, "data:"), ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return media, fmt.Errorf("%s must be a base64 data uri", t)
+		}
+		media.MIMEType = strings.ToLower(strings.TrimSuffix(header, ";base64"))
+		if media.Data, err = base64.StdEncoding.DecodeString(data); err != nil {
+			return media, fmt.Errorf("invalid base64 data of %s: %w", t, err)
+		}
+		return media, nil
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return media, fmt.Errorf("%s must be an http(s) url or a data uri", t)
+	}
+	media.URL = value
+	media.MIMEType = mediaDefaultMIMEType[t]
+	if mimeType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(u.Path))); err == nil {
+		media.MIMEType = mimeType
+	}
+	return media, nil
+}
+
+func (m mediaValue) validate() error {
+	if !slices.Contains(mediaMIMETypes[m.Type], m.MIMEType) {
+		return fmt.Errorf("%s is not a supported %s type", m.MIMEType, m.Type)
+	}
+	if len(m.Data) > mediaMaxSize[m.Type] {
+		return fmt.Errorf("%s of %d bytes is larger than %d bytes", m.Type, len(m.Data), mediaMaxSize[m.Type])
+	}
+	return nil
+}
+
+// reference describes the value without the data, for logs
+func (m mediaValue) reference() string {
+	if m.URL != "" {
+		return m.URL
+	}
+	sum := sha256.Sum256(m.Data)
+	return fmt.Sprintf("%s;size=%d;sha256=%s", m.MIMEType, len(m.Data), hex.EncodeToString(sum[:]))
+}
+
+// ValidateMediaVariables checks the values of the image, audio and video variables of a prompt
+func ValidateMediaVariables(variables []schema.PromptVariable, values map[string]string) error {
+	for _, v := range variables {
+		value, ok := values[v.Name]
+		if !ok || !isMediaVariableType(v.Type) {
+			continue
+		}
+		media, err := parseMediaValue(v.Type, value)
+		if err == nil {
+			err = media.validate()
+		}
+		if err != nil {
+			return fmt.Errorf("variable %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// MediaVariableReferences replaces the inline data of media variables with a
+// reference of their type, size and hash. the debug payload keeps no blobs.
+func MediaVariableReferences(variables []schema.PromptVariable, values map[string]string) map[string]string {
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		result[k] = v
+	}
+	for _, v := range variables {
+		value, ok := values[v.Name]
+		if !ok || !isMediaVariableType(v.Type) {
+			continue
+		}
+		if media, err := parseMediaValue(v.Type, value); err == nil {
+			result[v.Name] = media.reference()
+		}
+	}
+	return result
+}
+
+func mediaVariableTypes(variables []schema.PromptVariable) map[string]schema.PromptVariableTypes {
+	result := map[string]schema.PromptVariableTypes{}
+	for _, v := range variables {
+		if isMediaVariableType(v.Type) {
+			result[v.Name] = v.Type
+		}
+	}
+	return result
+}
+
+// renderMultiContent renders a prompt into text and media parts, the media variables become parts of their own.
+// it returns nil if the prompt renders no media variable.
+func renderMultiContent(prompt string, variables map[string]string, mediaTypes map[string]schema.PromptVariableTypes) []openai.ChatMessagePart {
+	if len(mediaTypes) == 0 {
+		return nil
+	}
+	t, err := ParseTemplate(prompt)
+	if err != nil {
+		return splitMultiContentLegacy(prompt, variables, mediaTypes)
+	}
+	rendered, err := t.renderParts(variables, mediaTypes)
+	if err != nil || !slices.ContainsFunc(rendered, func(part templatePart) bool { return part.media != "" }) {
+		return nil
+	}
+
+	var parts []openai.ChatMessagePart
+	for _, part := range rendered {
+		if part.media == "" {
+			if strings.TrimSpace(part.text) != "" {
+				parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: part.text})
+			}
+			continue
+		}
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     mediaPartTypes[mediaTypes[part.media]],
+			ImageURL: &openai.ChatMessageImageURL{URL: variables[part.media]},
+		})
+	}
+	return parts
+}
+
+// splitMultiContentLegacy splits a text which is not a template at its media placeholders
+// into text and media parts. it returns nil if the text has no media placeholder.
+func splitMultiContentLegacy(prompt string, variables map[string]string, mediaTypes map[string]schema.PromptVariableTypes) []openai.ChatMessagePart {
+	if len(mediaTypes) == 0 {
+		return nil
+	}
+	var parts []openai.ChatMessagePart
+	addText := func(text string) {
+		if text = replacePlaceholdersLegacy(text, variables); strings.TrimSpace(text) != "" {
+			parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: text})
+		}
+	}
+
+	last := 0
+	hasMedia := false
+	for _, match := range placeholderRegexp.FindAllStringSubmatchIndex(prompt, -1) {
+		name := prompt[match[2]:match[3]]
+		t, isMedia := mediaTypes[name]
+		value, ok := variables[name]
+		if !isMedia || !ok {
+			continue
+		}
+		hasMedia = true
+		addText(prompt[last:match[0]])
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     mediaPartTypes[t],
+			ImageURL: &openai.ChatMessageImageURL{URL: value},
+		})
+		last = match[1]
+	}
+	if !hasMedia {
+		return nil
+	}
+	addText(prompt[last:])
+	return parts
+}
+
+// mediaFromPart parses a media part of a message, ok is false for text parts
+func mediaFromPart(part openai.ChatMessagePart) (media mediaValue, ok bool) {
+	if part.ImageURL == nil {
+		return media, false
+	}
+	for t, partType := range mediaPartTypes {
+		if partType == part.Type {
+			media, err := parseMediaValue(t, part.ImageURL.URL)
+			return media, err == nil
+		}
+	}
+	return media, false
+}
+
+// messageText returns the text of a message, joining the text parts of a multi content one
+func messageText(msg openai.ChatCompletionMessage) string {
+	if len(msg.MultiContent) == 0 {
+		return msg.Content
+	}
+	texts := []string{}
+	for _, part := range msg.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "")
+}
+
+// requireMediaSupport fails if a message has media the driver cannot send
+func requireMediaSupport(driver string, req openai.ChatCompletionRequest, supports func(media mediaValue) bool) error {
+	for _, msg := range req.Messages {
+		for _, part := range msg.MultiContent {
+			media, ok := mediaFromPart(part)
+			if ok && !supports(media) {
+				if media.URL != "" {
+					return fmt.Errorf("%s: %s urls are not supported, send the data inline", driver, media.Type)
+				}
+				return fmt.Errorf("%s: %s variables are not supported", driver, media.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// openAIMediaDoer rewrites the audio and video parts of a chat request into the
+// shape of the openai api, go-openai can only marshal image parts.
+// it is only installed for the requests with such parts, see openAIHTTPDoer.
+type openAIMediaDoer struct {
+	doer openai.HTTPDoer
+}
+
+func (d openAIMediaDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Method != http.MethodPost {
+		return d.doer.Do(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if rewritten, changed, err := rewriteOpenAIMediaParts(body); err == nil && changed {
+		body = rewritten
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	return d.doer.Do(req)
+}
+
+// openAIHTTPDoer returns the doer for the openai client, the body of the
+// request is only rewritten when it has audio or video parts
+func openAIHTTPDoer(client *http.Client, req openai.ChatCompletionRequest) openai.HTTPDoer {
+	for _, msg := range req.Messages {
+		for _, part := range msg.MultiContent {
+			if part.Type == mediaPartTypeInputAudio || part.Type == mediaPartTypeVideoURL {
+				return openAIMediaDoer{doer: client}
+			}
+		}
+	}
+	return client
+}
+
+// openAIMediaPart is the shape go-openai marshals the media parts in
+type openAIMediaPart struct {
+	Type     openai.ChatMessagePartType `json:"type"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// rewriteOpenAIMediaParts rewrites the audio and video parts of the messages, found by their type.
+// the other fields are kept as they are sent, only the messages with such parts are marshalled again.
+func rewriteOpenAIMediaParts(body []byte) ([]byte, bool, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, false, err
+	}
+	var messages []json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for i, raw := range messages {
+		var message map[string]json.RawMessage
+		var parts []json.RawMessage
+		// the content of the text messages is a string
+		if json.Unmarshal(raw, &message) != nil || json.Unmarshal(message["content"], &parts) != nil {
+			continue
+		}
+		partsChanged := false
+		for j, rawPart := range parts {
+			var part openAIMediaPart
+			if json.Unmarshal(rawPart, &part) != nil || part.ImageURL == nil {
+				continue
+			}
+			var rewritten any
+			switch part.Type {
+			case mediaPartTypeInputAudio:
+				media, err := parseMediaValue(schema.PromptVariableTypesAudio, part.ImageURL.URL)
+				if err != nil {
+					return nil, false, err
+				}
+				rewritten = map[string]any{
+					"type": part.Type,
+					"input_audio": map[string]string{
+						"data":   base64.StdEncoding.EncodeToString(media.Data),
+						"format": openAIAudioFormat(media.MIMEType),
+					},
+				}
+			case mediaPartTypeVideoURL:
+				rewritten = map[string]any{
+					"type":      part.Type,
+					"video_url": map[string]string{"url": part.ImageURL.URL},
+				}
+			default:
+				continue
+			}
+			b, err := json.Marshal(rewritten)
+			if err != nil {
+				return nil, false, err
+			}
+			parts[j] = b
+			partsChanged = true
+		}
+		if !partsChanged {
+			continue
+		}
+		content, err := json.Marshal(parts)
+		if err != nil {
+			return nil, false, err
+		}
+		message["content"] = content
+		if messages[i], err = json.Marshal(message); err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	if !changed {
+		return body, false, nil
+	}
+
+	rawMessages, err := json.Marshal(messages)
+	if err != nil {
+		return nil, false, err
+	}
+	payload["messages"] = rawMessages
+	result, err := json.Marshal(payload)
+	return result, true, err
+}
+
+// openAISupportsMedia tells the media the openai protocol takes, input_audio has no url
+func openAISupportsMedia(media mediaValue) bool {
+	return media.Type != schema.PromptVariableTypesAudio || media.URL == ""
+}
+
+// openAIAudioFormat is the format of input_audio, openai takes wav and mp3
+func openAIAudioFormat(mimeType string) string {
+	switch mimeType {
+	case "audio/mpeg", "audio/mp3":
+		return "mp3"
+	case "audio/wav", "audio/x-wav":
+		return "wav"
+	}
+	return strings.TrimPrefix(mimeType, "audio/")
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testImageDataURI = "data:image/png;base64,iVBORw0KGgo="
+	testAudioDataURI = "data:audio/wav;base64,UklGRiQAAABXQVZF"
+)
+
+func newMediaTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{
+			{Role: "system", Prompt: "Describe what you get in {{lang}}."},
+			{Role: "user", Prompt: "Look at {{photo}} and tell me about it."},
+		},
+		Variables: []schema.PromptVariable{
+			{Name: "lang", Type: schema.PromptVariableTypesString},
+			{Name: "photo", Type: schema.PromptVariableTypesImage},
+			{Name: "voice", Type: schema.PromptVariableTypesAudio},
+		},
+	}
+}
+
+func TestMediaValidateVariables(t *testing.T) {
+	variables := newMediaTestPrompt().Variables
+	valid := []map[string]string{
+		{"lang": "English", "photo": testImageDataURI},
+		{"photo": "https://example.com/cat.webp"},
+		{"photo": "https://example.com/cat"},
+		{"voice": testAudioDataURI},
+	}
+	for _, values := range valid {
+		assert.Nil(t, ValidateMediaVariables(variables, values), values)
+	}
+
+	invalid := map[string]map[string]string{
+		"photo: image must be an http(s) url or a data uri": {"photo": "cat.png"},
+		"photo: image/svg+xml is not a supported image":     {"photo": "data:image/svg+xml;base64,PHN2Zz4="},
+		"photo: audio/wav is not a supported image":         {"photo": testAudioDataURI},
+		"photo: invalid base64 data":                        {"photo": "data:image/png;base64,???"},
+		"voice: audio must be a base64 data uri":            {"voice": "data:audio/wav,raw"},
+		"photo: text/html is not a supported image type":    {"photo": "https://example.com/cat.html"},
+	}
+	for message, values := range invalid {
+		assert.ErrorContains(t, ValidateMediaVariables(variables, values), message)
+	}
+
+	maxSize := mediaMaxSize[schema.PromptVariableTypesImage]
+	mediaMaxSize[schema.PromptVariableTypesImage] = 4
+	defer func() { mediaMaxSize[schema.PromptVariableTypesImage] = maxSize }()
+	assert.ErrorContains(t, ValidateMediaVariables(variables, map[string]string{"photo": testImageDataURI}), "image of 8 bytes is larger than 4 bytes")
+}
+
+func TestMediaVariableReferences(t *testing.T) {
+	values := map[string]string{"lang": "English", "photo": testImageDataURI, "voice": "https://example.com/hi.mp3"}
+	result := MediaVariableReferences(newMediaTestPrompt().Variables, values)
+
+	assert.Equal(t, "English", result["lang"])
+	assert.True(t, strings.HasPrefix(result["photo"], "image/png;size=8;sha256="), result["photo"])
+	assert.Equal(t, "https://example.com/hi.mp3", result["voice"])
+	// the payload itself is kept as is
+	assert.Equal(t, testImageDataURI, values["photo"])
+}
+
+func TestMediaMultiContent(t *testing.T) {
+	prompt := newMediaTestPrompt()
+	req := isomorphicAIService{}.buildChatRequest(&ent.Provider{}, prompt, map[string]string{"lang": "English", "photo": testImageDataURI}, "")
+
+	assert.Equal(t, "Describe what you get in English.", req.Messages[0].Content)
+	assert.Empty(t, req.Messages[1].Content)
+	assert.Equal(t, []openai.ChatMessagePart{
+		{Type: openai.ChatMessagePartTypeText, Text: "Look at "},
+		{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: testImageDataURI}},
+		{Type: openai.ChatMessagePartTypeText, Text: " and tell me about it."},
+	}, req.Messages[1].MultiContent)
+
+	// without a value the placeholder stays in the text
+	req = isomorphicAIService{}.buildChatRequest(&ent.Provider{}, prompt, map[string]string{}, "")
+	assert.Equal(t, "Look at {{photo}} and tell me about it.", req.Messages[1].Content)
+}
+
+func TestMediaOpenAIRequest(t *testing.T) {
+	var received struct {
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	prompt := newMediaTestPrompt()
+	prompt.Prompts[1].Prompt = "{{photo}} {{voice}}"
+	provider := &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", DefaultModel: "gpt-4o-audio-preview"}
+	_, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, map[string]string{"photo": testImageDataURI, "voice": testAudioDataURI}, "")
+	assert.Nil(t, err)
+
+	var parts []map[string]interface{}
+	assert.Nil(t, json.Unmarshal(received.Messages[1].Content, &parts))
+	assert.Len(t, parts, 2)
+	assert.Equal(t, "image_url", parts[0]["type"])
+	assert.Equal(t, testImageDataURI, parts[0]["image_url"].(map[string]interface{})["url"])
+	assert.Equal(t, "input_audio", parts[1]["type"])
+	assert.Nil(t, parts[1]["image_url"])
+	assert.Equal(t, map[string]interface{}{"data": "UklGRiQAAABXQVZF", "format": "wav"}, parts[1]["input_audio"])
+
+	// input_audio takes no url
+	_, err = NewIsomorphicAIService().Chat(context.Background(), provider, prompt, map[string]string{"voice": "https://example.com/hi.mp3"}, "")
+	assert.ErrorContains(t, err, "openai: audio urls are not supported")
+}
+
+func TestMediaOpenAIRewrite(t *testing.T) {
+	// a text which talks about the part types is not a media part
+	body := []byte(`{"model":"gpt-4o","seed":9007199254740993,"logit_bias":{"50256":-100},` +
+		`"messages":[{"role":"user","content":"what is \"input_audio\" or video_url?"}]}`)
+	result, changed, err := rewriteOpenAIMediaParts(body)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, body, result)
+
+	body = []byte(`{"model":"gpt-4o","seed":9007199254740993,"messages":[` +
+		`{"role":"system","content":"say \"input_audio\""},` +
+		`{"role":"user","content":[{"type":"text","text":"input_audio"},` +
+		`{"type":"input_audio","image_url":{"url":"` + testAudioDataURI + `"}}]}]}`)
+	result, changed, err = rewriteOpenAIMediaParts(body)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Contains(t, string(result), `"seed":9007199254740993`)
+	assert.Contains(t, string(result), `{"role":"system","content":"say \"input_audio\""}`)
+	assert.Contains(t, string(result), `{"type":"text","text":"input_audio"},{"input_audio":{"data":"UklGRiQAAABXQVZF","format":"wav"},"type":"input_audio"}`)
+
+	// only the requests with audio or video parts are rewritten
+	client := &http.Client{}
+	req := isomorphicAIService{}.buildChatRequest(&ent.Provider{Source: "openai"}, newMediaTestPrompt(), map[string]string{"photo": testImageDataURI}, "")
+	assert.Same(t, client, openAIHTTPDoer(client, req))
+	prompt := newMediaTestPrompt()
+	prompt.Prompts[1].Prompt = "{{voice}}"
+	req = isomorphicAIService{}.buildChatRequest(&ent.Provider{Source: "openai"}, prompt, map[string]string{"voice": testAudioDataURI}, "")
+	assert.IsType(t, openAIMediaDoer{}, openAIHTTPDoer(client, req))
+}
+
+func TestMediaClaude(t *testing.T) {
+	prompt := newMediaTestPrompt()
+	req := isomorphicAIService{}.buildChatRequest(newClaudeTestProvider(""), prompt, map[string]string{"photo": testImageDataURI}, "")
+	payload := claudeService{}.buildRequest(req, false)
+
+	blocks := payload.Messages[0].Content.([]claudeContentBlock)
+	assert.Len(t, blocks, 3)
+	assert.Equal(t, "image", blocks[1].Type)
+	assert.Equal(t, &claudeImageSource{Type: "base64", MediaType: "image/png", Data: "iVBORw0KGgo="}, blocks[1].Source)
+
+	req = isomorphicAIService{}.buildChatRequest(newClaudeTestProvider(""), prompt, map[string]string{"photo": "https://example.com/cat.jpg"}, "")
+	blocks = claudeService{}.buildRequest(req, false).Messages[0].Content.([]claudeContentBlock)
+	assert.Equal(t, &claudeImageSource{Type: "url", URL: "https://example.com/cat.jpg"}, blocks[1].Source)
+
+	prompt.Prompts[1].Prompt = "{{voice}}"
+	_, err := NewIsomorphicAIService().Chat(context.Background(), newClaudeTestProvider(""), prompt, map[string]string{"voice": testAudioDataURI}, "")
+	assert.ErrorContains(t, err, "claude: audio variables are not supported")
+}
+
+func TestMediaGemini(t *testing.T) {
+	var received struct {
+		Contents []struct {
+			Parts []struct {
+				Text       string `json:"text"`
+				InlineData *struct {
+					MIMEType string `json:"mimeType"`
+					Data     string `json:"data"`
+				} `json:"inlineData"`
+				FileData *struct {
+					MIMEType string `json:"mimeType"`
+					FileURI  string `json:"fileUri"`
+				} `json:"fileData"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeGeminiStream(w, `[{"candidates": [{"content": {"role": "model", "parts": [{"text": "A cat"}]}, "finishReason": 1, "index": 0}]}]`)
+	}))
+	defer server.Close()
+
+	prompt := newMediaTestPrompt()
+	prompt.Prompts[1].Prompt = "{{photo}} {{voice}}"
+	values := map[string]string{"photo": "https://example.com/cat.png", "voice": testAudioDataURI}
+	_, err := NewIsomorphicAIService().Chat(context.Background(), newGeminiTestProvider(server.URL), prompt, values, "")
+	assert.Nil(t, err)
+
+	parts := received.Contents[0].Parts
+	assert.Len(t, parts, 2)
+	assert.Equal(t, "image/png", parts[0].FileData.MIMEType)
+	assert.Equal(t, "https://example.com/cat.png", parts[0].FileData.FileURI)
+	assert.Equal(t, "audio/wav", parts[1].InlineData.MIMEType)
+	assert.Equal(t, "UklGRiQAAABXQVZF", parts[1].InlineData.Data)
+}
+
+func TestMediaOllama(t *testing.T) {
+	prompt := newMediaTestPrompt()
+	provider := newOllamaTestProvider("")
+	req := isomorphicAIService{}.buildChatRequest(provider, prompt, map[string]string{"photo": testImageDataURI}, "")
+	payload := ollamaService{}.buildRequest(provider, req, false)
+
+	assert.Equal(t, "Look at  and tell me about it.", payload.Messages[1].Content)
+	assert.Equal(t, []string{base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n"))}, payload.Messages[1].Images)
+
+	_, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, map[string]string{"photo": "https://example.com/cat.png"}, "")
+	assert.ErrorContains(t, err, "ollama: image urls are not supported, send the data inline")
+}
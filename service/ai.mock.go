@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// mockDriver is a deterministic provider for tests and load testing, nothing
+// leaves the process. it reads its behaviour from `Provider.Config`:
+//
+//	reply            fixed reply text, echo the rendered prompt if empty
+//	latencyMs        delay before the reply (and before each stream chunk)
+//	errorStatus      fail with this http status code
+//	errorMessage     fail with this message (status defaults to 500)
+//	errorAfterChunks stream this many chunks before failing, stream only
+//	promptTokens     reported prompt tokens, word count of the prompt if unset
+//	completionTokens reported completion tokens, word count of the reply if unset
+//	chunkSize        words per stream chunk, defaults to 1
+type mockDriver struct {
+}
+
+type mockDriverConfig struct {
+	reply            string
+	latency          time.Duration
+	errorStatus      int
+	errorMessage     string
+	errorAfterChunks int
+	promptTokens     int
+	completionTokens int
+	chunkSize        int
+}
+
+func newMockDriverConfig(provider *ent.Provider) mockDriverConfig {
+	config := provider.Config
+	cfg := mockDriverConfig{
+		reply:            providerConfigString(config, "reply", ""),
+		latency:          time.Duration(providerConfigInt(config, "latencyMs", 0)) * time.Millisecond,
+		errorStatus:      providerConfigInt(config, "errorStatus", 0),
+		errorMessage:     providerConfigString(config, "errorMessage", ""),
+		errorAfterChunks: providerConfigInt(config, "errorAfterChunks", -1),
+		promptTokens:     providerConfigInt(config, "promptTokens", -1),
+		completionTokens: providerConfigInt(config, "completionTokens", -1),
+		chunkSize:        providerConfigInt(config, "chunkSize", 1),
+	}
+	if cfg.errorMessage != "" && cfg.errorStatus == 0 {
+		cfg.errorStatus = http.StatusInternalServerError
+	}
+	if cfg.errorStatus > 0 && cfg.errorMessage == "" {
+		cfg.errorMessage = http.StatusText(cfg.errorStatus)
+	}
+	if cfg.chunkSize <= 0 {
+		cfg.chunkSize = 1
+	}
+	return cfg
+}
+
+func (c mockDriverConfig) error() error {
+	return &openai.APIError{
+		Code:           "mock_error",
+		Type:           "mock_error",
+		Message:        c.errorMessage,
+		HTTPStatus:     http.StatusText(c.errorStatus),
+		HTTPStatusCode: c.errorStatus,
+	}
+}
+
+func (c mockDriverConfig) wait(ctx context.Context) error {
+	if c.latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(c.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c mockDriverConfig) render(req openai.ChatCompletionRequest) (prompt string, reply string) {
+	contents := make([]string, len(req.Messages))
+	for i, msg := range req.Messages {
+		contents[i] = messageText(msg)
+	}
+	prompt = strings.Join(contents, "\n")
+	reply = c.reply
+	if reply == "" {
+		reply = prompt
+	}
+	return
+}
+
+func (c mockDriverConfig) usage(prompt, reply string) openai.Usage {
+	usage := openai.Usage{
+		PromptTokens:     c.promptTokens,
+		CompletionTokens: c.completionTokens,
+	}
+	if usage.PromptTokens < 0 {
+		usage.PromptTokens = len(strings.Fields(prompt))
+	}
+	if usage.CompletionTokens < 0 {
+		usage.CompletionTokens = len(strings.Fields(reply))
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+func mockModel(req openai.ChatCompletionRequest) string {
+	if req.Model == "" {
+		return "mock"
+	}
+	return req.Model
+}
+
+func (o mockDriver) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	cfg := newMockDriverConfig(provider)
+	if err = cfg.wait(ctx); err != nil {
+		return
+	}
+	if cfg.errorStatus > 0 {
+		return reply, cfg.error()
+	}
+
+	prompt, content := cfg.render(req)
+	return openai.ChatCompletionResponse{
+		ID:      "mock",
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   mockModel(req),
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Index: 0,
+				Message: openai.ChatCompletionMessage{
+					Role:    openai.ChatMessageRoleAssistant,
+					Content: content,
+				},
+				FinishReason: openai.FinishReasonStop,
+			},
+		},
+		Usage: cfg.usage(prompt, content),
+	}, nil
+}
+
+func (o mockDriver) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	reply = &ChatStreamResponse{
+		Done:    make(chan bool),
+		Err:     make(chan error),
+		Info:    make(chan openai.Usage),
+		Message: make(chan []openai.ChatCompletionChoice),
+	}
+
+	cfg := newMockDriverConfig(provider)
+	if err = cfg.wait(ctx); err != nil {
+		return reply, err
+	}
+	if cfg.errorStatus > 0 && cfg.errorAfterChunks < 0 {
+		return reply, cfg.error()
+	}
+
+	prompt, content := cfg.render(req)
+	words := strings.SplitAfter(content, " ")
+
+	go func() {
+		chunks := 0
+		for i := 0; i < len(words); i += cfg.chunkSize {
+			if cfg.errorStatus > 0 && chunks == cfg.errorAfterChunks {
+				reply.Err <- cfg.error()
+				return
+			}
+			if i > 0 {
+				if err := cfg.wait(ctx); err != nil {
+					reply.Err <- err
+					return
+				}
+			}
+			end := min(i+cfg.chunkSize, len(words))
+			reply.Message <- []openai.ChatCompletionChoice{
+				{
+					Index:        0,
+					FinishReason: openai.FinishReasonStop,
+					Message: openai.ChatCompletionMessage{
+						Role:    openai.ChatMessageRoleAssistant,
+						Content: strings.Join(words[i:end], ""),
+					},
+				},
+			}
+			chunks++
+		}
+		if cfg.errorStatus > 0 && chunks <= cfg.errorAfterChunks {
+			reply.Err <- cfg.error()
+			return
+		}
+		reply.Info <- cfg.usage(prompt, content)
+		reply.Done <- true
+	}()
+
+	return reply, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{
+			{Role: "system", Prompt: "be brief"},
+			{Role: "user", Prompt: "Hello {{name}}"},
+		},
+	}
+}
+
+func collectMockStream(t *testing.T, reply *ChatStreamResponse) (chunks []string, usage openai.Usage, err error) {
+	for {
+		select {
+		case msg := <-reply.Message:
+			chunks = append(chunks, msg[0].Message.Content)
+		case usage = <-reply.Info:
+		case err = <-reply.Err:
+			return
+		case <-reply.Done:
+			return
+		case <-time.After(time.Second):
+			t.Fatal("mock stream timeout")
+		}
+	}
+}
+
+func TestMockDriverEcho(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{Source: "mock"}
+
+	res, err := svc.Chat(context.Background(), provider, newMockTestPrompt(), map[string]string{"name": "John"}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "mock", res.Model)
+	assert.Equal(t, "be brief\nHello John", res.Choices[0].Message.Content)
+	assert.Equal(t, 4, res.Usage.PromptTokens)
+	assert.Equal(t, 4, res.Usage.CompletionTokens)
+	assert.Equal(t, 8, res.Usage.TotalTokens)
+}
+
+func TestMockDriverFixedReply(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{
+		Source:       "mock",
+		DefaultModel: "gpt-4o",
+		Config: map[string]interface{}{
+			"reply":            "fixed answer",
+			"promptTokens":     float64(100),
+			"completionTokens": "20",
+			"latencyMs":        float64(10),
+		},
+	}
+
+	start := time.Now()
+	res, err := svc.Chat(context.Background(), provider, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	assert.Equal(t, "gpt-4o", res.Model)
+	assert.Equal(t, "fixed answer", res.Choices[0].Message.Content)
+	assert.Equal(t, openai.Usage{PromptTokens: 100, CompletionTokens: 20, TotalTokens: 120}, res.Usage)
+}
+
+func TestMockDriverError(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{
+		Source: "mock",
+		Config: map[string]interface{}{"errorStatus": float64(http.StatusTooManyRequests)},
+	}
+
+	_, err := svc.Chat(context.Background(), provider, newMockTestPrompt(), nil, "")
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.HTTPStatusCode)
+	assert.Equal(t, "Too Many Requests", apiErr.Message)
+
+	_, err = svc.ChatStream(context.Background(), provider, newMockTestPrompt(), nil, "")
+	assert.ErrorAs(t, err, &apiErr)
+}
+
+func TestMockDriverLatencyRespectsContext(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{
+		Source: "mock",
+		Config: map[string]interface{}{"latencyMs": float64(time.Minute.Milliseconds())},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := svc.Chat(ctx, provider, newMockTestPrompt(), nil, "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMockDriverStream(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{
+		Source: "mock",
+		Config: map[string]interface{}{
+			"reply":     "one two three four five",
+			"chunkSize": float64(2),
+		},
+	}
+
+	reply, err := svc.ChatStream(context.Background(), provider, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	chunks, usage, err := collectMockStream(t, reply)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"one two ", "three four ", "five"}, chunks)
+	assert.Equal(t, 5, usage.CompletionTokens)
+}
+
+func TestMockDriverStreamErrorAfterChunks(t *testing.T) {
+	svc := NewIsomorphicAIService()
+	provider := &ent.Provider{
+		Source: "mock",
+		Config: map[string]interface{}{
+			"reply":            "one two three",
+			"errorMessage":     "upstream closed",
+			"errorAfterChunks": float64(2),
+		},
+	}
+
+	reply, err := svc.ChatStream(context.Background(), provider, newMockTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	chunks, _, err := collectMockStream(t, reply)
+	assert.Equal(t, []string{"one ", "two "}, chunks)
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusInternalServerError, apiErr.HTTPStatusCode)
+	assert.Equal(t, "upstream closed", apiErr.Message)
+}
+
+type staticTestDriver struct {
+	mockDriver
+}
+
+func TestRegisterProviderDriver(t *testing.T) {
+	assert.IsType(t, openAICompatibleService{}, GetProviderDriver("deepseek"))
+	assert.IsType(t, claudeService{}, GetProviderDriver("Claude"))
+
+	RegisterProviderDriver("static-test", staticTestDriver{})
+	assert.IsType(t, staticTestDriver{}, GetProviderDriver("static-test"))
+}
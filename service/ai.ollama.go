@@ -0,0 +1,388 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+const ollamaDefaultEndpoint = "http://localhost:11434"
+
+// ollamaService talks to the native api of Ollama (and llama.cpp servers that
+// implement it) instead of the openai shim. the endpoint is the server url,
+// the api key is optional and sent as bearer token for servers behind a gateway.
+// extra settings are read from `Provider.Config`:
+//
+//	numCtx    size of the context window of the model
+//	keepAlive how long the model stays loaded after the call, e.g. `5m`
+type ollamaService struct {
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	// the name of the tool of a `tool` message
+	ToolName string `json:"tool_name,omitempty"`
+	// base64 encoded images
+	Images []string `json:"images,omitempty"`
+}
+
+// ollamaToolCall is like the openai one, but the arguments are an object
+// and the id is optional
+type ollamaToolCall struct {
+	ID       string `json:"id,omitempty"`
+	Function struct {
+		Name      string         `json:"name"`
+		Arguments map[string]any `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaOptions struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NumPredict       int      `json:"num_predict,omitempty"`
+	NumCtx           int      `json:"num_ctx,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  float32  `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32  `json:"frequency_penalty,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model     string          `json:"model"`
+	Messages  []ollamaMessage `json:"messages"`
+	Stream    bool            `json:"stream"`
+	Options   ollamaOptions   `json:"options"`
+	KeepAlive string          `json:"keep_alive,omitempty"`
+	// `json` or a JSON Schema the reply must match
+	Format json.RawMessage `json:"format,omitempty"`
+	Tools  []openai.Tool   `json:"tools,omitempty"`
+}
+
+// ollamaResponse is the reply of a call, and each line of a stream.
+// the counts are only set on the last line, when done is true.
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+func (o ollamaService) buildRequest(provider *ent.Provider, req openai.ChatCompletionRequest, stream bool) ollamaRequest {
+	result := ollamaRequest{
+		Model:  req.Model,
+		Stream: stream,
+		Options: ollamaOptions{
+			NumPredict:       req.MaxTokens,
+			NumCtx:           providerConfigInt(provider.Config, "numCtx", 0),
+			Stop:             req.Stop,
+			Seed:             req.Seed,
+			PresencePenalty:  req.PresencePenalty,
+			FrequencyPenalty: req.FrequencyPenalty,
+		},
+		KeepAlive: providerConfigString(provider.Config, "keepAlive", ""),
+	}
+	if req.Temperature > 0 {
+		result.Options.Temperature = &req.Temperature
+	}
+	if req.TopP > 0 {
+		result.Options.TopP = &req.TopP
+	}
+	if format := req.ResponseFormat; format != nil {
+		switch {
+		case format.Type == openai.ChatCompletionResponseFormatTypeJSONSchema && format.JSONSchema != nil:
+			result.Format, _ = format.JSONSchema.Schema.MarshalJSON()
+		case format.Type == openai.ChatCompletionResponseFormatTypeJSONObject:
+			result.Format = json.RawMessage(`"json"`)
+		}
+	}
+	// ollama has no tool_choice, the model always decides by itself
+	if policy, _ := toolChoicePolicy(req.ToolChoice); policy != ToolChoiceNone {
+		result.Tools = req.Tools
+	}
+	for _, msg := range req.Messages {
+		message := ollamaMessage{
+			Role:    msg.Role,
+			Content: messageText(msg),
+		}
+		for _, part := range msg.MultiContent {
+			if media, ok := mediaFromPart(part); ok {
+				message.Images = append(message.Images, base64.StdEncoding.EncodeToString(media.Data))
+			}
+		}
+		if msg.Role == openai.ChatMessageRoleTool {
+			message.ToolName = toolCallName(req.Messages, msg.ToolCallID)
+		}
+		for _, call := range msg.ToolCalls {
+			toolCall := ollamaToolCall{ID: call.ID}
+			toolCall.Function.Name = call.Function.Name
+			toolCall.Function.Arguments = toolArguments(call.Function.Arguments)
+			message.ToolCalls = append(message.ToolCalls, toolCall)
+		}
+		result.Messages = append(result.Messages, message)
+	}
+	return result
+}
+
+// ollamaToolCalls converts the calls of a reply, `calls` numbers the ones without id
+func ollamaToolCalls(toolCalls []ollamaToolCall, calls *int) []openai.ToolCall {
+	if len(toolCalls) == 0 {
+		return nil
+	}
+	result := make([]openai.ToolCall, len(toolCalls))
+	for i, call := range toolCalls {
+		id := call.ID
+		if id == "" {
+			id = fmt.Sprintf("call_%d", *calls)
+		}
+		*calls++
+		args, _ := json.Marshal(call.Function.Arguments)
+		result[i] = openai.ToolCall{
+			ID:   id,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: string(args),
+			},
+		}
+	}
+	return result
+}
+
+func ollamaChoice(message ollamaMessage, doneReason string, calls *int) openai.ChatCompletionChoice {
+	choice := openai.ChatCompletionChoice{
+		Index: 0,
+		Message: openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			Content:   message.Content,
+			ToolCalls: ollamaToolCalls(message.ToolCalls, calls),
+		},
+		FinishReason: ollamaFinishReason(doneReason),
+	}
+	if len(choice.Message.ToolCalls) > 0 {
+		choice.FinishReason = openai.FinishReasonToolCalls
+	}
+	return choice
+}
+
+// ollamaSupportsMedia tells the media ollama takes, inline images only
+func ollamaSupportsMedia(media mediaValue) bool {
+	return media.Type == schema.PromptVariableTypesImage && media.URL == ""
+}
+
+func (o ollamaService) doRequest(ctx context.Context, provider *ent.Provider, method, path string, payload any) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		buf, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	endpoint := ollamaDefaultEndpoint
+	if provider.Endpoint != "" {
+		endpoint = provider.Endpoint
+	}
+	endpoint = strings.TrimSuffix(strings.TrimSuffix(endpoint, "/"), "/api")
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, endpoint+path, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if provider.ApiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+provider.ApiKey)
+	}
+
+	client, err := GetProviderHTTPClient(provider)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, ollamaAPIError(resp)
+	}
+	return resp, nil
+}
+
+// ollamaAPIError converts the `{"error": "..."}` body into an openai.APIError
+func ollamaAPIError(resp *http.Response) error {
+	buf, _ := io.ReadAll(resp.Body)
+	var errResp ollamaResponse
+	if err := json.Unmarshal(buf, &errResp); err != nil || errResp.Error == "" {
+		errResp.Error = strings.TrimSpace(string(buf))
+	}
+	return &openai.APIError{
+		Message:        errResp.Error,
+		HTTPStatus:     resp.Status,
+		HTTPStatusCode: resp.StatusCode,
+	}
+}
+
+func ollamaFinishReason(doneReason string) openai.FinishReason {
+	if doneReason == "length" {
+		return openai.FinishReasonLength
+	}
+	return openai.FinishReasonStop
+}
+
+func ollamaUsage(result ollamaResponse) openai.Usage {
+	return openai.Usage{
+		PromptTokens:     result.PromptEvalCount,
+		CompletionTokens: result.EvalCount,
+		TotalTokens:      result.PromptEvalCount + result.EvalCount,
+	}
+}
+
+func (o ollamaService) Chat(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply openai.ChatCompletionResponse, err error) {
+	if err = requireMediaSupport("ollama", req, ollamaSupportsMedia); err != nil {
+		return
+	}
+	payload := o.buildRequest(provider, req, false)
+	logrus.Debugln("ollama:chat: prompts need to send", payload.Messages)
+
+	resp, err := o.doRequest(ctx, provider, http.MethodPost, "/api/chat", payload)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var result ollamaResponse
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return
+	}
+
+	calls := 0
+	reply = openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   result.Model,
+		Choices: []openai.ChatCompletionChoice{
+			ollamaChoice(result.Message, result.DoneReason, &calls),
+		},
+		Usage: ollamaUsage(result),
+	}
+	return
+}
+
+func (o ollamaService) ChatStream(
+	ctx context.Context,
+	provider *ent.Provider,
+	req openai.ChatCompletionRequest,
+) (reply *ChatStreamResponse, err error) {
+	reply = &ChatStreamResponse{
+		Done:    make(chan bool),
+		Err:     make(chan error),
+		Info:    make(chan openai.Usage),
+		Message: make(chan []openai.ChatCompletionChoice),
+	}
+
+	if err := requireMediaSupport("ollama", req, ollamaSupportsMedia); err != nil {
+		return reply, err
+	}
+	payload := o.buildRequest(provider, req, true)
+	logrus.Debugln("ollama:stream: prompts need to send", payload.Messages)
+
+	resp, err := o.doRequest(ctx, provider, http.MethodPost, "/api/chat", payload)
+	if err != nil {
+		return reply, err
+	}
+
+	go func() {
+		defer resp.Body.Close()
+
+		// the stream is newline delimited json, one ollamaResponse per line
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		calls := 0
+
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var event ollamaResponse
+			if err := json.Unmarshal(line, &event); err != nil {
+				reply.Err <- err
+				return
+			}
+			if event.Error != "" {
+				reply.Err <- fmt.Errorf("ollama: %s", event.Error)
+				return
+			}
+
+			// the tool calls arrive whole, in a line of their own
+			if event.Message.Content != "" || len(event.Message.ToolCalls) > 0 {
+				reply.Message <- []openai.ChatCompletionChoice{
+					ollamaChoice(event.Message, event.DoneReason, &calls),
+				}
+			}
+
+			if event.Done {
+				reply.Info <- ollamaUsage(event)
+				reply.Done <- true
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			reply.Err <- err
+			return
+		}
+		reply.Err <- io.ErrUnexpectedEOF
+	}()
+
+	return reply, nil
+}
+
+// ListModels returns the models pulled on the server
+func (o ollamaService) ListModels(ctx context.Context, provider *ent.Provider) ([]string, error) {
+	resp, err := o.doRequest(ctx, provider, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	models := make([]string, 0, len(result.Models))
+	for _, model := range result.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
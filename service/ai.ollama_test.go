@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newOllamaTestProvider(endpoint string) *ent.Provider {
+	return &ent.Provider{
+		Source:       "ollama",
+		Endpoint:     endpoint,
+		DefaultModel: "llama3.2",
+		Temperature:  0.5,
+		MaxTokens:    128,
+		Config:       map[string]interface{}{"numCtx": float64(8192), "keepAlive": "10m"},
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	var received ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+		assert.Empty(t, r.Header.Get("Authorization"))
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"model": "llama3.2",
+			"message": {"role": "assistant", "content": "Bonjour John"},
+			"done": true,
+			"done_reason": "length",
+			"prompt_eval_count": 20,
+			"eval_count": 3
+		}`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(
+		context.Background(),
+		newOllamaTestProvider(server.URL),
+		newClaudeTestPrompt(),
+		map[string]string{"lang": "French", "name": "John"},
+		"",
+	)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "llama3.2", received.Model)
+	assert.False(t, received.Stream)
+	assert.Equal(t, 128, received.Options.NumPredict)
+	assert.Equal(t, 8192, received.Options.NumCtx)
+	assert.Equal(t, float32(0.5), *received.Options.Temperature)
+	assert.Equal(t, "10m", received.KeepAlive)
+	assert.Len(t, received.Messages, 3)
+	assert.Equal(t, "system", received.Messages[1].Role)
+	assert.Equal(t, "Reply in French.", received.Messages[1].Content)
+	assert.Equal(t, "Hello John", received.Messages[2].Content)
+
+	assert.Equal(t, "Bonjour John", res.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonLength, res.Choices[0].FinishReason)
+	assert.Equal(t, 20, res.Usage.PromptTokens)
+	assert.Equal(t, 3, res.Usage.CompletionTokens)
+	assert.Equal(t, 23, res.Usage.TotalTokens)
+}
+
+func TestOllamaChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer gateway-key", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": "model \"llama3.2\" not found, try pulling it first"}`)
+	}))
+	defer server.Close()
+
+	provider := newOllamaTestProvider(server.URL + "/api")
+	provider.ApiKey = "gateway-key"
+
+	svc := NewIsomorphicAIService()
+	_, err := svc.Chat(context.Background(), provider, newClaudeTestPrompt(), nil, "")
+	apiErr, ok := err.(*openai.APIError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, apiErr.HTTPStatusCode)
+	assert.Contains(t, apiErr.Message, "try pulling it first")
+}
+
+func TestOllamaChatStream(t *testing.T) {
+	var received ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		lines := []string{
+			`{"model": "llama3.2", "message": {"role": "assistant", "content": "Bonjour"}, "done": false}`,
+			`{"model": "llama3.2", "message": {"role": "assistant", "content": " John"}, "done": false}`,
+			``,
+			`{"model": "llama3.2", "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 20, "eval_count": 2}`,
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	reply, err := svc.ChatStream(context.Background(), newOllamaTestProvider(server.URL), newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	chunks, usage, err := collectMockStream(t, reply)
+	assert.Nil(t, err)
+	assert.True(t, received.Stream)
+	assert.Equal(t, []string{"Bonjour", " John"}, chunks)
+	assert.Equal(t, 20, usage.PromptTokens)
+	assert.Equal(t, 2, usage.CompletionTokens)
+	assert.Equal(t, 22, usage.TotalTokens)
+}
+
+func TestOllamaChatStreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"model": "llama3.2", "message": {"role": "assistant", "content": "Bon"}, "done": false}`)
+		fmt.Fprintln(w, `{"error": "out of memory"}`)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	reply, err := svc.ChatStream(context.Background(), newOllamaTestProvider(server.URL), newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	chunks, _, err := collectMockStream(t, reply)
+	assert.Equal(t, []string{"Bon"}, chunks)
+	assert.ErrorContains(t, err, "out of memory")
+}
+
+func TestOllamaListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"models": [
+			{"name": "llama3.2:latest", "size": 2019393189},
+			{"name": "qwen2.5-coder:7b", "size": 4683087332}
+		]}`)
+	}))
+	defer server.Close()
+
+	models, err := ListProviderModels(context.Background(), newOllamaTestProvider(server.URL))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"llama3.2:latest", "qwen2.5-coder:7b"}, models)
+
+	_, err = ListProviderModels(context.Background(), newClaudeTestProvider(""))
+	assert.ErrorIs(t, err, ErrorModelListingNotSupported)
+}
+
+func TestOllamaCosts(t *testing.T) {
+	model := GetProviderCostModel(newOllamaTestProvider(""))
+	setTestModelPrices(t, model, defaultTestModelPrices(model))
+	cost, err := GetCosts(context.Background(), nil, model, time.Now())
+	assert.Nil(t, err)
+	assert.Zero(t, cost.InputTokenCostInCents)
+	assert.Zero(t, cost.OutputTokenCostInCents)
+}
@@ -65,7 +65,7 @@ func (o openAIService) Chat(
 
 	logrus.Debugln("openai:chat: prompts need to send", prompts)
 	for _, prompt := range prompts {
-		content := replacePlaceholdersLegacy(prompt.Prompt, variables)
+		content := renderPromptRow(prompt, variables)
 		pt := openai.ChatCompletionMessage{
 			Role:    prompt.Role,
 			Content: content,
@@ -114,7 +114,7 @@ func (o openAIService) ChatStream(
 
 	logrus.Debugln("openai:stream: prompts need to send", prompts, variables)
 	for _, prompt := range prompts {
-		content := replacePlaceholdersLegacy(prompt.Prompt, variables)
+		content := renderPromptRow(prompt, variables)
 		pt := openai.ChatCompletionMessage{
 			Role:    prompt.Role,
 			Content: content,
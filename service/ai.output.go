@@ -0,0 +1,183 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const defaultOutputSchemaName = "output"
+
+var ErrorInvalidOutput = errors.New("reply does not match the output schema")
+
+// outputResponseFormat asks the provider for a reply matching the output schema of the prompt
+func outputResponseFormat(prompt ent.Prompt) *openai.ChatCompletionResponseFormat {
+	output := prompt.OutputSchema
+	if output == nil || len(output.Schema) == 0 {
+		return nil
+	}
+	name := output.Name
+	if name == "" {
+		name = defaultOutputSchemaName
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   name,
+			Schema: outputSchemaMarshaler(output.Schema),
+			Strict: output.Strict,
+		},
+	}
+}
+
+type outputSchemaMarshaler map[string]interface{}
+
+func (s outputSchemaMarshaler) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}(s))
+}
+
+// ParsePromptOutput parses the reply and validates it against the output schema.
+// the reply may be wrapped in a markdown code block by providers without native support.
+func ParsePromptOutput(output *schema.OutputSchema, content string) (result any, err error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+	}
+	if err = json.Unmarshal([]byte(content), &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidOutput, err)
+	}
+	if err = validateJSONSchema(output.Schema, result, "$"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrorInvalidOutput, err)
+	}
+	return
+}
+
+// validateJSONSchema covers the keywords used by structured outputs: type,
+// enum, properties, required, additionalProperties, items and nullable.
+// other keywords are left to the provider.
+func validateJSONSchema(def map[string]interface{}, value any, path string) error {
+	if value == nil && def["nullable"] == true {
+		return nil
+	}
+	if enum, ok := def["enum"].([]interface{}); ok {
+		if !slices.ContainsFunc(enum, func(v interface{}) bool { return jsonEqual(v, value) }) {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+	if anyOf, ok := def["anyOf"].([]interface{}); ok {
+		for _, sub := range anyOf {
+			if subDef, ok := sub.(map[string]interface{}); ok && validateJSONSchema(subDef, value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: does not match any of the schemas", path)
+	}
+
+	types := []string{}
+	switch t := def["type"].(type) {
+	case string:
+		types = append(types, t)
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	if len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return jsonTypeMatches(t, value) }) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := def["properties"].(map[string]interface{})
+		if required, ok := def["required"].([]interface{}); ok {
+			for _, key := range required {
+				if name, ok := key.(string); ok {
+					if _, exists := v[name]; !exists {
+						return fmt.Errorf("%s.%s: is required", path, name)
+					}
+				}
+			}
+		}
+		for key, item := range v {
+			propDef, ok := properties[key].(map[string]interface{})
+			if !ok {
+				if def["additionalProperties"] == false {
+					return fmt.Errorf("%s.%s: is not allowed", path, key)
+				}
+				if additional, ok := def["additionalProperties"].(map[string]interface{}); ok {
+					propDef = additional
+				}
+			}
+			if propDef == nil {
+				continue
+			}
+			if err := validateJSONSchema(propDef, item, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items, ok := def["items"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range v {
+			if err := validateJSONSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(t string, value any) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == t
+}
+
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func jsonEqual(a, b any) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestOutputSchema() *schema.OutputSchema {
+	var def map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["name", "tags"],
+		"properties": {
+			"name": {"type": "string"},
+			"age": {"type": ["integer", "null"]},
+			"level": {"enum": ["junior", "senior"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`), &def)
+	return &schema.OutputSchema{Name: "person", Schema: def, Strict: true}
+}
+
+func TestOutputSchemaValid(t *testing.T) {
+	result, err := ParsePromptOutput(newTestOutputSchema(), `{"name": "John", "age": 42, "level": "senior", "tags": ["a"]}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "John", result.(map[string]interface{})["name"])
+
+	// providers without native support tend to wrap the json in a code block
+	_, err = ParsePromptOutput(newTestOutputSchema(), "```json\n{\"name\": \"John\", \"age\": null, \"tags\": []}\n```")
+	assert.Nil(t, err)
+}
+
+func TestOutputSchemaInvalid(t *testing.T) {
+	cases := map[string]string{
+		`Hello John`:              "invalid character",
+		`{"tags": []}`:            "$.name: is required",
+		`{"name": 1, "tags": []}`: "$.name: expected string, got number",
+		`{"name": "John", "age": 4.2, "tags": []}`:      "$.age: expected integer or null, got number",
+		`{"name": "John", "level": "lead", "tags": []}`: "$.level: lead is not one of [junior senior]",
+		`{"name": "John", "tags": ["a", 1]}`:            "$.tags[1]: expected string",
+		`{"name": "John", "tags": [], "x": 1}`:          "$.x: is not allowed",
+	}
+	for content, message := range cases {
+		_, err := ParsePromptOutput(newTestOutputSchema(), content)
+		assert.ErrorIs(t, err, ErrorInvalidOutput, content)
+		assert.ErrorContains(t, err, message, content)
+	}
+}
+
+func TestOutputSchemaResponseFormat(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	prompt := newTransportTestPrompt()
+	prompt.OutputSchema = newTestOutputSchema()
+	provider := &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", DefaultModel: "gpt-4o"}
+
+	_, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, nil, "")
+	assert.Nil(t, err)
+
+	format := received["response_format"].(map[string]interface{})
+	assert.Equal(t, "json_schema", format["type"])
+	jsonSchema := format["json_schema"].(map[string]interface{})
+	assert.Equal(t, "person", jsonSchema["name"])
+	assert.Equal(t, true, jsonSchema["strict"])
+	assert.Equal(t, prompt.OutputSchema.Schema, jsonSchema["schema"])
+}
+
+func TestOutputSchemaOllamaFormat(t *testing.T) {
+	prompt := newTransportTestPrompt()
+	prompt.OutputSchema = newTestOutputSchema()
+	provider := newOllamaTestProvider("")
+
+	req := isomorphicAIService{}.buildChatRequest(provider, prompt, nil, "")
+	payload := ollamaService{}.buildRequest(provider, req, false)
+
+	var format map[string]interface{}
+	assert.Nil(t, json.Unmarshal(payload.Format, &format))
+	assert.Equal(t, prompt.OutputSchema.Schema, format)
+}
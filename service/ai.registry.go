@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ProviderDriver translates the openai shaped request into the protocol of
+// a provider source and the reply back into the openai types.
+type ProviderDriver interface {
+	Chat(
+		ctx context.Context,
+		provider *ent.Provider,
+		req openai.ChatCompletionRequest,
+	) (reply openai.ChatCompletionResponse, err error)
+	ChatStream(
+		ctx context.Context,
+		provider *ent.Provider,
+		req openai.ChatCompletionRequest,
+	) (reply *ChatStreamResponse, err error)
+}
+
+// ProviderModelLister is implemented by the drivers that can list the models
+// available on the provider.
+type ProviderModelLister interface {
+	ListModels(ctx context.Context, provider *ent.Provider) ([]string, error)
+}
+
+// ProviderExplicitTopP is implemented by the drivers whose models take either the
+// temperature or top_p. the top_p of a provider always has a value, these drivers
+// only get the top_p the prompt sets.
+type ProviderExplicitTopP interface {
+	ExplicitTopPOnly() bool
+}
+
+// sources without a dedicated driver (deepseek, etc.) speak the openai protocol
+const defaultProviderSource = "openai"
+
+var ErrorModelListingNotSupported = errors.New("model listing is not supported by the provider source")
+
+var (
+	providerDriversMu sync.RWMutex
+	providerDrivers   = map[string]ProviderDriver{}
+)
+
+func init() {
+	RegisterProviderDriver(defaultProviderSource, openAICompatibleService{})
+	RegisterProviderDriver("azure-openai", azureOpenAIService{})
+	RegisterProviderDriver("claude", claudeService{})
+	RegisterProviderDriver("gemini", geminiService{})
+	RegisterProviderDriver("ollama", ollamaService{})
+	RegisterProviderDriver("mock", mockDriver{})
+}
+
+// RegisterProviderDriver makes the driver available for the `Provider.Source`.
+// registering a source twice replaces the previous driver.
+func RegisterProviderDriver(source string, driver ProviderDriver) {
+	providerDriversMu.Lock()
+	defer providerDriversMu.Unlock()
+	providerDrivers[strings.ToLower(source)] = driver
+}
+
+// GetProviderDriver returns the driver registered for the source, or the
+// openai compatible driver if there is none.
+func GetProviderDriver(source string) ProviderDriver {
+	providerDriversMu.RLock()
+	defer providerDriversMu.RUnlock()
+	if driver, ok := providerDrivers[strings.ToLower(source)]; ok {
+		return driver
+	}
+	return providerDrivers[defaultProviderSource]
+}
+
+// ListProviderModels lists the models of the provider if its driver supports it
+func ListProviderModels(ctx context.Context, provider *ent.Provider) ([]string, error) {
+	lister, ok := GetProviderDriver(provider.Source).(ProviderModelLister)
+	if !ok {
+		return nil, ErrorModelListingNotSupported
+	}
+	return lister.ListModels(ctx, provider)
+}
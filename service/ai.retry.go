@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+// RetryPolicy is read from `Provider.Config`:
+//
+//	retryMaxAttempts       attempts of a call including the first one, defaults to 1 (no retry)
+//	retryBackoffMs         backoff before the first retry, doubled on each retry. defaults to 500
+//	retryMaxBackoffMs      the cap of the backoff, defaults to 10000
+//	retryRespectRetryAfter wait for the `Retry-After` header if the provider sends it, defaults to true
+type RetryPolicy struct {
+	MaxAttempts       int
+	Backoff           time.Duration
+	MaxBackoff        time.Duration
+	RespectRetryAfter bool
+}
+
+func NewRetryPolicy(provider *ent.Provider) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts:       providerConfigInt(provider.Config, "retryMaxAttempts", 1),
+		Backoff:           time.Duration(providerConfigInt(provider.Config, "retryBackoffMs", 500)) * time.Millisecond,
+		MaxBackoff:        time.Duration(providerConfigInt(provider.Config, "retryMaxBackoffMs", 10_000)) * time.Millisecond,
+		RespectRetryAfter: providerConfigBool(provider.Config, "retryRespectRetryAfter", true),
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = policy.Backoff
+	}
+	return policy
+}
+
+// delay returns how long to wait before the next attempt. `attempt` starts
+// from 1. false if the provider asks to wait longer than the max backoff.
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if p.RespectRetryAfter && retryAfter > 0 {
+		return retryAfter, retryAfter <= p.MaxBackoff
+	}
+	backoff := p.Backoff << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	// full jitter on the second half, so instances don't retry at the same time
+	if half := int64(backoff / 2); half > 0 {
+		backoff = time.Duration(half + rand.Int64N(half+1))
+	}
+	return backoff, true
+}
+
+type retryAfterContextKey struct{}
+
+// retryAfterRecorder keeps the `Retry-After` header of the last response of a call
+type retryAfterRecorder struct {
+	mu    sync.Mutex
+	after time.Duration
+}
+
+func (r *retryAfterRecorder) set(after time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.after = after
+}
+
+// take returns the recorded duration and resets it for the next attempt
+func (r *retryAfterRecorder) take() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	after := r.after
+	r.after = 0
+	return after
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retryAfterTransport records the `Retry-After` header of throttled responses
+// into the recorder of the request context. the drivers convert the response
+// into an error and the header would be lost otherwise.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryAfterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, err
+	}
+	recorder, ok := r.Context().Value(retryAfterContextKey{}).(*retryAfterRecorder)
+	if ok {
+		recorder.set(parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()))
+	}
+	return resp, err
+}
+
+// retryable reports whether another attempt on the same provider may succeed
+func retryable(err error) bool {
+	switch fallbackErrorClass(err) {
+	case FallbackOn5xx, FallbackOnRateLimit, FallbackOnTimeout, FallbackOnNetwork:
+		return true
+	}
+	return false
+}
+
+// withRetry calls fn until it succeeds, the error is not retryable or the
+// attempts of the provider's retry policy are used up. the circuit breaker of
+// the provider is checked before and fed after each attempt.
+func withRetry[T any](ctx context.Context, provider *ent.Provider, fn func(ctx context.Context) (T, error)) (result T, err error) {
+	policy := NewRetryPolicy(provider)
+	breaker := NewCircuitBreaker(provider)
+	recorder := &retryAfterRecorder{}
+	ctx = context.WithValue(ctx, retryAfterContextKey{}, recorder)
+
+	for attempt := 1; ; attempt++ {
+		if err = breaker.Allow(ctx); err != nil {
+			return
+		}
+		result, err = fn(ctx)
+		if err == nil {
+			breaker.Success(ctx)
+			return
+		}
+		if !retryable(err) {
+			return
+		}
+		breaker.Failure(ctx)
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			return
+		}
+		delay, ok := policy.delay(attempt, recorder.take())
+		if !ok {
+			return
+		}
+		logrus.Warnf("provider %d(%s) attempt %d failed, retry in %s: %v", provider.ID, provider.Source, attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (o isomorphicAIService) chat(ctx context.Context, provider *ent.Provider, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if err := checkContextWindow(provider, req); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	return withRetry(ctx, provider, func(ctx context.Context) (openai.ChatCompletionResponse, error) {
+		return GetProviderDriver(provider.Source).Chat(ctx, provider, req)
+	})
+}
+
+// chatStream retries the stream only if it fails to start
+func (o isomorphicAIService) chatStream(ctx context.Context, provider *ent.Provider, req openai.ChatCompletionRequest) (*ChatStreamResponse, error) {
+	if err := checkContextWindow(provider, req); err != nil {
+		return nil, err
+	}
+	return withRetry(ctx, provider, func(ctx context.Context) (*ChatStreamResponse, error) {
+		return GetProviderDriver(provider.Source).ChatStream(ctx, provider, req)
+	})
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := NewRetryPolicy(&ent.Provider{})
+	assert.Equal(t, 1, policy.MaxAttempts)
+	assert.Equal(t, 500*time.Millisecond, policy.Backoff)
+	assert.True(t, policy.RespectRetryAfter)
+
+	policy = NewRetryPolicy(&ent.Provider{Config: map[string]interface{}{
+		"retryMaxAttempts":  float64(3),
+		"retryBackoffMs":    float64(100),
+		"retryMaxBackoffMs": float64(300),
+	}})
+	delay, ok := policy.delay(1, 0)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+	assert.LessOrEqual(t, delay, 100*time.Millisecond)
+
+	delay, _ = policy.delay(5, 0)
+	assert.LessOrEqual(t, delay, 300*time.Millisecond)
+
+	delay, ok = policy.delay(1, 200*time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, 200*time.Millisecond, delay)
+
+	// the provider asks to wait too long
+	_, ok = policy.delay(1, time.Minute)
+	assert.False(t, ok)
+}
+
+func TestRetryParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 10*time.Second, parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func newRetryTestServer(statuses []int, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	calls := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		if call <= len(statuses) {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(statuses[call-1])
+			fmt.Fprint(w, `{"type": "error", "error": {"type": "overloaded_error", "message": "try later"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "msg_01",
+			"content": [{"type": "text", "text": "Hello"}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 1, "output_tokens": 1}
+		}`)
+	}))
+	return server, calls
+}
+
+func TestRetryChat(t *testing.T) {
+	server, calls := newRetryTestServer([]int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, "")
+	defer server.Close()
+
+	provider := newClaudeTestProvider(server.URL)
+	provider.Config = map[string]interface{}{
+		"retryMaxAttempts": float64(3),
+		"retryBackoffMs":   float64(1),
+	}
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(context.Background(), provider, newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", res.Choices[0].Message.Content)
+	assert.EqualValues(t, 3, calls.Load())
+}
+
+func TestRetryChatGiveUp(t *testing.T) {
+	svc := NewIsomorphicAIService()
+
+	// attempts are used up
+	server, calls := newRetryTestServer([]int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, "")
+	provider := newClaudeTestProvider(server.URL)
+	provider.Config = map[string]interface{}{
+		"retryMaxAttempts": float64(2),
+		"retryBackoffMs":   float64(1),
+	}
+	_, err := svc.Chat(context.Background(), provider, newClaudeTestPrompt(), nil, "")
+	var apiErr *openai.APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusServiceUnavailable, apiErr.HTTPStatusCode)
+	assert.EqualValues(t, 2, calls.Load())
+	server.Close()
+
+	// client errors are not retried
+	server, calls = newRetryTestServer([]int{http.StatusBadRequest}, "")
+	provider.Endpoint = server.URL
+	_, err = svc.Chat(context.Background(), provider, newClaudeTestPrompt(), nil, "")
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 1, calls.Load())
+	server.Close()
+
+	// the provider asks to wait longer than the max backoff
+	server, calls = newRetryTestServer([]int{http.StatusTooManyRequests}, "120")
+	provider.Endpoint = server.URL
+	_, err = svc.Chat(context.Background(), provider, newClaudeTestPrompt(), nil, "")
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 1, calls.Load())
+	server.Close()
+}
+
+func TestRetryCircuitState(t *testing.T) {
+	assert.Equal(t, CircuitClosed, circuitState(0, 5, 0))
+	assert.Equal(t, CircuitClosed, circuitState(4, 5, 0))
+	assert.Equal(t, CircuitOpen, circuitState(5, 5, time.Second))
+	assert.Equal(t, CircuitHalfOpen, circuitState(5, 5, -2))
+	assert.Equal(t, CircuitClosed, circuitState(5, 0, 0))
+
+	// no redis in the unit tests, the breaker lets everything through
+	breaker := NewCircuitBreaker(&ent.Provider{ID: 1})
+	assert.Nil(t, breaker.Allow(context.Background()))
+	health, err := breaker.Health(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, CircuitClosed, health.State)
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	ToolChoiceAuto     = "auto"
+	ToolChoiceNone     = "none"
+	ToolChoiceRequired = "required"
+)
+
+// promptTools returns the tools of the prompt and the tool_choice of the request.
+// the choice is either one of the ToolChoice* policies or the name of a tool.
+func promptTools(prompt ent.Prompt) (tools []openai.Tool, toolChoice any) {
+	if len(prompt.Tools) == 0 {
+		return nil, nil
+	}
+	for _, tool := range prompt.Tools {
+		tools = append(tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  toolParameters(tool.Parameters),
+			},
+		})
+	}
+	switch prompt.ToolChoice {
+	case "":
+		return tools, nil
+	case ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+		return tools, prompt.ToolChoice
+	}
+	return tools, openai.ToolChoice{
+		Type:     openai.ToolTypeFunction,
+		Function: openai.ToolFunction{Name: prompt.ToolChoice},
+	}
+}
+
+// toolParameters defaults to an object without properties, providers reject a missing schema
+func toolParameters(parameters map[string]interface{}) map[string]interface{} {
+	if len(parameters) == 0 {
+		return map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+	return parameters
+}
+
+// toolChoicePolicy splits the tool_choice of a request into the policy and the
+// name of the tool the model must call. the policy is `required` for a named tool.
+func toolChoicePolicy(toolChoice any) (policy string, name string) {
+	switch choice := toolChoice.(type) {
+	case string:
+		return choice, ""
+	case openai.ToolChoice:
+		return ToolChoiceRequired, choice.Function.Name
+	}
+	return ToolChoiceAuto, ""
+}
+
+// ValidateToolChoice checks the tool choice refers to a policy or a tool of the prompt
+func ValidateToolChoice(tools []schema.PromptTool, toolChoice string) error {
+	switch toolChoice {
+	case "", ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired:
+		return nil
+	}
+	if slices.ContainsFunc(tools, func(tool schema.PromptTool) bool { return tool.Name == toolChoice }) {
+		return nil
+	}
+	return fmt.Errorf("tool choice %s is not a tool of the prompt", toolChoice)
+}
+
+// promptRowMessage converts a row into a message. tool calls and their
+// results are sent as is, only the text of the row is rendered.
+// rows with media variables become multi content messages.
+func promptRowMessage(
+	row schema.PromptRow,
+	variables map[string]string,
+	mediaTypes map[string]schema.PromptVariableTypes,
+) openai.ChatCompletionMessage {
+	msg := openai.ChatCompletionMessage{
+		Role:       row.Role,
+		Content:    row.Prompt,
+		ToolCallID: row.ToolCallID,
+		ToolCalls:  toOpenAIToolCalls(row.ToolCalls),
+	}
+	if row.Role == openai.ChatMessageRoleTool || len(row.ToolCalls) > 0 {
+		return msg
+	}
+	var parts []openai.ChatMessagePart
+	if row.Appended {
+		parts = splitMultiContentLegacy(row.Prompt, variables, mediaTypes)
+	} else {
+		parts = renderMultiContent(row.Prompt, variables, mediaTypes)
+	}
+	if parts != nil {
+		msg.Content = ""
+		msg.MultiContent = parts
+		return msg
+	}
+	msg.Content = renderPromptRow(row, variables)
+	return msg
+}
+
+func toOpenAIToolCalls(calls []schema.ToolCall) []openai.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	result := make([]openai.ToolCall, len(calls))
+	for i, call := range calls {
+		result[i] = openai.ToolCall{
+			ID:   call.ID,
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		}
+	}
+	return result
+}
+
+// ToolCallsFromOpenAI converts the tool calls of a reply for the public api
+func ToolCallsFromOpenAI(calls []openai.ToolCall) []schema.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	result := make([]schema.ToolCall, len(calls))
+	for i, call := range calls {
+		result[i] = schema.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+		}
+	}
+	return result
+}
+
+// toolCallName finds the name of the tool of a call in the previous messages,
+// for the providers which match the results by name instead of id
+func toolCallName(messages []openai.ChatCompletionMessage, toolCallID string) string {
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			if call.ID == toolCallID {
+				return call.Function.Name
+			}
+		}
+	}
+	return toolCallID
+}
+
+// toolArguments decodes the arguments of a call, which must be a JSON object
+func toolArguments(arguments string) map[string]any {
+	args := map[string]any{}
+	if strings.TrimSpace(arguments) == "" {
+		return args
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return map[string]any{}
+	}
+	return args
+}
+
+// toolResult decodes the result of a tool as an object, or wraps it otherwise
+func toolResult(content string) map[string]any {
+	result := map[string]any{}
+	if err := json.Unmarshal([]byte(content), &result); err == nil {
+		return result
+	}
+	return map[string]any{"content": content}
+}
+
+// toolCallsAccumulator joins the tool call deltas of an openai stream, the
+// arguments arrive in pieces and only the first delta carries the id and name
+type toolCallsAccumulator struct {
+	calls []openai.ToolCall
+}
+
+func (a *toolCallsAccumulator) add(deltas []openai.ToolCall) {
+	for _, delta := range deltas {
+		index := len(a.calls)
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(a.calls) <= index {
+			a.calls = append(a.calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+		call := &a.calls[index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+}
+
+// choices returns the joined calls as a stream chunk, nil if there is none
+func (a *toolCallsAccumulator) choices() []openai.ChatCompletionChoice {
+	if len(a.calls) == 0 {
+		return nil
+	}
+	return []openai.ChatCompletionChoice{toolCallsChoice(a.calls)}
+}
+
+func toolCallsChoice(calls []openai.ToolCall) openai.ChatCompletionChoice {
+	return openai.ChatCompletionChoice{
+		Index:        0,
+		FinishReason: openai.FinishReasonToolCalls,
+		Message: openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			ToolCalls: calls,
+		},
+	}
+}
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newToolsTestPrompt is the second turn of a conversation: the model asked
+// for the weather and the client sent back the result
+func newToolsTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{
+			{Role: "system", Prompt: "You are a helpful assistant."},
+			{Role: "user", Prompt: "What is the weather in {{city}}?"},
+			{Role: "assistant", ToolCalls: []schema.ToolCall{
+				{ID: "call_1", Name: "weather", Arguments: `{"city": "Paris"}`},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Prompt: `{"celsius": 21}`},
+		},
+		Tools: []schema.PromptTool{
+			{
+				Name:        "weather",
+				Description: "current weather of a city",
+				Parameters: map[string]interface{}{
+					"type":       "object",
+					"required":   []interface{}{"city"},
+					"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+				},
+			},
+			{Name: "time"},
+		},
+	}
+}
+
+func collectToolCallsStream(t *testing.T, reply *ChatStreamResponse) (content string, calls []openai.ToolCall) {
+	for {
+		select {
+		case msg := <-reply.Message:
+			content += msg[0].Message.Content
+			calls = append(calls, msg[0].Message.ToolCalls...)
+		case <-reply.Info:
+		case err := <-reply.Err:
+			t.Fatal(err)
+		case <-reply.Done:
+			return
+		case <-time.After(time.Second):
+			t.Fatal("stream timeout")
+		}
+	}
+}
+
+func TestToolsChatRequest(t *testing.T) {
+	prompt := newToolsTestPrompt()
+	prompt.ToolChoice = "weather"
+	req := isomorphicAIService{}.buildChatRequest(&ent.Provider{DefaultModel: "gpt-4o"}, prompt, map[string]string{"city": "Paris"}, "")
+
+	assert.Len(t, req.Tools, 2)
+	assert.Equal(t, "weather", req.Tools[0].Function.Name)
+	assert.Equal(t, prompt.Tools[0].Parameters, req.Tools[0].Function.Parameters)
+	assert.Equal(t, "object", req.Tools[1].Function.Parameters.(map[string]interface{})["type"])
+	assert.Equal(t, openai.ToolChoice{Type: openai.ToolTypeFunction, Function: openai.ToolFunction{Name: "weather"}}, req.ToolChoice)
+
+	assert.Equal(t, "What is the weather in Paris?", req.Messages[1].Content)
+	assert.Equal(t, "call_1", req.Messages[2].ToolCalls[0].ID)
+	assert.Equal(t, openai.ChatMessageRoleTool, req.Messages[3].Role)
+	assert.Equal(t, "call_1", req.Messages[3].ToolCallID)
+	assert.Equal(t, `{"celsius": 21}`, req.Messages[3].Content)
+
+	prompt.ToolChoice = ToolChoiceRequired
+	req = isomorphicAIService{}.buildChatRequest(&ent.Provider{}, prompt, nil, "")
+	assert.Equal(t, ToolChoiceRequired, req.ToolChoice)
+
+	req = isomorphicAIService{}.buildChatRequest(&ent.Provider{}, newClaudeTestPrompt(), nil, "")
+	assert.Nil(t, req.Tools)
+	assert.Nil(t, req.ToolChoice)
+}
+
+func TestToolChoiceValidation(t *testing.T) {
+	tools := newToolsTestPrompt().Tools
+	for _, choice := range []string{"", ToolChoiceAuto, ToolChoiceNone, ToolChoiceRequired, "weather"} {
+		assert.Nil(t, ValidateToolChoice(tools, choice), choice)
+	}
+	assert.ErrorContains(t, ValidateToolChoice(tools, "search"), "search is not a tool of the prompt")
+	assert.Error(t, ValidateToolChoice(nil, "weather"))
+}
+
+func TestToolsOpenAIChat(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "chatcmpl-1",
+			"object": "chat.completion",
+			"choices": [{"index": 0, "message": {"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_2", "type": "function", "function": {"name": "time", "arguments": "{}"}}
+			]}, "finish_reason": "tool_calls"}],
+			"usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}
+		}`)
+	}))
+	defer server.Close()
+
+	prompt := newToolsTestPrompt()
+	prompt.ToolChoice = ToolChoiceAuto
+	provider := &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", DefaultModel: "gpt-4o"}
+	res, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, map[string]string{"city": "Paris"}, "")
+	assert.Nil(t, err)
+
+	assert.Equal(t, "auto", received["tool_choice"])
+	assert.Len(t, received["tools"], 2)
+	messages := received["messages"].([]interface{})
+	assert.Equal(t, "call_1", messages[3].(map[string]interface{})["tool_call_id"])
+
+	assert.Equal(t, openai.FinishReasonToolCalls, res.Choices[0].FinishReason)
+	assert.Equal(t, []schema.ToolCall{{ID: "call_2", Name: "time", Arguments: "{}"}}, ToolCallsFromOpenAI(res.Choices[0].Message.ToolCalls))
+}
+
+func TestToolsOpenAIChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"id": "1", "choices": [{"index": 0, "delta": {"role": "assistant", "tool_calls": [{"index": 0, "id": "call_2", "type": "function", "function": {"name": "weather", "arguments": ""}}]}}]}`,
+			`{"id": "1", "choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "{\"city\": "}}]}}]}`,
+			`{"id": "1", "choices": [{"index": 0, "delta": {"tool_calls": [{"index": 0, "function": {"arguments": "\"Lyon\"}"}}]}}]}`,
+			`{"id": "1", "choices": [{"index": 0, "delta": {"tool_calls": [{"index": 1, "id": "call_3", "type": "function", "function": {"name": "time", "arguments": "{}"}}]}}]}`,
+			`{"id": "1", "choices": [{"index": 0, "delta": {}, "finish_reason": "tool_calls"}]}`,
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", DefaultModel: "gpt-4o"}
+	reply, err := NewIsomorphicAIService().ChatStream(context.Background(), provider, newToolsTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	content, calls := collectToolCallsStream(t, reply)
+	assert.Empty(t, content)
+	assert.Equal(t, []schema.ToolCall{
+		{ID: "call_2", Name: "weather", Arguments: `{"city": "Lyon"}`},
+		{ID: "call_3", Name: "time", Arguments: "{}"},
+	}, ToolCallsFromOpenAI(calls))
+}
+
+func TestToolsClaudeRequest(t *testing.T) {
+	prompt := newToolsTestPrompt()
+	prompt.Prompts = append(prompt.Prompts, schema.PromptRow{Role: "tool", ToolCallID: "call_0", Prompt: "12:00"})
+	prompt.ToolChoice = ToolChoiceRequired
+	req := isomorphicAIService{}.buildChatRequest(newClaudeTestProvider(""), prompt, map[string]string{"city": "Paris"}, "")
+	payload := claudeService{}.buildRequest(req, false)
+
+	assert.Len(t, payload.Messages, 3)
+	toolUse := payload.Messages[1].Content.([]claudeContentBlock)
+	assert.Equal(t, "tool_use", toolUse[0].Type)
+	assert.Equal(t, "weather", toolUse[0].Name)
+	assert.JSONEq(t, `{"city": "Paris"}`, string(toolUse[0].Input))
+
+	// consecutive results are merged into a single user message
+	results := payload.Messages[2].Content.([]claudeContentBlock)
+	assert.Equal(t, openai.ChatMessageRoleUser, payload.Messages[2].Role)
+	assert.Len(t, results, 2)
+	assert.Equal(t, "call_1", results[0].ToolUseID)
+	assert.Equal(t, "12:00", results[1].Content)
+
+	assert.Len(t, payload.Tools, 2)
+	assert.Equal(t, prompt.Tools[0].Parameters, payload.Tools[0].InputSchema)
+	assert.Equal(t, &claudeToolChoice{Type: "any"}, payload.ToolChoice)
+
+	prompt.ToolChoice = "time"
+	req = isomorphicAIService{}.buildChatRequest(newClaudeTestProvider(""), prompt, nil, "")
+	assert.Equal(t, &claudeToolChoice{Type: "tool", Name: "time"}, claudeService{}.buildRequest(req, false).ToolChoice)
+}
+
+func TestToolsClaudeChat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "msg_01",
+			"model": "claude-sonnet-4",
+			"role": "assistant",
+			"content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_01", "name": "weather", "input": {"city": "Lyon"}}
+			],
+			"stop_reason": "tool_use",
+			"usage": {"input_tokens": 10, "output_tokens": 5}
+		}`)
+	}))
+	defer server.Close()
+
+	res, err := NewIsomorphicAIService().Chat(context.Background(), newClaudeTestProvider(server.URL), newToolsTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Let me check.", res.Choices[0].Message.Content)
+	assert.Equal(t, openai.FinishReasonToolCalls, res.Choices[0].FinishReason)
+	assert.Equal(t, []schema.ToolCall{{ID: "toolu_01", Name: "weather", Arguments: `{"city": "Lyon"}`}}, ToolCallsFromOpenAI(res.Choices[0].Message.ToolCalls))
+}
+
+func TestToolsClaudeChatStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`{"type": "message_start", "message": {"id": "msg_01", "usage": {"input_tokens": 10, "output_tokens": 1}}}`,
+			`{"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}`,
+			`{"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Let me check."}}`,
+			`{"type": "content_block_stop", "index": 0}`,
+			`{"type": "content_block_start", "index": 1, "content_block": {"type": "tool_use", "id": "toolu_01", "name": "weather", "input": {}}}`,
+			`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "{\"city\": "}}`,
+			`{"type": "content_block_delta", "index": 1, "delta": {"type": "input_json_delta", "partial_json": "\"Lyon\"}"}}`,
+			`{"type": "content_block_stop", "index": 1}`,
+			`{"type": "content_block_start", "index": 2, "content_block": {"type": "tool_use", "id": "toolu_02", "name": "time", "input": {}}}`,
+			`{"type": "content_block_stop", "index": 2}`,
+			`{"type": "message_delta", "delta": {"stop_reason": "tool_use"}, "usage": {"output_tokens": 8}}`,
+			`{"type": "message_stop"}`,
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "data: %s\n\n", event)
+		}
+	}))
+	defer server.Close()
+
+	reply, err := NewIsomorphicAIService().ChatStream(context.Background(), newClaudeTestProvider(server.URL), newToolsTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	content, calls := collectToolCallsStream(t, reply)
+	assert.Equal(t, "Let me check.", content)
+	assert.Equal(t, []schema.ToolCall{
+		{ID: "toolu_01", Name: "weather", Arguments: `{"city": "Lyon"}`},
+		{ID: "toolu_02", Name: "time", Arguments: "{}"},
+	}, ToolCallsFromOpenAI(calls))
+}
+
+func TestToolsGeminiChat(t *testing.T) {
+	var received struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text         string `json:"text"`
+				FunctionCall *struct {
+					Name string         `json:"name"`
+					Args map[string]any `json:"args"`
+				} `json:"functionCall"`
+				FunctionResponse *struct {
+					Name     string         `json:"name"`
+					Response map[string]any `json:"response"`
+				} `json:"functionResponse"`
+			} `json:"parts"`
+		} `json:"contents"`
+		Tools []struct {
+			FunctionDeclarations []struct {
+				Name       string `json:"name"`
+				Parameters struct {
+					Type       any            `json:"type"`
+					Required   []string       `json:"required"`
+					Properties map[string]any `json:"properties"`
+				} `json:"parameters"`
+			} `json:"functionDeclarations"`
+		} `json:"tools"`
+		ToolConfig struct {
+			FunctionCallingConfig struct {
+				Mode                 any      `json:"mode"`
+				AllowedFunctionNames []string `json:"allowedFunctionNames"`
+			} `json:"functionCallingConfig"`
+		} `json:"toolConfig"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		writeGeminiStream(w, `[{
+			"candidates": [{
+				"content": {"role": "model", "parts": [
+					{"functionCall": {"name": "weather", "args": {"city": "Lyon"}}},
+					{"functionCall": {"name": "time", "args": {}}}
+				]},
+				"finishReason": 1,
+				"index": 0
+			}]
+		}]`)
+	}))
+	defer server.Close()
+
+	prompt := newToolsTestPrompt()
+	prompt.ToolChoice = "weather"
+	res, err := NewIsomorphicAIService().Chat(context.Background(), newGeminiTestProvider(server.URL), prompt, map[string]string{"city": "Paris"}, "")
+	assert.Nil(t, err)
+
+	assert.Len(t, received.Contents, 3)
+	assert.Equal(t, "weather", received.Contents[1].Parts[0].FunctionCall.Name)
+	assert.Equal(t, "Paris", received.Contents[1].Parts[0].FunctionCall.Args["city"])
+	// gemini matches the results by the name of the function
+	assert.Equal(t, "weather", received.Contents[2].Parts[0].FunctionResponse.Name)
+	assert.Equal(t, float64(21), received.Contents[2].Parts[0].FunctionResponse.Response["celsius"])
+
+	declarations := received.Tools[0].FunctionDeclarations
+	assert.Len(t, declarations, 2)
+	assert.Equal(t, []string{"city"}, declarations[0].Parameters.Required)
+	assert.Contains(t, declarations[0].Parameters.Properties, "city")
+	assert.Equal(t, []string{"weather"}, received.ToolConfig.FunctionCallingConfig.AllowedFunctionNames)
+
+	assert.Equal(t, openai.FinishReasonToolCalls, res.Choices[0].FinishReason)
+	assert.Equal(t, []schema.ToolCall{
+		{ID: "call_0", Name: "weather", Arguments: `{"city":"Lyon"}`},
+		{ID: "call_1", Name: "time", Arguments: "{}"},
+	}, ToolCallsFromOpenAI(res.Choices[0].Message.ToolCalls))
+}
+
+func TestToolsOllama(t *testing.T) {
+	var received ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"model": "llama3.2",
+			"message": {"role": "assistant", "content": "", "tool_calls": [
+				{"function": {"name": "weather", "arguments": {"city": "Lyon"}}}
+			]},
+			"done": true,
+			"done_reason": "stop"
+		}`)
+	}))
+	defer server.Close()
+
+	res, err := NewIsomorphicAIService().Chat(context.Background(), newOllamaTestProvider(server.URL), newToolsTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	assert.Len(t, received.Tools, 2)
+	assert.Equal(t, "Paris", received.Messages[2].ToolCalls[0].Function.Arguments["city"])
+	assert.Equal(t, "weather", received.Messages[3].ToolName)
+
+	assert.Equal(t, openai.FinishReasonToolCalls, res.Choices[0].FinishReason)
+	assert.Equal(t, []schema.ToolCall{{ID: "call_0", Name: "weather", Arguments: `{"city":"Lyon"}`}}, ToolCallsFromOpenAI(res.Choices[0].Message.ToolCalls))
+
+	// ollama has no tool_choice, the tools are not sent at all
+	prompt := newToolsTestPrompt()
+	prompt.ToolChoice = ToolChoiceNone
+	req := isomorphicAIService{}.buildChatRequest(newOllamaTestProvider(""), prompt, nil, "")
+	assert.Nil(t, ollamaService{}.buildRequest(newOllamaTestProvider(""), req, false).Tools)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"entgo.io/ent/dialect/sql"
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/project"
+	"github.com/PromptPal/PromptPal/ent/promptcall"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	BudgetPeriodMonthly = "monthly"
+	BudgetPeriodCustom  = "custom"
+)
+
+var ErrorBudgetExceeded = errors.New("the project exceeded its budget")
+
+// ProjectSpend is the cost of the calls of a project in the current period of its budget
+type ProjectSpend struct {
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+	SpendCents  float64
+}
+
+// BudgetPeriod returns the period of the budget the time is in. a project
+// without a budget is counted by calendar months.
+func BudgetPeriod(budget *schema.ProjectBudget, at time.Time) (start, end time.Time) {
+	if budget != nil && budget.Period == BudgetPeriodCustom && budget.PeriodDays > 0 {
+		length := time.Duration(budget.PeriodDays) * 24 * time.Hour
+		elapsed := at.Sub(budget.StartAt)
+		periods := elapsed / length
+		if elapsed < 0 && elapsed%length != 0 {
+			periods--
+		}
+		start = budget.StartAt.Add(periods * length)
+		return start, start.Add(length)
+	}
+	at = at.UTC()
+	start = time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+func projectSpendKey(projectID int, periodStart time.Time) string {
+	return fmt.Sprintf("project-spend:%d:%d", projectID, periodStart.Unix())
+}
+
+// the running totals outlive their period a little, for the late calls
+func projectSpendTTL(periodEnd time.Time) time.Duration {
+	return time.Until(periodEnd) + 24*time.Hour
+}
+
+// sumProjectCallCosts aggregates the cost of the calls of the project in the period
+func sumProjectCallCosts(ctx context.Context, projectID int, start, end time.Time) (float64, error) {
+	var result []struct {
+		// null without a call
+		Sum *float64 `json:"sum"`
+	}
+	err := EntClient.
+		PromptCall.
+		Query().
+		Where(
+			promptcall.HasProjectWith(project.ID(projectID)),
+			promptcall.CreateTimeGTE(start),
+			promptcall.CreateTimeLT(end),
+		).
+		Aggregate(func(s *sql.Selector) string {
+			return sql.As(sql.Sum(s.C(promptcall.FieldCostCents)), "sum")
+		}).
+		Scan(ctx, &result)
+	if err != nil || len(result) == 0 || result[0].Sum == nil {
+		return 0, err
+	}
+	return *result[0].Sum, nil
+}
+
+// GetProjectSpend returns the spend of the project in the current period of its budget.
+// the running total lives in redis, it is summed from the calls when missing.
+func GetProjectSpend(ctx context.Context, pj ent.Project, at time.Time) (spend ProjectSpend, err error) {
+	spend.PeriodStart, spend.PeriodEnd = BudgetPeriod(pj.Budget, at)
+	if redisClient == nil {
+		spend.SpendCents, err = sumProjectCallCosts(ctx, pj.ID, spend.PeriodStart, spend.PeriodEnd)
+		return
+	}
+
+	key := projectSpendKey(pj.ID, spend.PeriodStart)
+	spend.SpendCents, err = redisClient.Get(ctx, key).Float64()
+	if err == nil || !errors.Is(err, redis.Nil) {
+		return
+	}
+	total, err := sumProjectCallCosts(ctx, pj.ID, spend.PeriodStart, spend.PeriodEnd)
+	if err != nil {
+		return
+	}
+	// another instance may have loaded it meanwhile, its total wins
+	if err = redisClient.SetNX(ctx, key, total, projectSpendTTL(spend.PeriodEnd)).Err(); err != nil {
+		return
+	}
+	spend.SpendCents, err = redisClient.Get(ctx, key).Float64()
+	return
+}
+
+// CheckProjectBudget fails once the project reached the hard limit of its budget.
+// a broken redis never blocks the calls.
+func CheckProjectBudget(ctx context.Context, pj ent.Project) error {
+	if pj.Budget == nil || pj.Budget.HardLimitCents <= 0 {
+		return nil
+	}
+	spend, err := GetProjectSpend(ctx, pj, time.Now())
+	if err != nil {
+		logrus.Warnln("budget:", err)
+		return nil
+	}
+	if spend.SpendCents >= pj.Budget.HardLimitCents {
+		return fmt.Errorf(
+			"%w: %.4f of %.4f cents spent, the period ends at %s",
+			ErrorBudgetExceeded,
+			spend.SpendCents,
+			pj.Budget.HardLimitCents,
+			spend.PeriodEnd.Format(time.RFC3339),
+		)
+	}
+	return nil
+}
+
+// the running total is only incremented once it is loaded, a missing one is
+// summed from the calls including the new one
+var incrementProjectSpend = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("INCRBYFLOAT", KEYS[1], ARGV[1])
+end
+return false
+`)
+
+// AddProjectSpend adds the cost of a call to the running total of the project.
+// it tells if the call reached the soft limit of the budget, once per period.
+func AddProjectSpend(ctx context.Context, pj ent.Project, costCents float64, at time.Time) (softLimitReached bool) {
+	if redisClient == nil || costCents <= 0 {
+		return false
+	}
+	// the request may be cancelled already, the spend should be recorded anyway
+	ctx = context.WithoutCancel(ctx)
+	start, end := BudgetPeriod(pj.Budget, at)
+	key := projectSpendKey(pj.ID, start)
+
+	err := incrementProjectSpend.Run(ctx, redisClient, []string{key}, costCents).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		logrus.Warnln("budget:", err)
+		return false
+	}
+	if pj.Budget == nil || pj.Budget.SoftLimitCents <= 0 {
+		return false
+	}
+	spend, err := GetProjectSpend(ctx, pj, at)
+	if err != nil {
+		logrus.Warnln("budget:", err)
+		return false
+	}
+	if spend.SpendCents < pj.Budget.SoftLimitCents {
+		return false
+	}
+	notified, err := redisClient.SetNX(ctx, key+":soft", 1, projectSpendTTL(end)).Result()
+	if err != nil {
+		logrus.Warnln("budget:", err)
+		return false
+	}
+	return notified
+}
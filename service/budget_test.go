@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudgetPeriodMonthly(t *testing.T) {
+	at := time.Date(2025, 2, 14, 10, 0, 0, 0, time.UTC)
+	for _, budget := range []*schema.ProjectBudget{nil, {Period: BudgetPeriodMonthly}} {
+		start, end := BudgetPeriod(budget, at)
+		assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), start)
+		assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), end)
+	}
+
+	// the months are counted in UTC
+	start, _ := BudgetPeriod(nil, time.Date(2025, 3, 1, 1, 0, 0, 0, time.FixedZone("UTC+8", 8*3600)))
+	assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), start)
+}
+
+func TestBudgetPeriodCustom(t *testing.T) {
+	budget := &schema.ProjectBudget{
+		Period:     BudgetPeriodCustom,
+		PeriodDays: 7,
+		StartAt:    time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	start, end := BudgetPeriod(budget, time.Date(2025, 1, 16, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2025, 1, 22, 0, 0, 0, 0, time.UTC), end)
+
+	start, _ = BudgetPeriod(budget, budget.StartAt)
+	assert.Equal(t, budget.StartAt, start)
+
+	// the periods before the start go backwards
+	start, end = BudgetPeriod(budget, time.Date(2024, 12, 30, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, budget.StartAt, end)
+
+	// a custom period without a length is monthly
+	budget.PeriodDays = 0
+	start, _ = BudgetPeriod(budget, time.Date(2025, 1, 16, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), start)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/conversation"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var ErrorConversationNotFound = errors.New("conversation not found or expired")
+
+// ConversationHistory returns the turns sent after the rows of the prompt, windowed by the
+// message and token limits of the conversation. the tokens are counted like the provider does.
+func ConversationHistory(conv *ent.Conversation, provider *ent.Provider, model string) []schema.PromptRow {
+	return truncateConversation(conv.Messages, conv.MaxMessages, conv.MaxTokens, conversationRowTokens(provider, model))
+}
+
+// truncateConversation keeps the latest messages within the limits, 0 means no limit
+func truncateConversation(messages []schema.PromptRow, maxMessages, maxTokens int, countTokens func(row schema.PromptRow) int) []schema.PromptRow {
+	start := 0
+	if maxMessages > 0 && len(messages) > maxMessages {
+		start = len(messages) - maxMessages
+	}
+	if maxTokens > 0 {
+		tokens := 0
+		for i := len(messages) - 1; i >= start; i-- {
+			tokens += countTokens(messages[i])
+			if tokens > maxTokens {
+				start = i + 1
+				break
+			}
+		}
+	}
+	// a tool result without the call before it is rejected by the providers
+	for start < len(messages) && messages[start].Role == openai.ChatMessageRoleTool {
+		start++
+	}
+	return messages[start:]
+}
+
+// conversationRowTokens counts the tokens a turn takes with the counter of the provider,
+// the overhead of the reply is counted once for the request, not for every turn.
+func conversationRowTokens(provider *ent.Provider, model string) func(row schema.PromptRow) int {
+	counter := GetTokenCounter(provider.Source)
+	overhead := counter.CountTokens(model, nil)
+	return func(row schema.PromptRow) int {
+		row.Appended = true
+		msg := promptRowMessage(row, nil, nil)
+		return counter.CountTokens(model, []openai.ChatCompletionMessage{msg}) - overhead
+	}
+}
+
+// IsConversationExpired tells if the conversation outlived its ttl
+func IsConversationExpired(conv *ent.Conversation) bool {
+	return !conv.ExpireAt.After(time.Now())
+}
+
+// conversationAppendRetries is how many times the turns are appended again after another run updated the conversation
+const conversationAppendRetries = 5
+
+var ErrorConversationConflict = errors.New("the conversation is updated by too many runs at once")
+
+// AppendConversationTurns stores the turns of a run and extends the life of the conversation.
+// the stored messages are windowed by the limits of the conversation. the turns are appended
+// to the latest messages, a conversation updated by another run in the meantime is read again.
+func AppendConversationTurns(ctx context.Context, conv *ent.Conversation, turns []schema.PromptRow, provider *ent.Provider, model string) error {
+	// the request may be cancelled already, the turns should be stored anyway
+	ctx = context.WithoutCancel(ctx)
+	countTokens := conversationRowTokens(provider, model)
+	for range conversationAppendRetries {
+		messages := append(slices.Clone(conv.Messages), turns...)
+		updated, err := EntClient.Conversation.
+			Update().
+			Where(conversation.ID(conv.ID), conversation.Version(conv.Version)).
+			SetMessages(truncateConversation(messages, conv.MaxMessages, conv.MaxTokens, countTokens)).
+			SetExpireAt(time.Now().Add(time.Duration(conv.TTL) * time.Second)).
+			AddVersion(1).
+			Save(ctx)
+		if err != nil {
+			return err
+		}
+		if updated > 0 {
+			return nil
+		}
+		conv, err = EntClient.Conversation.Get(ctx, conv.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return ErrorConversationConflict
+}
+
+// DeleteExpiredConversations removes the conversations past their ttl
+func DeleteExpiredConversations(ctx context.Context) (int, error) {
+	return EntClient.Conversation.
+		Delete().
+		Where(conversation.ExpireAtLTE(time.Now())).
+		Exec(ctx)
+}
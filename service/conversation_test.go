@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func newConversationTestMessages() []schema.PromptRow {
+	return []schema.PromptRow{
+		{Role: "user", Prompt: "What is the weather in Paris?"},
+		{Role: "assistant", ToolCalls: []schema.ToolCall{{ID: "call_1", Name: "weather", Arguments: `{"city": "Paris"}`}}},
+		{Role: "tool", ToolCallID: "call_1", Prompt: `{"celsius": 21}`},
+		{Role: "assistant", Prompt: "It is 21 degrees."},
+		{Role: "user", Prompt: "And in London?"},
+	}
+}
+
+// the mock provider estimates 4 characters per token and 3 tokens per message
+var conversationTestProvider = &ent.Provider{Source: "mock"}
+
+func TestConversationTruncateUnlimited(t *testing.T) {
+	messages := newConversationTestMessages()
+	countTokens := conversationRowTokens(conversationTestProvider, "")
+	assert.Equal(t, messages, truncateConversation(messages, 0, 0, countTokens))
+	assert.Empty(t, truncateConversation(nil, 2, 10, countTokens))
+}
+
+func TestConversationTruncateMaxMessages(t *testing.T) {
+	messages := newConversationTestMessages()
+	countTokens := conversationRowTokens(conversationTestProvider, "")
+	assert.Equal(t, messages[3:], truncateConversation(messages, 2, 0, countTokens))
+	// the window never starts with a tool result
+	assert.Equal(t, messages[3:], truncateConversation(messages, 3, 0, countTokens))
+	assert.Equal(t, messages[1:], truncateConversation(messages, 4, 0, countTokens))
+}
+
+func TestConversationTruncateMaxTokens(t *testing.T) {
+	messages := newConversationTestMessages()
+	countTokens := conversationRowTokens(conversationTestProvider, "")
+	// "And in London?" takes 7 tokens, "It is 21 degrees." 8
+	assert.Equal(t, messages[4:], truncateConversation(messages, 0, 7, countTokens))
+	assert.Equal(t, messages[3:], truncateConversation(messages, 0, 15, countTokens))
+	assert.Equal(t, messages, truncateConversation(messages, 0, 1000, countTokens))
+
+	// a single message above the budget is dropped too
+	long := []schema.PromptRow{{Role: "user", Prompt: strings.Repeat("a", 400)}}
+	assert.Empty(t, truncateConversation(long, 0, 50, countTokens))
+}
+
+func TestConversationRowTokens(t *testing.T) {
+	countTokens := conversationRowTokens(conversationTestProvider, "")
+	assert.Equal(t, 3, countTokens(schema.PromptRow{}))
+	assert.Equal(t, 7, countTokens(schema.PromptRow{Role: "user", Prompt: "And in London?"}))
+	assert.Equal(t, 9, countTokens(newConversationTestMessages()[1]))
+	// the messages are not templates
+	assert.Equal(t, 7, countTokens(schema.PromptRow{Role: "user", Prompt: "{{#if a}}{{/if}}"}))
+
+	// the openai models count with their tokenizer
+	row := schema.PromptRow{Role: "user", Prompt: "And in London?"}
+	openAIProvider := &ent.Provider{Source: "openai"}
+	expected := tiktokenCounter{}.CountTokens("gpt-4o", []openai.ChatCompletionMessage{{Role: "user", Content: "And in London?"}}) - tiktokenTokensPerReply
+	assert.Equal(t, expected, conversationRowTokens(openAIProvider, "gpt-4o")(row))
+}
+
+func TestAppendConversationTurns(t *testing.T) {
+	client := setupTestDB(t)
+	defer client.Close()
+	ctx := context.Background()
+
+	user, err := client.User.Create().
+		SetName("Conversation User").
+		SetAddr("test_service_conversation").
+		SetEmail("test_service_conversation@annatarhe.com").
+		SetPhone("").
+		SetLang("en").
+		SetLevel(1).
+		Save(ctx)
+	assert.Nil(t, err)
+	pj, err := client.Project.Create().
+		SetName("Conversation Project").
+		SetCreatorID(user.ID).
+		Save(ctx)
+	assert.Nil(t, err)
+	conv, err := client.Conversation.Create().
+		SetProjectId(pj.ID).
+		SetMessages([]schema.PromptRow{}).
+		SetMaxTokens(30).
+		SetExpireAt(time.Now().Add(time.Hour)).
+		Save(ctx)
+	assert.Nil(t, err)
+
+	// both runs loaded the conversation before the other one stored its turns
+	first := []schema.PromptRow{{Role: "user", Prompt: "Hi"}, {Role: "assistant", Prompt: "Hello"}}
+	second := []schema.PromptRow{{Role: "user", Prompt: "Bye"}, {Role: "assistant", Prompt: "See you"}}
+	assert.Nil(t, AppendConversationTurns(ctx, conv, first, conversationTestProvider, ""))
+	assert.Nil(t, AppendConversationTurns(ctx, conv, second, conversationTestProvider, ""))
+
+	stored, err := client.Conversation.Get(ctx, conv.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stored.Version)
+	assert.Equal(t, append(slices.Clone(first), second...), stored.Messages)
+
+	// the messages above the token budget are not stored
+	long := []schema.PromptRow{{Role: "user", Prompt: strings.Repeat("a", 100)}, {Role: "assistant", Prompt: "ok"}}
+	assert.Nil(t, AppendConversationTurns(ctx, stored, long, conversationTestProvider, ""))
+	stored, err = client.Conversation.Get(ctx, conv.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, long[1:], stored.Messages)
+}
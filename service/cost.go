@@ -1,10 +1,19 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/modelprice"
+	"github.com/go-redis/cache/v9"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
 )
 
 var ErrorNoCostFound = errors.New("no cost found for")
@@ -14,12 +23,18 @@ type ModelCost struct {
 	StartFrom              time.Time
 	InputTokenCostInCents  float64
 	OutputTokenCostInCents float64
+	// nil for the input and output prices
+	CachedInputTokenCostInCents *float64
+	ReasoningTokenCostInCents   *float64
 }
 
-var costMap map[string][]ModelCost
+const ollamaCostModel = "ollama"
+
+// defaultModelCosts are the prices loaded into the model_prices table by SeedModelPrices
+var defaultModelCosts map[string][]ModelCost
 
 func init() {
-	costMap = map[string][]ModelCost{
+	defaultModelCosts = map[string][]ModelCost{
 		"o1-mini": {
 			ModelCost{
 				StartFrom:              time.Date(2024, 9, 12, 0, 0, 0, 0, time.UTC),
@@ -125,6 +140,14 @@ func init() {
 				OutputTokenCostInCents: 0.00004,
 			},
 		},
+		// local models cost nothing, whatever model the ollama server runs
+		ollamaCostModel: {
+			ModelCost{
+				StartFrom:              time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+				InputTokenCostInCents:  0,
+				OutputTokenCostInCents: 0,
+			},
+		},
 		"gpt-5-chat-latest": {
 			ModelCost{
 				StartFrom:              time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
@@ -135,21 +158,200 @@ func init() {
 	}
 }
 
-func GetCosts(model string, currentAt time.Time) (*ModelCost, error) {
-	modelCostList, ok := costMap[strings.ToLower(model)]
-	if !ok {
-		return nil, ErrorInvalidModel
+// GetProviderCostModel returns the model to look up the costs of the provider.
+// azure-openai deployments have names of their own, the model behind is in the config.
+// ollama runs local models, they share a single zero cost entry.
+func GetProviderCostModel(provider *ent.Provider) string {
+	switch provider.Source {
+	case "azure-openai":
+		return providerConfigString(provider.Config, "model", provider.DefaultModel)
+	case "ollama":
+		return ollamaCostModel
+	}
+	return provider.DefaultModel
+}
+
+// Calculate returns the cost of the usage in cents. the cached and reasoning
+// tokens are part of the prompt and completion tokens.
+func (c ModelCost) Calculate(usage openai.Usage) float64 {
+	input := float64(usage.PromptTokens) * c.InputTokenCostInCents
+	if c.CachedInputTokenCostInCents != nil && usage.PromptTokensDetails != nil {
+		input += float64(usage.PromptTokensDetails.CachedTokens) * (*c.CachedInputTokenCostInCents - c.InputTokenCostInCents)
+	}
+	output := float64(usage.CompletionTokens) * c.OutputTokenCostInCents
+	if c.ReasoningTokenCostInCents != nil && usage.CompletionTokensDetails != nil {
+		output += float64(usage.CompletionTokensDetails.ReasoningTokens) * (*c.ReasoningTokenCostInCents - c.OutputTokenCostInCents)
+	}
+	return input + output
+}
+
+func modelCostFromPrice(price *ent.ModelPrice) ModelCost {
+	return ModelCost{
+		StartFrom:                   price.StartFrom,
+		InputTokenCostInCents:       price.InputTokenCostInCents,
+		OutputTokenCostInCents:      price.OutputTokenCostInCents,
+		CachedInputTokenCostInCents: price.CachedInputTokenCostInCents,
+		ReasoningTokenCostInCents:   price.ReasoningTokenCostInCents,
+	}
+}
+
+func modelPricesCacheKey(model string) string {
+	return fmt.Sprintf("model-prices:%s", strings.ToLower(model))
+}
+
+// getModelPrices returns the prices of the model, of all the providers
+func getModelPrices(ctx context.Context, model string) (prices []*ent.ModelPrice, err error) {
+	err = Cache.Get(ctx, modelPricesCacheKey(model), &prices)
+	if err == nil {
+		return prices, nil
+	}
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		return nil, err
 	}
+	prices, err = EntClient.ModelPrice.
+		Query().
+		Where(modelprice.Model(strings.ToLower(model))).
+		All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	Cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   modelPricesCacheKey(model),
+		Value: prices,
+		TTL:   time.Hour * 24,
+	})
+	return prices, nil
+}
+
+// ClearModelPricesCache drops the cached prices of the model, after they changed
+func ClearModelPricesCache(ctx context.Context, model string) {
+	if err := Cache.Delete(ctx, modelPricesCacheKey(model)); err != nil {
+		logrus.Warnln("model prices", err)
+	}
+}
 
-	slices.SortFunc(modelCostList, func(a, b ModelCost) int {
+// effectiveCost returns the latest cost started before the time
+func effectiveCost(costs []ModelCost, currentAt time.Time) (*ModelCost, bool) {
+	slices.SortFunc(costs, func(a, b ModelCost) int {
 		return a.StartFrom.Compare(b.StartFrom)
 	})
 
-	for i := len(modelCostList) - 1; i >= 0; i-- {
-		if currentAt.After(modelCostList[i].StartFrom) {
-			return &modelCostList[i], nil
+	for i := len(costs) - 1; i >= 0; i-- {
+		if currentAt.After(costs[i].StartFrom) {
+			return &costs[i], true
 		}
 	}
+	return nil, false
+}
 
+// GetCosts returns the price of the model at the time. the prices of the
+// provider come first, then the ones for all the providers.
+func GetCosts(ctx context.Context, providerID *int, model string, currentAt time.Time) (*ModelCost, error) {
+	prices, err := getModelPrices(ctx, model)
+	if err != nil {
+		return nil, err
+	}
+	if len(prices) == 0 {
+		return nil, ErrorInvalidModel
+	}
+
+	var providerCosts, globalCosts []ModelCost
+	for _, price := range prices {
+		switch {
+		case price.ProviderId == nil:
+			globalCosts = append(globalCosts, modelCostFromPrice(price))
+		case providerID != nil && *price.ProviderId == *providerID:
+			providerCosts = append(providerCosts, modelCostFromPrice(price))
+		}
+	}
+	if cost, ok := effectiveCost(providerCosts, currentAt); ok {
+		return cost, nil
+	}
+	if cost, ok := effectiveCost(globalCosts, currentAt); ok {
+		return cost, nil
+	}
 	return nil, ErrorNoCostFound
 }
+
+// the date of a model snapshot, like gpt-4o-2024-08-06 or claude-3-5-sonnet-20241022
+var modelSnapshotSuffix = regexp.MustCompile(`-(\d{4}-\d{2}-\d{2}|\d{8})$`)
+
+// costModelCandidates lists the models to price a call with, the reported
+// model first, then its base model and the cost model of the provider
+func costModelCandidates(provider *ent.Provider, model string) []string {
+	candidates := []string{}
+	if model != "" {
+		candidates = append(candidates, model)
+		if base := modelSnapshotSuffix.ReplaceAllString(model, ""); base != model {
+			candidates = append(candidates, base)
+		}
+	}
+	if provider != nil {
+		if costModel := GetProviderCostModel(provider); costModel != "" && !slices.Contains(candidates, costModel) {
+			candidates = append(candidates, costModel)
+		}
+	}
+	return candidates
+}
+
+// GetCallCosts returns the price of a call answered by the model of the provider.
+// a candidate without a price for the provider at the time falls back to the next one.
+func GetCallCosts(ctx context.Context, provider *ent.Provider, model string, currentAt time.Time) (*ModelCost, error) {
+	var providerID *int
+	if provider != nil && provider.ID > 0 {
+		providerID = &provider.ID
+	}
+	result := ErrorInvalidModel
+	for _, candidate := range costModelCandidates(provider, model) {
+		cost, err := GetCosts(ctx, providerID, candidate, currentAt)
+		switch {
+		case err == nil:
+			return cost, nil
+		case errors.Is(err, ErrorNoCostFound):
+			// the model has prices, but none of them for this call
+			result = ErrorNoCostFound
+		case !errors.Is(err, ErrorInvalidModel):
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", result, model)
+}
+
+// SeedModelPrices loads the default prices into the model_prices table. the
+// prices already there are kept, an edited default is not overwritten.
+func SeedModelPrices(ctx context.Context) (int, error) {
+	created := 0
+	for model, costs := range defaultModelCosts {
+		for _, cost := range costs {
+			exists, err := EntClient.ModelPrice.
+				Query().
+				Where(
+					modelprice.Model(model),
+					modelprice.ProviderIdIsNil(),
+					modelprice.StartFrom(cost.StartFrom),
+				).
+				Exist(ctx)
+			if err != nil {
+				return created, err
+			}
+			if exists {
+				continue
+			}
+			err = EntClient.ModelPrice.
+				Create().
+				SetModel(model).
+				SetStartFrom(cost.StartFrom).
+				SetInputTokenCostInCents(cost.InputTokenCostInCents).
+				SetOutputTokenCostInCents(cost.OutputTokenCostInCents).
+				SetNillableCachedInputTokenCostInCents(cost.CachedInputTokenCostInCents).
+				SetNillableReasoningTokenCostInCents(cost.ReasoningTokenCostInCents).
+				Exec(ctx)
+			if err != nil {
+				return created, err
+			}
+			created++
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/go-redis/cache/v9"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// setTestModelPrices puts the prices of the model in a local cache, GetCosts reads no database then
+func setTestModelPrices(t *testing.T, model string, prices []*ent.ModelPrice) {
+	if Cache == nil {
+		Cache = cache.New(&cache.Options{
+			LocalCache: cache.NewTinyLFU(100, time.Minute),
+		})
+	}
+	err := Cache.Set(&cache.Item{
+		Ctx:   context.Background(),
+		Key:   modelPricesCacheKey(model),
+		Value: prices,
+		TTL:   time.Minute,
+	})
+	assert.Nil(t, err)
+}
+
+// defaultTestModelPrices are the prices the seed step loads for the model
+func defaultTestModelPrices(model string) []*ent.ModelPrice {
+	result := []*ent.ModelPrice{}
+	for _, cost := range defaultModelCosts[model] {
+		result = append(result, &ent.ModelPrice{
+			Model:                       model,
+			StartFrom:                   cost.StartFrom,
+			InputTokenCostInCents:       cost.InputTokenCostInCents,
+			OutputTokenCostInCents:      cost.OutputTokenCostInCents,
+			CachedInputTokenCostInCents: cost.CachedInputTokenCostInCents,
+			ReasoningTokenCostInCents:   cost.ReasoningTokenCostInCents,
+		})
+	}
+	return result
+}
+
+func TestCostCalculate(t *testing.T) {
+	cached, reasoning := 0.5, 4.0
+	cost := ModelCost{InputTokenCostInCents: 1, OutputTokenCostInCents: 2}
+	usage := openai.Usage{
+		PromptTokens:            100,
+		CompletionTokens:        10,
+		PromptTokensDetails:     &openai.PromptTokensDetails{CachedTokens: 40},
+		CompletionTokensDetails: &openai.CompletionTokensDetails{ReasoningTokens: 5},
+	}
+	// without the prices the details are billed like the other tokens
+	assert.Equal(t, 120.0, cost.Calculate(usage))
+
+	cost.CachedInputTokenCostInCents = &cached
+	cost.ReasoningTokenCostInCents = &reasoning
+	assert.Equal(t, 60*1+40*0.5+5*2+5*4.0, cost.Calculate(usage))
+	assert.Equal(t, 100.0, cost.Calculate(openai.Usage{PromptTokens: 100}))
+}
+
+func TestCostEffectiveDate(t *testing.T) {
+	setTestModelPrices(t, "test-cost-model", []*ent.ModelPrice{
+		{Model: "test-cost-model", StartFrom: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), InputTokenCostInCents: 2},
+		{Model: "test-cost-model", StartFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), InputTokenCostInCents: 1},
+	})
+
+	cost, err := GetCosts(context.Background(), nil, "Test-Cost-Model", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, cost.InputTokenCostInCents)
+
+	cost, err = GetCosts(context.Background(), nil, "test-cost-model", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, cost.InputTokenCostInCents)
+
+	_, err = GetCosts(context.Background(), nil, "test-cost-model", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.ErrorIs(t, err, ErrorNoCostFound)
+
+	setTestModelPrices(t, "test-cost-unknown", []*ent.ModelPrice{})
+	_, err = GetCosts(context.Background(), nil, "test-cost-unknown", time.Now())
+	assert.ErrorIs(t, err, ErrorInvalidModel)
+}
+
+func TestCostProviderOverride(t *testing.T) {
+	providerID, otherID := 7, 8
+	setTestModelPrices(t, "test-cost-override", []*ent.ModelPrice{
+		{Model: "test-cost-override", StartFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), InputTokenCostInCents: 1},
+		{Model: "test-cost-override", StartFrom: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), InputTokenCostInCents: 3, ProviderId: &providerID},
+	})
+
+	cost, err := GetCosts(context.Background(), &providerID, "test-cost-override", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, cost.InputTokenCostInCents)
+
+	// the override is not in effect yet
+	cost, err = GetCosts(context.Background(), &providerID, "test-cost-override", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, cost.InputTokenCostInCents)
+
+	for _, id := range []*int{nil, &otherID} {
+		cost, err = GetCosts(context.Background(), id, "test-cost-override", time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC))
+		assert.Nil(t, err)
+		assert.Equal(t, 1.0, cost.InputTokenCostInCents)
+	}
+}
+
+func TestCostCallModel(t *testing.T) {
+	openAIProvider := &ent.Provider{ID: 3, Source: "openai", DefaultModel: "gpt-4o"}
+	assert.Equal(t, []string{"gpt-4o-2024-08-06", "gpt-4o"}, costModelCandidates(openAIProvider, "gpt-4o-2024-08-06"))
+	assert.Equal(t, []string{"claude-3-5-sonnet-20241022", "claude-3-5-sonnet", "gpt-4o"}, costModelCandidates(openAIProvider, "claude-3-5-sonnet-20241022"))
+	assert.Equal(t, []string{"llama3", ollamaCostModel}, costModelCandidates(&ent.Provider{Source: "ollama", DefaultModel: "llama3"}, "llama3"))
+	assert.Equal(t, []string{"gpt-4o-mini"}, costModelCandidates(nil, "gpt-4o-mini"))
+
+	// the snapshot has no price of its own, the base model is used
+	setTestModelPrices(t, "gpt-4o-2024-08-06", []*ent.ModelPrice{})
+	setTestModelPrices(t, "gpt-4o", defaultTestModelPrices("gpt-4o"))
+	cost, err := GetCallCosts(context.Background(), openAIProvider, "gpt-4o-2024-08-06", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, defaultModelCosts["gpt-4o"][0].InputTokenCostInCents, cost.InputTokenCostInCents)
+
+	// the snapshot is only priced for another provider, the base model is used
+	otherID := openAIProvider.ID + 1
+	setTestModelPrices(t, "gpt-4o-2024-08-06", []*ent.ModelPrice{
+		{Model: "gpt-4o-2024-08-06", StartFrom: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), InputTokenCostInCents: 99, ProviderId: &otherID},
+	})
+	cost, err = GetCallCosts(context.Background(), openAIProvider, "gpt-4o-2024-08-06", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, defaultModelCosts["gpt-4o"][0].InputTokenCostInCents, cost.InputTokenCostInCents)
+
+	// and the cost model of the provider when the base model is not priced yet
+	setTestModelPrices(t, "test-cost-call-late", []*ent.ModelPrice{
+		{Model: "test-cost-call-late", StartFrom: time.Now().Add(time.Hour), InputTokenCostInCents: 99},
+	})
+	cost, err = GetCallCosts(context.Background(), openAIProvider, "test-cost-call-late", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, defaultModelCosts["gpt-4o"][0].InputTokenCostInCents, cost.InputTokenCostInCents)
+
+	_, err = GetCallCosts(context.Background(), nil, "test-cost-call-late", time.Now())
+	assert.ErrorIs(t, err, ErrorNoCostFound)
+
+	setTestModelPrices(t, "test-cost-call-unknown", []*ent.ModelPrice{})
+	_, err = GetCallCosts(context.Background(), nil, "test-cost-call-unknown", time.Now())
+	assert.ErrorIs(t, err, ErrorInvalidModel)
+}
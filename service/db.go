@@ -27,6 +27,42 @@ func InitDB() {
 	EntClient = client
 	logrus.Infoln("Connected to database")
 	initAdminFromEnv()
+	initModelPrices()
+	initOpenTokens()
+	initPromptVersions()
+}
+
+func initPromptVersions() {
+	migrated, err := MigratePromptVersions(context.Background())
+	if err != nil {
+		logrus.Errorln("failed numbering prompt versions: ", err)
+		return
+	}
+	if migrated > 0 {
+		logrus.Infoln("numbered prompt versions: ", migrated)
+	}
+}
+
+func initOpenTokens() {
+	migrated, err := MigrateOpenTokens(context.Background())
+	if err != nil {
+		logrus.Errorln("failed hashing open tokens: ", err)
+		return
+	}
+	if migrated > 0 {
+		logrus.Infoln("hashed open tokens: ", migrated)
+	}
+}
+
+func initModelPrices() {
+	created, err := SeedModelPrices(context.Background())
+	if err != nil {
+		logrus.Errorln("failed seeding model prices: ", err)
+		return
+	}
+	if created > 0 {
+		logrus.Infoln("seeded model prices: ", created)
+	}
 }
 
 func initAdminFromEnv() {
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/config"
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/opentoken"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrorOpenTokenExpired = errors.New("the API token is expired")
+var ErrorOpenTokenNotFound = errors.New("invalid API token")
+
+const (
+	openTokenScheme       = "pp"
+	openTokenPrefixLength = 8
+)
+
+func openTokenHashKey() []byte {
+	cfg := config.GetRuntimeConfig()
+	if len(cfg.TokenHashKey) > 0 {
+		return cfg.TokenHashKey
+	}
+	return cfg.JwtTokenKey
+}
+
+// HashOpenToken returns the keyed hash the token is stored and cached as
+func HashOpenToken(tk string) string {
+	mac := hmac.New(sha256.New, openTokenHashKey())
+	mac.Write([]byte(tk))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func openTokenHashEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// NewOpenToken issues a token of a public prefix and a secret, like pp_<prefix>_<secret>
+func NewOpenToken() (token, prefix string) {
+	b := make([]byte, openTokenPrefixLength/2+16)
+	rand.Read(b)
+	prefix = hex.EncodeToString(b[:openTokenPrefixLength/2])
+	token = fmt.Sprintf("%s_%s_%s", openTokenScheme, prefix, hex.EncodeToString(b[openTokenPrefixLength/2:]))
+	return
+}
+
+// OpenTokenPrefix returns the public prefix of the token. the legacy tokens
+// have none, their first characters are used instead.
+func OpenTokenPrefix(tk string) string {
+	parts := strings.SplitN(tk, "_", 3)
+	if len(parts) == 3 && parts[0] == openTokenScheme {
+		return parts[1]
+	}
+	if len(tk) > openTokenPrefixLength {
+		return tk[:openTokenPrefixLength]
+	}
+	return tk
+}
+
+// FindOpenToken looks the token up by its prefix and compares the hashes in constant time.
+// the token before a rotation is found during its grace period.
+func FindOpenToken(ctx context.Context, tk string, now time.Time) (*ent.OpenToken, error) {
+	prefix := OpenTokenPrefix(tk)
+	hash := HashOpenToken(tk)
+	candidates, err := EntClient.
+		OpenToken.
+		Query().
+		Where(opentoken.Or(
+			opentoken.Prefix(prefix),
+			opentoken.And(
+				opentoken.PreviousPrefix(prefix),
+				opentoken.PreviousTokenExpireAtGT(now),
+			),
+		)).
+		All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ot := range candidates {
+		if openTokenHashEqual(ot.TokenHash, hash) {
+			return ot, nil
+		}
+		if ot.PreviousTokenHash != nil && openTokenHashEqual(*ot.PreviousTokenHash, hash) {
+			return ot, nil
+		}
+	}
+	return nil, ErrorOpenTokenNotFound
+}
+
+// OpenTokenCacheTTL is how long the token can be cached by its hash, not past its expiry
+func OpenTokenCacheTTL(ot ent.OpenToken, tokenHash string, now time.Time) time.Duration {
+	ttl := time.Hour
+	expireAt := ot.ExpireAt
+	if !openTokenHashEqual(ot.TokenHash, tokenHash) && ot.PreviousTokenExpireAt != nil && ot.PreviousTokenExpireAt.Before(expireAt) {
+		expireAt = *ot.PreviousTokenExpireAt
+	}
+	if until := expireAt.Sub(now); until < ttl {
+		ttl = until
+	}
+	return ttl
+}
+
+// CheckOpenTokenExpiry fails if the token or, for the token before a rotation, its grace period expired
+func CheckOpenTokenExpiry(ot ent.OpenToken, tokenHash string, now time.Time) error {
+	if !ot.ExpireAt.After(now) {
+		return ErrorOpenTokenExpired
+	}
+	if !openTokenHashEqual(ot.TokenHash, tokenHash) && (ot.PreviousTokenExpireAt == nil || !ot.PreviousTokenExpireAt.After(now)) {
+		return fmt.Errorf("%w: the token was rotated", ErrorOpenTokenExpired)
+	}
+	return nil
+}
+
+// TouchOpenToken records the last use of the token, at most once a minute
+func TouchOpenToken(ctx context.Context, tokenID int, ip string, at time.Time) {
+	if redisClient != nil {
+		ok, err := redisClient.SetNX(ctx, fmt.Sprintf("openToken:used:%d", tokenID), 1, time.Minute).Result()
+		if err != nil {
+			logrus.Warnln("openToken", err)
+			return
+		}
+		if !ok {
+			return
+		}
+	}
+	err := EntClient.OpenToken.
+		UpdateOneID(tokenID).
+		SetLastUsedAt(at).
+		SetLastUsedIp(ip).
+		Exec(ctx)
+	if err != nil {
+		logrus.Warnln("openToken", err)
+	}
+}
+
+// ClearOpenTokenCache evicts the cached token of the current and the previous secret
+func ClearOpenTokenCache(ctx context.Context, ot *ent.OpenToken) {
+	Cache.Delete(ctx, fmt.Sprintf("openToken:%s", ot.TokenHash))
+	if ot.PreviousTokenHash != nil {
+		Cache.Delete(ctx, fmt.Sprintf("openToken:%s", *ot.PreviousTokenHash))
+	}
+}
+
+// MigrateOpenTokens hashes the plaintext tokens issued before the tokens were stored hashed.
+// they keep working, with their first characters as the prefix.
+func MigrateOpenTokens(ctx context.Context) (int, error) {
+	legacy, err := EntClient.
+		OpenToken.
+		Query().
+		Where(
+			opentoken.TokenHash(""),
+			opentoken.TokenNEQ(""),
+		).
+		All(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, ot := range legacy {
+		err = EntClient.OpenToken.
+			UpdateOneID(ot.ID).
+			SetPrefix(OpenTokenPrefix(ot.Token)).
+			SetTokenHash(HashOpenToken(ot.Token)).
+			SetToken("").
+			Exec(ctx)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(legacy), nil
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenTokenExpiry(t *testing.T) {
+	now := time.Now()
+	ot := ent.OpenToken{TokenHash: "new", ExpireAt: now.Add(time.Hour)}
+
+	assert.Nil(t, CheckOpenTokenExpiry(ot, "new", now))
+	assert.ErrorIs(t, CheckOpenTokenExpiry(ot, "new", now.Add(2*time.Hour)), ErrorOpenTokenExpired)
+
+	// the old secret is only valid in its grace period
+	previous := "old"
+	graceEnd := now.Add(time.Minute)
+	ot.PreviousTokenHash = &previous
+	ot.PreviousTokenExpireAt = &graceEnd
+	assert.Nil(t, CheckOpenTokenExpiry(ot, "old", now))
+	assert.ErrorIs(t, CheckOpenTokenExpiry(ot, "old", now.Add(2*time.Minute)), ErrorOpenTokenExpired)
+	assert.Nil(t, CheckOpenTokenExpiry(ot, "new", now.Add(2*time.Minute)))
+}
+
+func TestOpenTokenCacheTTL(t *testing.T) {
+	now := time.Now()
+	ot := ent.OpenToken{TokenHash: "new", ExpireAt: now.Add(24 * time.Hour)}
+	assert.Equal(t, time.Hour, OpenTokenCacheTTL(ot, "new", now))
+
+	ot.ExpireAt = now.Add(10 * time.Minute)
+	assert.Equal(t, 10*time.Minute, OpenTokenCacheTTL(ot, "new", now))
+
+	graceEnd := now.Add(time.Minute)
+	ot.PreviousTokenExpireAt = &graceEnd
+	assert.Equal(t, time.Minute, OpenTokenCacheTTL(ot, "old", now))
+	assert.Equal(t, 10*time.Minute, OpenTokenCacheTTL(ot, "new", now))
+}
+
+func TestOpenTokenFormat(t *testing.T) {
+	tk, prefix := NewOpenToken()
+	assert.True(t, strings.HasPrefix(tk, "pp_"+prefix+"_"))
+	assert.Len(t, prefix, openTokenPrefixLength)
+	assert.Equal(t, prefix, OpenTokenPrefix(tk))
+
+	other, _ := NewOpenToken()
+	assert.NotEqual(t, tk, other)
+
+	// the legacy tokens are looked up by their first characters
+	assert.Equal(t, "0123abcd", OpenTokenPrefix("0123abcdef4567890123abcdef456789"))
+
+	assert.Equal(t, HashOpenToken(tk), HashOpenToken(tk))
+	assert.NotEqual(t, HashOpenToken(tk), HashOpenToken(other))
+	assert.Len(t, HashOpenToken(tk), 64)
+}
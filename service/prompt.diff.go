@@ -0,0 +1,355 @@
+package service
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+)
+
+type DiffOp string
+
+const (
+	DiffOpEqual  DiffOp = "equal"
+	DiffOpInsert DiffOp = "insert"
+	DiffOpDelete DiffOp = "delete"
+	// a row or a line which is in both sides with edits, the next level tells what changed
+	DiffOpChange DiffOp = "change"
+)
+
+// the largest table of the LCS, the longer texts are diffed as a whole replacement
+const maxDiffCells = 4_000_000
+
+// WordDiff is a chunk of the words of a changed line
+type WordDiff struct {
+	Op   DiffOp
+	Text string
+}
+
+type LineDiff struct {
+	Op DiffOp
+	// nil if the line is inserted
+	From *string
+	// nil if the line is deleted
+	To *string
+	// only for the changed lines
+	Words []WordDiff
+}
+
+type PromptRowDiff struct {
+	Op DiffOp
+	// nil if the row is inserted
+	FromIndex *int
+	// nil if the row is deleted
+	ToIndex  *int
+	FromRole *string
+	ToRole   *string
+	Lines    []LineDiff
+}
+
+type PromptVariableDiff struct {
+	Op       DiffOp
+	Name     string
+	FromType *string
+	ToType   *string
+}
+
+// PromptFieldDiff is a changed setting of the prompt
+type PromptFieldDiff struct {
+	Field string
+	From  string
+	To    string
+}
+
+type PromptDiff struct {
+	FromVersion int
+	ToVersion   int
+	Rows        []PromptRowDiff
+	Variables   []PromptVariableDiff
+	Fields      []PromptFieldDiff
+}
+
+// diffEdit is a step of the edit script, the index of a side is -1 if it has no element
+type diffEdit struct {
+	op   DiffOp
+	from int
+	to   int
+}
+
+// diffSequences returns the edit script from a to b by their longest common subsequence.
+// the deletions and insertions next to each other are paired as changes.
+func diffSequences[T any](a, b []T, equal func(x, y T) bool) []diffEdit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && equal(a[prefix], b[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && equal(a[len(a)-1-suffix], b[len(b)-1-suffix]) {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	edits := make([]diffEdit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, diffEdit{op: DiffOpEqual, from: i, to: i})
+	}
+
+	var middle []diffEdit
+	if len(ma)*len(mb) > maxDiffCells {
+		for i := range ma {
+			middle = append(middle, diffEdit{op: DiffOpDelete, from: i, to: -1})
+		}
+		for j := range mb {
+			middle = append(middle, diffEdit{op: DiffOpInsert, from: -1, to: j})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if equal(ma[i], mb[j]) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && equal(ma[i], mb[j]):
+				middle = append(middle, diffEdit{op: DiffOpEqual, from: i, to: j})
+				i++
+				j++
+			case j < len(mb) && (i == len(ma) || lcs[i][j+1] > lcs[i+1][j]):
+				middle = append(middle, diffEdit{op: DiffOpInsert, from: -1, to: j})
+				j++
+			default:
+				middle = append(middle, diffEdit{op: DiffOpDelete, from: i, to: -1})
+				i++
+			}
+		}
+	}
+	for _, e := range pairDiffEdits(middle) {
+		if e.from >= 0 {
+			e.from += prefix
+		}
+		if e.to >= 0 {
+			e.to += prefix
+		}
+		edits = append(edits, e)
+	}
+
+	for k := suffix; k > 0; k-- {
+		edits = append(edits, diffEdit{op: DiffOpEqual, from: len(a) - k, to: len(b) - k})
+	}
+	return edits
+}
+
+// pairDiffEdits turns each run of deletions and insertions into changes, from the first ones
+func pairDiffEdits(edits []diffEdit) []diffEdit {
+	result := make([]diffEdit, 0, len(edits))
+	for k := 0; k < len(edits); {
+		if edits[k].op == DiffOpEqual {
+			result = append(result, edits[k])
+			k++
+			continue
+		}
+		var deleted, inserted []int
+		for ; k < len(edits) && edits[k].op != DiffOpEqual; k++ {
+			if edits[k].op == DiffOpDelete {
+				deleted = append(deleted, edits[k].from)
+			} else {
+				inserted = append(inserted, edits[k].to)
+			}
+		}
+		paired := min(len(deleted), len(inserted))
+		for n := 0; n < paired; n++ {
+			result = append(result, diffEdit{op: DiffOpChange, from: deleted[n], to: inserted[n]})
+		}
+		for _, from := range deleted[paired:] {
+			result = append(result, diffEdit{op: DiffOpDelete, from: from, to: -1})
+		}
+		for _, to := range inserted[paired:] {
+			result = append(result, diffEdit{op: DiffOpInsert, from: -1, to: to})
+		}
+	}
+	return result
+}
+
+func equalStrings(x, y string) bool {
+	return x == y
+}
+
+// words, runs of spaces and single punctuation marks
+var diffWordPattern = regexp.MustCompile(`[\p{L}\p{N}_]+|\s+|[^\p{L}\p{N}_\s]`)
+
+// DiffWords diffs two lines word by word, the chunks of the same op are merged
+func DiffWords(from, to string) []WordDiff {
+	a := diffWordPattern.FindAllString(from, -1)
+	b := diffWordPattern.FindAllString(to, -1)
+
+	var result []WordDiff
+	push := func(op DiffOp, text string) {
+		if n := len(result); n > 0 && result[n-1].Op == op {
+			result[n-1].Text += text
+			return
+		}
+		result = append(result, WordDiff{Op: op, Text: text})
+	}
+	for _, e := range diffSequences(a, b, equalStrings) {
+		switch e.op {
+		case DiffOpEqual:
+			push(DiffOpEqual, a[e.from])
+		case DiffOpChange:
+			push(DiffOpDelete, a[e.from])
+			push(DiffOpInsert, b[e.to])
+		case DiffOpDelete:
+			push(DiffOpDelete, a[e.from])
+		case DiffOpInsert:
+			push(DiffOpInsert, b[e.to])
+		}
+	}
+	return normalizeWordDiffs(result)
+}
+
+// normalizeWordDiffs moves the deletions before the insertions of a changed run, to read them as replacements
+func normalizeWordDiffs(words []WordDiff) []WordDiff {
+	result := make([]WordDiff, 0, len(words))
+	for k := 0; k < len(words); {
+		if words[k].Op == DiffOpEqual {
+			result = append(result, words[k])
+			k++
+			continue
+		}
+		var deleted, inserted strings.Builder
+		for ; k < len(words) && words[k].Op != DiffOpEqual; k++ {
+			if words[k].Op == DiffOpDelete {
+				deleted.WriteString(words[k].Text)
+			} else {
+				inserted.WriteString(words[k].Text)
+			}
+		}
+		if deleted.Len() > 0 {
+			result = append(result, WordDiff{Op: DiffOpDelete, Text: deleted.String()})
+		}
+		if inserted.Len() > 0 {
+			result = append(result, WordDiff{Op: DiffOpInsert, Text: inserted.String()})
+		}
+	}
+	return result
+}
+
+// DiffLines diffs two texts line by line, the changed lines are diffed word by word
+func DiffLines(from, to string) []LineDiff {
+	a := strings.Split(from, "\n")
+	b := strings.Split(to, "\n")
+
+	var result []LineDiff
+	for _, e := range diffSequences(a, b, equalStrings) {
+		line := LineDiff{Op: e.op}
+		if e.from >= 0 {
+			line.From = &a[e.from]
+		}
+		if e.to >= 0 {
+			line.To = &b[e.to]
+		}
+		if e.op == DiffOpChange {
+			line.Words = DiffWords(a[e.from], b[e.to])
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+func equalPromptRows(x, y schema.PromptRow) bool {
+	return x.Role == y.Role && x.Prompt == y.Prompt
+}
+
+// DiffPromptRows aligns the rows of both sides, the rows with edits are diffed line by line
+func DiffPromptRows(from, to []schema.PromptRow) []PromptRowDiff {
+	var result []PromptRowDiff
+	for _, e := range diffSequences(from, to, equalPromptRows) {
+		row := PromptRowDiff{Op: e.op}
+		fromText, toText := "", ""
+		if e.from >= 0 {
+			row.FromIndex = &e.from
+			row.FromRole = &from[e.from].Role
+			fromText = from[e.from].Prompt
+		}
+		if e.to >= 0 {
+			row.ToIndex = &e.to
+			row.ToRole = &to[e.to].Role
+			toText = to[e.to].Prompt
+		}
+		switch e.op {
+		case DiffOpEqual, DiffOpChange:
+			row.Lines = DiffLines(fromText, toText)
+		case DiffOpDelete:
+			for _, line := range strings.Split(fromText, "\n") {
+				row.Lines = append(row.Lines, LineDiff{Op: DiffOpDelete, From: &line})
+			}
+		case DiffOpInsert:
+			for _, line := range strings.Split(toText, "\n") {
+				row.Lines = append(row.Lines, LineDiff{Op: DiffOpInsert, To: &line})
+			}
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// DiffPromptVariables matches the variables by name, the changed ones have another type
+func DiffPromptVariables(from, to []schema.PromptVariable) []PromptVariableDiff {
+	var result []PromptVariableDiff
+	for _, v := range from {
+		fromType := string(v.Type)
+		i := slices.IndexFunc(to, func(t schema.PromptVariable) bool { return t.Name == v.Name })
+		if i < 0 {
+			result = append(result, PromptVariableDiff{Op: DiffOpDelete, Name: v.Name, FromType: &fromType})
+			continue
+		}
+		toType := string(to[i].Type)
+		op := DiffOpEqual
+		if fromType != toType {
+			op = DiffOpChange
+		}
+		result = append(result, PromptVariableDiff{Op: op, Name: v.Name, FromType: &fromType, ToType: &toType})
+	}
+	for _, v := range to {
+		if !slices.ContainsFunc(from, func(f schema.PromptVariable) bool { return f.Name == v.Name }) {
+			toType := string(v.Type)
+			result = append(result, PromptVariableDiff{Op: DiffOpInsert, Name: v.Name, ToType: &toType})
+		}
+	}
+	return result
+}
+
+// DiffPromptSnapshots tells what changed from a version of the prompt to another one.
+// the provider of the snapshots taken before it was recorded is unknown, it is not compared.
+func DiffPromptSnapshots(from, to schema.PromptComplete) PromptDiff {
+	result := PromptDiff{
+		FromVersion: from.Version,
+		ToVersion:   to.Version,
+		Rows:        DiffPromptRows(from.Prompts, to.Prompts),
+		Variables:   DiffPromptVariables(from.Variables, to.Variables),
+	}
+	field := func(name, fromValue, toValue string) {
+		if fromValue != toValue {
+			result.Fields = append(result.Fields, PromptFieldDiff{Field: name, From: fromValue, To: toValue})
+		}
+	}
+	field("description", from.Description, to.Description)
+	field("publicLevel", from.PublicLevel, to.PublicLevel)
+	field("enabled", strconv.FormatBool(from.Enabled), strconv.FormatBool(to.Enabled))
+	field("debug", strconv.FormatBool(from.Debug), strconv.FormatBool(to.Debug))
+	if from.ProviderId > 0 && to.ProviderId > 0 {
+		field("providerId", strconv.Itoa(from.ProviderId), strconv.Itoa(to.ProviderId))
+	}
+	return result
+}
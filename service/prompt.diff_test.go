@@ -0,0 +1,126 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptDiffWords(t *testing.T) {
+	assert.Equal(t, []WordDiff{
+		{Op: DiffOpEqual, Text: "you are a "},
+		{Op: DiffOpDelete, Text: "helpful"},
+		{Op: DiffOpInsert, Text: "careful"},
+		{Op: DiffOpEqual, Text: " assistant"},
+		{Op: DiffOpInsert, Text: ", answer in {{ lang }}"},
+		{Op: DiffOpEqual, Text: "."},
+	}, DiffWords("you are a helpful assistant.", "you are a careful assistant, answer in {{ lang }}."))
+
+	assert.Equal(t, []WordDiff{{Op: DiffOpEqual, Text: "same"}}, DiffWords("same", "same"))
+	assert.Equal(t, []WordDiff{{Op: DiffOpInsert, Text: "new"}}, DiffWords("", "new"))
+}
+
+func TestPromptDiffLines(t *testing.T) {
+	lines := DiffLines("first\nsecond\nthird", "first\nsecond line\nthird\nfourth")
+	assert.Len(t, lines, 4)
+	assert.Equal(t, DiffOpEqual, lines[0].Op)
+	assert.Equal(t, DiffOpChange, lines[1].Op)
+	assert.Equal(t, "second", *lines[1].From)
+	assert.Equal(t, "second line", *lines[1].To)
+	assert.Equal(t, []WordDiff{{Op: DiffOpEqual, Text: "second"}, {Op: DiffOpInsert, Text: " line"}}, lines[1].Words)
+	assert.Equal(t, DiffOpEqual, lines[2].Op)
+	assert.Equal(t, DiffOpInsert, lines[3].Op)
+	assert.Nil(t, lines[3].From)
+	assert.Equal(t, "fourth", *lines[3].To)
+}
+
+func TestPromptDiffRows(t *testing.T) {
+	from := []schema.PromptRow{
+		{Role: "system", Prompt: "you are a translator"},
+		{Role: "user", Prompt: "translate {{ text }}"},
+		{Role: "assistant", Prompt: "an example"},
+	}
+	to := []schema.PromptRow{
+		{Role: "system", Prompt: "you are a translator"},
+		{Role: "system", Prompt: "answer briefly"},
+		{Role: "user", Prompt: "translate {{ text }} to {{ lang }}"},
+	}
+	rows := DiffPromptRows(from, to)
+	assert.Len(t, rows, 3)
+
+	assert.Equal(t, DiffOpEqual, rows[0].Op)
+	assert.Equal(t, 0, *rows[0].FromIndex)
+	assert.Equal(t, 0, *rows[0].ToIndex)
+
+	// the edited rows are aligned in order, the role changed too
+	assert.Equal(t, DiffOpChange, rows[1].Op)
+	assert.Equal(t, 1, *rows[1].FromIndex)
+	assert.Equal(t, 1, *rows[1].ToIndex)
+	assert.Equal(t, "user", *rows[1].FromRole)
+	assert.Equal(t, "system", *rows[1].ToRole)
+
+	assert.Equal(t, DiffOpChange, rows[2].Op)
+	assert.Equal(t, "assistant", *rows[2].FromRole)
+	assert.Equal(t, "user", *rows[2].ToRole)
+
+	rows = DiffPromptRows(from[:1], from)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, DiffOpInsert, rows[2].Op)
+	assert.Nil(t, rows[2].FromIndex)
+	assert.Equal(t, 2, *rows[2].ToIndex)
+	assert.Equal(t, DiffOpInsert, rows[2].Lines[0].Op)
+	assert.Equal(t, "an example", *rows[2].Lines[0].To)
+}
+
+func TestPromptDiffSnapshots(t *testing.T) {
+	from := schema.PromptComplete{
+		Version:     1,
+		Description: "old",
+		PublicLevel: "protected",
+		Enabled:     true,
+		ProviderId:  1,
+		Prompts:     []schema.PromptRow{{Role: "system", Prompt: "hi"}},
+		Variables: []schema.PromptVariable{
+			{Name: "text", Type: schema.PromptVariableTypesString},
+			{Name: "count", Type: schema.PromptVariableTypesString},
+			{Name: "image", Type: schema.PromptVariableTypesImage},
+		},
+	}
+	to := from
+	to.Version = 2
+	to.Description = "new"
+	to.Debug = true
+	to.ProviderId = 2
+	to.Variables = []schema.PromptVariable{
+		{Name: "text", Type: schema.PromptVariableTypesString},
+		{Name: "count", Type: schema.PromptVariableTypesNumber},
+		{Name: "lang", Type: schema.PromptVariableTypesString},
+	}
+
+	diff := DiffPromptSnapshots(from, to)
+	assert.Equal(t, 1, diff.FromVersion)
+	assert.Equal(t, 2, diff.ToVersion)
+	assert.Len(t, diff.Rows, 1)
+	assert.Equal(t, DiffOpEqual, diff.Rows[0].Op)
+	assert.Equal(t, []PromptFieldDiff{
+		{Field: "description", From: "old", To: "new"},
+		{Field: "debug", From: "false", To: "true"},
+		{Field: "providerId", From: "1", To: "2"},
+	}, diff.Fields)
+
+	ops := map[string]DiffOp{}
+	for _, v := range diff.Variables {
+		ops[v.Name] = v.Op
+	}
+	assert.Equal(t, map[string]DiffOp{
+		"text":  DiffOpEqual,
+		"count": DiffOpChange,
+		"image": DiffOpDelete,
+		"lang":  DiffOpInsert,
+	}, ops)
+
+	// the provider of the old snapshots is unknown
+	from.ProviderId = 0
+	assert.Len(t, DiffPromptSnapshots(from, to).Fields, 2)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/promptlabel"
+)
+
+var ErrorInvalidPromptLabel = errors.New("invalid prompt label, use up to 32 lowercase letters, digits, `-` or `_`")
+var ErrorPromptLabelNotFound = errors.New("prompt label not found")
+
+// ActivityPromptLabelChanged is the activity of a label which is set or removed
+const ActivityPromptLabelChanged = "promptLabelChanged"
+
+var promptLabelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidatePromptLabel checks the name of a label, e.g. dev, staging or production
+func ValidatePromptLabel(name string) error {
+	if !promptLabelPattern.MatchString(name) {
+		return fmt.Errorf("%w: %s", ErrorInvalidPromptLabel, name)
+	}
+	return nil
+}
+
+// PromptCacheKey is the key the prompt of the runs is cached by.
+// the labels are cached on their own, a promotion only evicts its label.
+func PromptCacheKey(hid string, label string) string {
+	if label == "" {
+		return fmt.Sprintf("prompt:%s", hid)
+	}
+	return fmt.Sprintf("prompt:%s:%s", hid, label)
+}
+
+// ResolvePromptLabel returns the version the label of the prompt points at
+func ResolvePromptLabel(ctx context.Context, promptID int, label string) (int, error) {
+	pl, err := EntClient.PromptLabel.
+		Query().
+		Where(promptlabel.PromptId(promptID), promptlabel.Name(label)).
+		Only(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return 0, fmt.Errorf("%w: %s", ErrorPromptLabelNotFound, label)
+		}
+		return 0, err
+	}
+	return pl.Version, nil
+}
+
+// ClearPromptLabelsCache evicts the cached prompts of all the labels of the prompt
+func ClearPromptLabelsCache(ctx context.Context, hid string, promptID int) error {
+	labels, err := EntClient.PromptLabel.
+		Query().
+		Where(promptlabel.PromptId(promptID)).
+		Select(promptlabel.FieldName).
+		Strings(ctx)
+	if err != nil {
+		return err
+	}
+	for _, label := range labels {
+		Cache.Delete(ctx, PromptCacheKey(hid, label))
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptLabel(t *testing.T) {
+	for _, name := range []string{"production", "staging", "dev", "canary-2", "eu_west", "1"} {
+		assert.Nil(t, ValidatePromptLabel(name), name)
+	}
+	for _, name := range []string{"", "Production", "-dev", "pro duction", "prod@v1", "a123456789012345678901234567890123"} {
+		assert.ErrorIs(t, ValidatePromptLabel(name), ErrorInvalidPromptLabel, name)
+	}
+
+	assert.Equal(t, "prompt:abc", PromptCacheKey("abc", ""))
+	assert.Equal(t, "prompt:abc:production", PromptCacheKey("abc", "production"))
+}
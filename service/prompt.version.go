@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/history"
+	"github.com/PromptPal/PromptPal/ent/prompt"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/go-redis/cache/v9"
+)
+
+var ErrorInvalidPromptVersion = errors.New("invalid prompt version")
+var ErrorPromptVersionNotFound = errors.New("prompt version not found")
+
+// ActivityPromptVersionRestored is the activity of a restore of a previous version
+const ActivityPromptVersionRestored = "promptVersionRestored"
+
+// the suffix of the prompt id which pins the version, e.g. `<id>@v3`
+const promptVersionSeparator = "@v"
+
+// ParsePromptVersion splits the version off the prompt id of a run.
+// the `version` query takes the version if the id has no suffix, 0 means the latest one.
+func ParsePromptVersion(id, query string) (string, int, error) {
+	hid, suffix, pinned := strings.Cut(id, promptVersionSeparator)
+	if !pinned {
+		if query == "" {
+			return hid, 0, nil
+		}
+		suffix = query
+	}
+	version, err := strconv.Atoi(suffix)
+	if err != nil || version <= 0 {
+		return hid, 0, fmt.Errorf("%w: %s", ErrorInvalidPromptVersion, suffix)
+	}
+	return hid, version, nil
+}
+
+// PromptVersionID is the id of the version of the prompt, the replies are cached by it
+func PromptVersionID(hid string, version int) string {
+	return fmt.Sprintf("%s%s%d", hid, promptVersionSeparator, version)
+}
+
+// PromptSnapshot is what a version of the prompt runs with
+func PromptSnapshot(p ent.Prompt) schema.PromptComplete {
+	return schema.PromptComplete{
+		Name:        p.Name,
+		Enabled:     p.Enabled,
+		Debug:       p.Debug,
+		Description: p.Description,
+		TokenCount:  p.TokenCount,
+		Prompts:     p.Prompts,
+		Variables:   p.Variables,
+		PublicLevel: p.PublicLevel.String(),
+		Version:     p.Version,
+
+		ModelParameters: p.ModelParameters,
+		OutputSchema:    p.OutputSchema,
+		Tools:           p.Tools,
+		ToolChoice:      p.ToolChoice,
+
+		ProviderId:          p.ProviderId,
+		FallbackProviderIds: p.FallbackProviderIds,
+		FallbackRules:       p.FallbackRules,
+	}
+}
+
+// ApplyPromptSnapshot returns the prompt as it was in the snapshot.
+// enabled, debug, cache and public level are not versioned, the latest ones apply.
+// the snapshots taken before the provider was recorded run on the latest providers.
+func ApplyPromptSnapshot(p ent.Prompt, snapshot schema.PromptComplete) ent.Prompt {
+	p.Description = snapshot.Description
+	p.TokenCount = snapshot.TokenCount
+	p.Prompts = snapshot.Prompts
+	p.Variables = snapshot.Variables
+	p.Version = snapshot.Version
+	p.ModelParameters = snapshot.ModelParameters
+	p.OutputSchema = snapshot.OutputSchema
+	p.Tools = snapshot.Tools
+	p.ToolChoice = snapshot.ToolChoice
+	if snapshot.ProviderId > 0 {
+		p.ProviderId = snapshot.ProviderId
+		p.FallbackProviderIds = snapshot.FallbackProviderIds
+		p.FallbackRules = snapshot.FallbackRules
+	}
+	return p
+}
+
+// GetPromptVersion returns the prompt at the version, the latest prompt is returned for its own version
+func GetPromptVersion(ctx context.Context, p ent.Prompt, version int) (ent.Prompt, error) {
+	if version == 0 || version == p.Version {
+		return p, nil
+	}
+	if version > p.Version {
+		return p, ErrorPromptVersionNotFound
+	}
+
+	// the versions never change, they are cached as long as the prompts
+	key := fmt.Sprintf("prompt-version:%d:%d", p.ID, version)
+	var snapshot schema.PromptComplete
+	err := Cache.Get(ctx, key, &snapshot)
+	if err == nil {
+		return ApplyPromptSnapshot(p, snapshot), nil
+	}
+	if !errors.Is(err, cache.ErrCacheMiss) {
+		return p, err
+	}
+
+	h, err := EntClient.History.
+		Query().
+		Where(history.PromptId(p.ID), history.Version(version)).
+		Order(ent.Desc(history.FieldID)).
+		First(ctx)
+	if err != nil {
+		if ent.IsNotFound(err) {
+			return p, ErrorPromptVersionNotFound
+		}
+		return p, err
+	}
+	snapshot = h.Snapshot
+	snapshot.Version = h.Version
+	Cache.Set(&cache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: snapshot,
+		TTL:   24 * time.Hour,
+	})
+	return ApplyPromptSnapshot(p, snapshot), nil
+}
+
+// MigratePromptVersions numbers the histories of the prompts created before the
+// versions, from the oldest one. the prompts get the version after them.
+func MigratePromptVersions(ctx context.Context) (int, error) {
+	legacy, err := EntClient.
+		Prompt.
+		Query().
+		Where(prompt.Version(0)).
+		All(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, p := range legacy {
+		histories, err := EntClient.History.
+			Query().
+			Where(history.PromptId(p.ID)).
+			Order(ent.Asc(history.FieldID)).
+			All(ctx)
+		if err != nil {
+			return i, err
+		}
+		for j, h := range histories {
+			snapshot := h.Snapshot
+			snapshot.Version = j + 1
+			err = EntClient.History.
+				UpdateOneID(h.ID).
+				SetVersion(j + 1).
+				SetSnapshot(snapshot).
+				Exec(ctx)
+			if err != nil {
+				return i, err
+			}
+		}
+		err = EntClient.Prompt.
+			UpdateOneID(p.ID).
+			SetVersion(len(histories) + 1).
+			Exec(ctx)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(legacy), nil
+}
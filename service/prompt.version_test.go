@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptVersionParse(t *testing.T) {
+	hid, version, err := ParsePromptVersion("abc", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", hid)
+	assert.Equal(t, 0, version)
+
+	hid, version, err = ParsePromptVersion("abc@v3", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", hid)
+	assert.Equal(t, 3, version)
+
+	hid, version, err = ParsePromptVersion("abc", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", hid)
+	assert.Equal(t, 2, version)
+
+	// the suffix wins over the query
+	_, version, err = ParsePromptVersion("abc@v4", "2")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, version)
+
+	for _, id := range []string{"abc@v", "abc@v0", "abc@v-1", "abc@vx"} {
+		_, _, err = ParsePromptVersion(id, "")
+		assert.ErrorIs(t, err, ErrorInvalidPromptVersion, id)
+	}
+	_, _, err = ParsePromptVersion("abc", "latest")
+	assert.ErrorIs(t, err, ErrorInvalidPromptVersion)
+
+	assert.Equal(t, "abc@v3", PromptVersionID("abc", 3))
+}
+
+func TestPromptVersionSnapshot(t *testing.T) {
+	temperature := 0.2
+	old := ent.Prompt{
+		ID:              1,
+		Name:            "p",
+		Version:         2,
+		Enabled:         true,
+		Prompts:         []schema.PromptRow{{Role: "system", Prompt: "old"}},
+		ProviderId:      3,
+		ModelParameters: &schema.ModelParameters{Temperature: &temperature},
+	}
+	snapshot := PromptSnapshot(old)
+	assert.Equal(t, 2, snapshot.Version)
+	assert.Equal(t, 3, snapshot.ProviderId)
+
+	latest := old
+	latest.Version = 3
+	latest.Enabled = false
+	latest.Prompts = []schema.PromptRow{{Role: "system", Prompt: "new"}}
+	latest.ProviderId = 4
+	latest.ModelParameters = nil
+
+	pinned := ApplyPromptSnapshot(latest, snapshot)
+	assert.Equal(t, 2, pinned.Version)
+	assert.Equal(t, "old", pinned.Prompts[0].Prompt)
+	assert.Equal(t, 3, pinned.ProviderId)
+	assert.Equal(t, &temperature, pinned.ModelParameters.Temperature)
+	// not versioned
+	assert.False(t, pinned.Enabled)
+
+	// the snapshots without a provider run on the latest one
+	snapshot.ProviderId = 0
+	assert.Equal(t, 4, ApplyPromptSnapshot(latest, snapshot).ProviderId)
+
+	// the latest version needs no history
+	p, err := GetPromptVersion(context.Background(), latest, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, "new", p.Prompts[0].Prompt)
+	p, err = GetPromptVersion(context.Background(), latest, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, p.Version)
+	_, err = GetPromptVersion(context.Background(), latest, 4)
+	assert.ErrorIs(t, err, ErrorPromptVersionNotFound)
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"strconv"
+)
+
+// the helpers below read values from `Provider.Config`. the config is decoded
+// from JSON, so numbers arrive as float64 but may also be sent as strings.
+
+func providerConfigString(config map[string]interface{}, key string, defaultValue string) string {
+	v, ok := config[key]
+	if !ok || v == nil {
+		return defaultValue
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return defaultValue
+}
+
+func providerConfigFloat(config map[string]interface{}, key string, defaultValue float64) float64 {
+	v, ok := config[key]
+	if !ok || v == nil {
+		return defaultValue
+	}
+	switch value := v.(type) {
+	case float64:
+		return value
+	case int:
+		return float64(value)
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+func providerConfigInt(config map[string]interface{}, key string, defaultValue int) int {
+	return int(providerConfigFloat(config, key, float64(defaultValue)))
+}
+
+func providerConfigBool(config map[string]interface{}, key string, defaultValue bool) bool {
+	v, ok := config[key]
+	if !ok || v == nil {
+		return defaultValue
+	}
+	switch value := v.(type) {
+	case bool:
+		return value
+	case string:
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
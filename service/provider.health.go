@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/redis/go-redis/v9"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/sirupsen/logrus"
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "halfOpen"
+)
+
+// ProviderHealth is the circuit breaker state of a provider
+type ProviderHealth struct {
+	State     CircuitState
+	Failures  int
+	OpenUntil *time.Time
+}
+
+// CircuitBreaker fails fast while a provider is unhealthy. the state lives in
+// redis, so all instances share it. it reads its settings from `Provider.Config`:
+//
+//	breakerFailureThreshold consecutive failures to open the circuit, defaults to 5. 0 disables it
+//	breakerCooldownMs       how long the circuit stays open before a probe call, defaults to 30000
+//
+// once the cooldown passed the circuit is half open: one call goes through as
+// a probe and closes the circuit on success or opens it again on failure.
+type CircuitBreaker struct {
+	providerID int
+	threshold  int
+	cooldown   time.Duration
+	redis      *redis.Client
+}
+
+func NewCircuitBreaker(provider *ent.Provider) CircuitBreaker {
+	return CircuitBreaker{
+		providerID: provider.ID,
+		threshold:  providerConfigInt(provider.Config, "breakerFailureThreshold", 5),
+		cooldown:   time.Duration(providerConfigInt(provider.Config, "breakerCooldownMs", 30_000)) * time.Millisecond,
+		redis:      redisClient,
+	}
+}
+
+// the providers of the legacy project settings have no id and are not tracked
+func (b CircuitBreaker) enabled() bool {
+	return b.redis != nil && b.providerID > 0 && b.threshold > 0
+}
+
+func (b CircuitBreaker) key(name string) string {
+	return fmt.Sprintf("provider-health:%d:%s", b.providerID, name)
+}
+
+func circuitState(failures, threshold int, openTTL time.Duration) CircuitState {
+	if openTTL > 0 {
+		return CircuitOpen
+	}
+	if threshold > 0 && failures >= threshold {
+		return CircuitHalfOpen
+	}
+	return CircuitClosed
+}
+
+func (b CircuitBreaker) Health(ctx context.Context) (health ProviderHealth, err error) {
+	health.State = CircuitClosed
+	if !b.enabled() {
+		return
+	}
+	pipe := b.redis.Pipeline()
+	failuresCmd := pipe.Get(ctx, b.key("failures"))
+	openCmd := pipe.PTTL(ctx, b.key("open"))
+	_, err = pipe.Exec(ctx)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return
+	}
+	err = nil
+
+	health.Failures, _ = failuresCmd.Int()
+	openTTL := openCmd.Val()
+	health.State = circuitState(health.Failures, b.threshold, openTTL)
+	if health.State == CircuitOpen {
+		openUntil := time.Now().Add(openTTL)
+		health.OpenUntil = &openUntil
+	}
+	return
+}
+
+func (b CircuitBreaker) openError() error {
+	return &openai.APIError{
+		Code:           "circuit_open",
+		Type:           "circuit_open",
+		Message:        fmt.Sprintf("provider %d is unhealthy, try again later", b.providerID),
+		HTTPStatus:     http.StatusText(http.StatusServiceUnavailable),
+		HTTPStatusCode: http.StatusServiceUnavailable,
+	}
+}
+
+// Allow returns an error if the call should not be sent to the provider.
+// a broken redis never blocks the calls.
+func (b CircuitBreaker) Allow(ctx context.Context) error {
+	if !b.enabled() {
+		return nil
+	}
+	health, err := b.Health(ctx)
+	if err != nil {
+		logrus.Warnln("circuit breaker:", err)
+		return nil
+	}
+	switch health.State {
+	case CircuitOpen:
+		return b.openError()
+	case CircuitHalfOpen:
+		// only one probe at a time
+		ok, err := b.redis.SetNX(ctx, b.key("probe"), 1, b.cooldown).Result()
+		if err != nil {
+			logrus.Warnln("circuit breaker:", err)
+			return nil
+		}
+		if !ok {
+			return b.openError()
+		}
+	}
+	return nil
+}
+
+func (b CircuitBreaker) Success(ctx context.Context) {
+	if !b.enabled() {
+		return
+	}
+	if err := b.redis.Del(ctx, b.key("failures"), b.key("probe")).Err(); err != nil {
+		logrus.Warnln("circuit breaker:", err)
+	}
+}
+
+func (b CircuitBreaker) Failure(ctx context.Context) {
+	if !b.enabled() {
+		return
+	}
+	// the request may be cancelled already, the failure should be recorded anyway
+	ctx = context.WithoutCancel(ctx)
+	pipe := b.redis.Pipeline()
+	failuresCmd := pipe.Incr(ctx, b.key("failures"))
+	// forget the failures of a provider which is not used for a while
+	pipe.Expire(ctx, b.key("failures"), 10*b.cooldown)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warnln("circuit breaker:", err)
+		return
+	}
+	if int(failuresCmd.Val()) < b.threshold {
+		return
+	}
+	pipe = b.redis.Pipeline()
+	pipe.Set(ctx, b.key("open"), 1, b.cooldown)
+	pipe.Del(ctx, b.key("probe"))
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warnln("circuit breaker:", err)
+		return
+	}
+	logrus.Warnf("provider %d is unhealthy, circuit open for %s", b.providerID, b.cooldown)
+}
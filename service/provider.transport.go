@@ -0,0 +1,174 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+)
+
+// providerTransportSettings is everything of a provider which affects its http
+// client. the transport settings are read from `Provider.Config`:
+//
+//	timeoutMs             time to wait for the response headers, streams may take longer
+//	proxyUrl              http(s) or socks5 proxy for the provider calls
+//	tlsInsecureSkipVerify skip the verification of the server certificate
+//	tlsServerName         server name to verify the certificate against
+//	tlsCACert             PEM encoded CA certificates to trust instead of the system ones
+//	tlsClientCert         PEM encoded client certificate for mutual TLS
+//	tlsClientKey          PEM encoded key of the client certificate
+type providerTransportSettings struct {
+	Headers        map[string]string
+	OrganizationId string
+	// gemini needs its api key as a header once a custom http client is used
+	GoogleAPIKey string
+
+	Timeout            time.Duration
+	ProxyURL           string
+	InsecureSkipVerify bool
+	ServerName         string
+	CACert             string
+	ClientCert         string
+	ClientKey          string
+}
+
+func newProviderTransportSettings(provider *ent.Provider) providerTransportSettings {
+	config := provider.Config
+	settings := providerTransportSettings{
+		Headers:            provider.Headers,
+		OrganizationId:     provider.OrganizationId,
+		Timeout:            time.Duration(providerConfigInt(config, "timeoutMs", 0)) * time.Millisecond,
+		ProxyURL:           providerConfigString(config, "proxyUrl", ""),
+		InsecureSkipVerify: providerConfigBool(config, "tlsInsecureSkipVerify", false),
+		ServerName:         providerConfigString(config, "tlsServerName", ""),
+		CACert:             providerConfigString(config, "tlsCACert", ""),
+		ClientCert:         providerConfigString(config, "tlsClientCert", ""),
+		ClientKey:          providerConfigString(config, "tlsClientKey", ""),
+	}
+	if provider.Source == "gemini" {
+		settings.GoogleAPIKey = provider.ApiKey
+	}
+	return settings
+}
+
+func (s providerTransportSettings) tlsConfig() (*tls.Config, error) {
+	if !s.InsecureSkipVerify && s.ServerName == "" && s.CACert == "" && s.ClientCert == "" {
+		return nil, nil
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: s.InsecureSkipVerify,
+		ServerName:         s.ServerName,
+	}
+	if s.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(s.CACert)) {
+			return nil, errors.New("provider: invalid tlsCACert")
+		}
+		config.RootCAs = pool
+	}
+	if s.ClientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(s.ClientCert), []byte(s.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("provider: invalid tlsClientCert or tlsClientKey: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (s providerTransportSettings) transport() (*http.Transport, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.ResponseHeaderTimeout = s.Timeout
+	if s.ProxyURL != "" {
+		proxyURL, err := url.Parse(s.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("provider: invalid proxyUrl: %w", err)
+		}
+		base.Proxy = http.ProxyURL(proxyURL)
+	}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		base.TLSClientConfig = tlsConfig
+	}
+	return base, nil
+}
+
+// providerHeaderTransport adds the configured headers of the provider to each request
+type providerHeaderTransport struct {
+	base     http.RoundTripper
+	settings providerTransportSettings
+}
+
+func (t providerHeaderTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if len(t.settings.Headers) == 0 && t.settings.OrganizationId == "" && t.settings.GoogleAPIKey == "" {
+		return t.base.RoundTrip(r)
+	}
+	// a RoundTripper must not modify the request
+	r = r.Clone(r.Context())
+	if t.settings.GoogleAPIKey != "" {
+		r.Header.Set("x-goog-api-key", t.settings.GoogleAPIKey)
+	}
+	if t.settings.OrganizationId != "" {
+		r.Header.Set("OpenAI-Organization", t.settings.OrganizationId)
+	}
+	// the headers of the provider win, gateways may need to replace the defaults
+	for key, value := range t.settings.Headers {
+		r.Header.Set(key, value)
+	}
+	return t.base.RoundTrip(r)
+}
+
+// providerHTTPClient is the client of a provider with the settings it was built from
+type providerHTTPClient struct {
+	settings  providerTransportSettings
+	transport *http.Transport
+	client    *http.Client
+}
+
+var (
+	providerHTTPClientsMu sync.Mutex
+	// the clients by the id of their provider, so the connections are reused
+	// across calls. the client is replaced once the settings of the provider change
+	providerHTTPClients = map[int]*providerHTTPClient{}
+)
+
+// GetProviderHTTPClient returns the http client for the calls to the provider
+func GetProviderHTTPClient(provider *ent.Provider) (*http.Client, error) {
+	settings := newProviderTransportSettings(provider)
+
+	providerHTTPClientsMu.Lock()
+	defer providerHTTPClientsMu.Unlock()
+	cached, ok := providerHTTPClients[provider.ID]
+	if ok && reflect.DeepEqual(cached.settings, settings) {
+		return cached.client, nil
+	}
+	transport, err := settings.transport()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{
+		Transport: retryAfterTransport{
+			base: providerHeaderTransport{base: transport, settings: settings},
+		},
+	}
+	providerHTTPClients[provider.ID] = &providerHTTPClient{
+		settings:  settings,
+		transport: transport,
+		client:    client,
+	}
+	// the calls in flight keep their connections, only the idle ones are closed
+	if ok {
+		cached.transport.CloseIdleConnections()
+	}
+	return client, nil
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTransportTestPrompt() ent.Prompt {
+	return ent.Prompt{
+		Prompts: []schema.PromptRow{{Role: "user", Prompt: "Hi"}},
+	}
+}
+
+func writeOpenAITestReply(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{
+		"id": "chatcmpl-1",
+		"object": "chat.completion",
+		"choices": [{"index": 0, "message": {"role": "assistant", "content": "Hello"}, "finish_reason": "stop"}],
+		"usage": {"prompt_tokens": 1, "completion_tokens": 1, "total_tokens": 2}
+	}`)
+}
+
+func TestProviderTransportHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	provider := &ent.Provider{
+		Source:         "openai",
+		Endpoint:       server.URL + "/v1",
+		ApiKey:         "test-key",
+		OrganizationId: "org-123",
+		DefaultModel:   "gpt-4o",
+		Headers: map[string]string{
+			"Helicone-Auth": "Bearer helicone-key",
+			"X-Team":        "search",
+		},
+	}
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(context.Background(), provider, newTransportTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", res.Choices[0].Message.Content)
+	assert.Equal(t, "Bearer test-key", received.Get("Authorization"))
+	assert.Equal(t, "org-123", received.Get("OpenAI-Organization"))
+	assert.Equal(t, "Bearer helicone-key", received.Get("Helicone-Auth"))
+	assert.Equal(t, "search", received.Get("X-Team"))
+}
+
+func TestProviderTransportTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	provider := &ent.Provider{
+		Source:   "openai",
+		Endpoint: server.URL + "/v1",
+		Config:   map[string]interface{}{"timeoutMs": float64(20)},
+	}
+
+	svc := NewIsomorphicAIService()
+	_, err := svc.Chat(context.Background(), provider, newTransportTestPrompt(), nil, "")
+	assert.NotNil(t, err)
+	assert.Equal(t, FallbackOnTimeout, fallbackErrorClass(err))
+}
+
+func TestProviderTransportProxy(t *testing.T) {
+	var proxiedURL string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a forward proxy receives the absolute url of the target
+		proxiedURL = r.URL.String()
+		writeOpenAITestReply(w)
+	}))
+	defer proxy.Close()
+
+	provider := &ent.Provider{
+		Source:   "openai",
+		Endpoint: "http://llm.internal/v1",
+		Config:   map[string]interface{}{"proxyUrl": proxy.URL},
+	}
+
+	svc := NewIsomorphicAIService()
+	res, err := svc.Chat(context.Background(), provider, newTransportTestPrompt(), nil, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", res.Choices[0].Message.Content)
+	assert.Equal(t, "http://llm.internal/v1/chat/completions", proxiedURL)
+}
+
+func TestProviderTransportTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	svc := NewIsomorphicAIService()
+	newProvider := func(config map[string]interface{}) *ent.Provider {
+		return &ent.Provider{Source: "openai", Endpoint: server.URL + "/v1", Config: config}
+	}
+
+	// the test server is signed by an unknown authority
+	_, err := svc.Chat(context.Background(), newProvider(nil), newTransportTestPrompt(), nil, "")
+	assert.NotNil(t, err)
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	_, err = svc.Chat(context.Background(), newProvider(map[string]interface{}{
+		"tlsCACert": string(caCert),
+	}), newTransportTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	_, err = svc.Chat(context.Background(), newProvider(map[string]interface{}{
+		"tlsInsecureSkipVerify": true,
+	}), newTransportTestPrompt(), nil, "")
+	assert.Nil(t, err)
+
+	_, err = svc.Chat(context.Background(), newProvider(map[string]interface{}{
+		"tlsCACert": "not a certificate",
+	}), newTransportTestPrompt(), nil, "")
+	assert.ErrorContains(t, err, "invalid tlsCACert")
+}
+
+func TestProviderTransportClientReuse(t *testing.T) {
+	a, err := GetProviderHTTPClient(&ent.Provider{ID: 9001, Source: "openai", Headers: map[string]string{"X-Team": "search"}})
+	assert.Nil(t, err)
+	b, err := GetProviderHTTPClient(&ent.Provider{ID: 9001, Source: "openai", Headers: map[string]string{"X-Team": "search"}})
+	assert.Nil(t, err)
+	assert.Same(t, a, b)
+
+	other, err := GetProviderHTTPClient(&ent.Provider{ID: 9002, Source: "openai", Headers: map[string]string{"X-Team": "search"}})
+	assert.Nil(t, err)
+	assert.NotSame(t, a, other)
+
+	// the settings of the provider changed, the client is replaced
+	c, err := GetProviderHTTPClient(&ent.Provider{ID: 9001, Source: "openai", Headers: map[string]string{"X-Team": "ads"}})
+	assert.Nil(t, err)
+	assert.NotSame(t, a, c)
+	d, err := GetProviderHTTPClient(&ent.Provider{ID: 9001, Source: "openai", Headers: map[string]string{"X-Team": "ads"}})
+	assert.Nil(t, err)
+	assert.Same(t, c, d)
+}
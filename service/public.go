@@ -1,7 +1,20 @@
 package service
 
+import "github.com/PromptPal/PromptPal/ent/schema"
+
+// results of a prompt call
+const (
+	PromptCallResultSuccess       = 0
+	PromptCallResultFail          = 1
+	PromptCallResultInvalidOutput = 2
+)
+
 type APIRunPromptResponse struct {
 	PromptID           string `json:"id"`
 	ResponseMessage    string `json:"message"`
 	ResponseTokenCount int    `json:"tokenCount"`
+	// the parsed reply, only for prompts with an output schema
+	ResponseObject any `json:"object,omitempty"`
+	// the tools the model wants to call, for prompts with tools
+	ToolCalls []schema.ToolCall `json:"toolCalls,omitempty"`
 }
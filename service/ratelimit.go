@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrorRateLimited = errors.New("the open token reached its limit")
+
+// RateLimit is a limit of an open token in a sliding window
+type RateLimit struct {
+	// requests, tokens or costCents
+	Name string
+	// minute or day, the name of the window
+	Per    string
+	Limit  float64
+	Window time.Duration
+}
+
+// RateLimitStatus is the usage of a limit in the current sliding window
+type RateLimitStatus struct {
+	RateLimit
+	Used float64
+	// until the window slides past the oldest usage
+	Reset   time.Duration
+	Allowed bool
+}
+
+func (s RateLimitStatus) Remaining() float64 {
+	return math.Max(0, s.Limit-s.Used)
+}
+
+func (s RateLimitStatus) Error() error {
+	return fmt.Errorf("%w of %g %s per %s, retry in %ds", ErrorRateLimited, s.Limit, s.Name, s.Per, int(math.Ceil(s.Reset.Seconds())))
+}
+
+// OpenTokenRateLimits returns the limits the token has
+func OpenTokenRateLimits(ot ent.OpenToken) []RateLimit {
+	limits := []RateLimit{}
+	if ot.TokensPerDay > 0 {
+		limits = append(limits, RateLimit{Name: "tokens", Per: "day", Limit: float64(ot.TokensPerDay), Window: 24 * time.Hour})
+	}
+	if ot.CostCentsPerDay > 0 {
+		limits = append(limits, RateLimit{Name: "costCents", Per: "day", Limit: ot.CostCentsPerDay, Window: 24 * time.Hour})
+	}
+	// the requests come last, a request rejected by another limit is not counted
+	if ot.RequestsPerMinute > 0 {
+		limits = append(limits, RateLimit{Name: "requests", Per: "minute", Limit: float64(ot.RequestsPerMinute), Window: time.Minute})
+	}
+	return limits
+}
+
+// windowKeys returns the keys of the fixed window of the time and the one before. the
+// sliding window takes the part of the previous window it still covers, by weight.
+func (l RateLimit) windowKeys(tokenID int, now time.Time) (current, previous string, weight float64, reset time.Duration) {
+	size := l.Window.Milliseconds()
+	index := now.UnixMilli() / size
+	elapsed := now.UnixMilli() - index*size
+	current = fmt.Sprintf("rate-limit:open-token:%d:%s:%d", tokenID, l.Name, index)
+	previous = fmt.Sprintf("rate-limit:open-token:%d:%s:%d", tokenID, l.Name, index-1)
+	weight = 1 - float64(elapsed)/float64(size)
+	reset = time.Duration(size-elapsed) * time.Millisecond
+	return
+}
+
+// checks the usage of the sliding window and adds the amount if it is below the limit
+var consumeRateLimit = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+local used = previous * tonumber(ARGV[2]) + current
+if used >= tonumber(ARGV[1]) then
+	return {0, tostring(used)}
+end
+local amount = tonumber(ARGV[3])
+if amount > 0 then
+	redis.call("INCRBYFLOAT", KEYS[1], ARGV[3])
+	redis.call("PEXPIRE", KEYS[1], ARGV[4])
+	used = used + amount
+end
+return {1, tostring(used)}
+`)
+
+func (l RateLimit) consume(ctx context.Context, tokenID int, amount float64, now time.Time) (status RateLimitStatus, err error) {
+	current, previous, weight, reset := l.windowKeys(tokenID, now)
+	status = RateLimitStatus{RateLimit: l, Reset: reset, Allowed: true}
+	// the key lives until the window after it ends
+	ttl := 2 * l.Window.Milliseconds()
+	result, err := consumeRateLimit.Run(ctx, redisClient, []string{current, previous}, l.Limit, weight, amount, ttl).Slice()
+	if err != nil {
+		return
+	}
+	if len(result) != 2 {
+		err = fmt.Errorf("unexpected rate limit result: %v", result)
+		return
+	}
+	allowed, _ := result[0].(int64)
+	used, _ := result[1].(string)
+	status.Allowed = allowed == 1
+	status.Used, err = strconv.ParseFloat(used, 64)
+	return
+}
+
+// CheckOpenTokenRateLimits counts a request of the token and checks the usage of
+// its limits. it returns the status of the limit closest to exhaustion, nil if the
+// token has none. a broken redis never blocks the calls.
+func CheckOpenTokenRateLimits(ctx context.Context, ot ent.OpenToken, now time.Time) *RateLimitStatus {
+	if redisClient == nil {
+		return nil
+	}
+	var closest *RateLimitStatus
+	for _, limit := range OpenTokenRateLimits(ot) {
+		amount := 0.0
+		if limit.Name == "requests" {
+			amount = 1
+		}
+		status, err := limit.consume(ctx, ot.ID, amount, now)
+		if err != nil {
+			logrus.Warnln("rate limit:", err)
+			continue
+		}
+		if !status.Allowed {
+			return &status
+		}
+		if closest == nil || status.Remaining()/status.Limit < closest.Remaining()/closest.Limit {
+			closest = &status
+		}
+	}
+	return closest
+}
+
+// RecordOpenTokenUsage adds the tokens and the cost of a call to the daily limits of the token
+func RecordOpenTokenUsage(ctx context.Context, ot ent.OpenToken, tokens int, costCents float64, at time.Time) {
+	if redisClient == nil {
+		return
+	}
+	// the request may be cancelled already, the usage should be recorded anyway
+	ctx = context.WithoutCancel(ctx)
+	pipe := redisClient.Pipeline()
+	for _, limit := range OpenTokenRateLimits(ot) {
+		amount := 0.0
+		switch limit.Name {
+		case "tokens":
+			amount = float64(tokens)
+		case "costCents":
+			amount = costCents
+		}
+		if amount <= 0 {
+			continue
+		}
+		current, _, _, _ := limit.windowKeys(ot.ID, at)
+		pipe.IncrByFloat(ctx, current, amount)
+		pipe.PExpire(ctx, current, 2*limit.Window)
+	}
+	if pipe.Len() == 0 {
+		return
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logrus.Warnln("rate limit:", err)
+	}
+}
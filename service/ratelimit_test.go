@@ -0,0 +1,49 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitOpenTokenLimits(t *testing.T) {
+	assert.Empty(t, OpenTokenRateLimits(ent.OpenToken{}))
+
+	limits := OpenTokenRateLimits(ent.OpenToken{RequestsPerMinute: 60, TokensPerDay: 1000, CostCentsPerDay: 2.5})
+	assert.Len(t, limits, 3)
+	assert.Equal(t, "tokens", limits[0].Name)
+	assert.Equal(t, "costCents", limits[1].Name)
+	// a request rejected by another limit is not counted
+	assert.Equal(t, "requests", limits[2].Name)
+	assert.Equal(t, time.Minute, limits[2].Window)
+	assert.Equal(t, 60.0, limits[2].Limit)
+}
+
+func TestRateLimitWindowKeys(t *testing.T) {
+	limit := RateLimit{Name: "requests", Per: "minute", Limit: 10, Window: time.Minute}
+	now := time.Unix(120+15, 0)
+
+	current, previous, weight, reset := limit.windowKeys(3, now)
+	assert.Equal(t, "rate-limit:open-token:3:requests:2", current)
+	assert.Equal(t, "rate-limit:open-token:3:requests:1", previous)
+	// 15 seconds into the window, the previous one still covers 3/4 of the sliding window
+	assert.InDelta(t, 0.75, weight, 0.0001)
+	assert.Equal(t, 45*time.Second, reset)
+
+	current, _, weight, _ = limit.windowKeys(3, time.Unix(180, 0))
+	assert.Equal(t, "rate-limit:open-token:3:requests:3", current)
+	assert.Equal(t, 1.0, weight)
+}
+
+func TestRateLimitStatus(t *testing.T) {
+	status := RateLimitStatus{
+		RateLimit: RateLimit{Name: "tokens", Per: "day", Limit: 100, Window: 24 * time.Hour},
+		Used:      120,
+		Reset:     1500 * time.Millisecond,
+	}
+	assert.Equal(t, 0.0, status.Remaining())
+	assert.ErrorIs(t, status.Error(), ErrorRateLimited)
+	assert.Contains(t, status.Error().Error(), "100 tokens per day, retry in 2s")
+}
@@ -0,0 +1,840 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/prompt"
+	"github.com/PromptPal/PromptPal/ent/schema"
+)
+
+var ErrorTemplatePartialNotFound = errors.New("partial not found")
+var ErrorTemplatePartialAmbiguous = errors.New("partial is the name of several prompts")
+
+const (
+	// the deepest the partials may include each other
+	maxTemplatePartialDepth = 5
+	// the longest text a row renders to, the loops may repeat a lot
+	maxTemplateOutput = 1 << 20
+)
+
+// TemplateError is an error of a prompt template, the line and the column start at 1
+type TemplateError struct {
+	Line   int
+	Column int
+	// the partial the error is in, empty if it is in the row itself
+	Partial string
+	Err     error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Partial != "" {
+		return fmt.Sprintf("partial %s: line %d, column %d: %s", e.Partial, e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+type templateNodeKind int
+
+const (
+	templateText templateNodeKind = iota
+	templateOutput
+	templateIf
+	templateEach
+	templatePartial
+	// only while parsing, they do not end up in the tree
+	templateElse
+	templateClose
+)
+
+type templateFilter struct {
+	name string
+	// the value of the default filter
+	arg string
+}
+
+type templateNode struct {
+	kind templateNodeKind
+	// the text, or the tag as it is written
+	text string
+	// the byte offsets of the tag in the source
+	start, end   int
+	line, column int
+	path         []string
+	filters      []templateFilter
+	// the partial or the closed block
+	name string
+	// the template of the partial once it is included
+	partial  *Template
+	body     []*templateNode
+	elseBody []*templateNode
+	hasElse  bool
+}
+
+// Template is a parsed prompt. it knows {{name}}, {{#if name}}, {{#each items}},
+// {{else}}, {{> partial}} and the filters default, json, trim and upper.
+// the tags which start with another character, e.g. {{"key": 1}}, are text.
+type Template struct {
+	src   string
+	nodes []*templateNode
+}
+
+type templateToken struct {
+	text   string
+	offset int
+	quoted bool
+}
+
+type templateParser struct {
+	src string
+	// the byte offsets of the starts of the lines
+	lineStarts []int
+}
+
+var templateNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+var templateIndexPattern = regexp.MustCompile(`^[0-9]+$`)
+
+func isTemplateSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isTemplateTagStart(c byte) bool {
+	return c == '#' || c == '/' || c == '>' || c == '@' || c == '_' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *templateParser) position(offset int) (line int, column int) {
+	line = sort.SearchInts(p.lineStarts, offset+1)
+	start := p.lineStarts[line-1]
+	return line, utf8.RuneCountInString(p.src[start:offset]) + 1
+}
+
+func (p *templateParser) errorAt(offset int, format string, args ...any) *TemplateError {
+	line, column := p.position(offset)
+	return &TemplateError{Line: line, Column: column, Err: fmt.Errorf(format, args...)}
+}
+
+// ParseTemplate parses the text of a row, the errors are TemplateErrors
+func ParseTemplate(src string) (*Template, error) {
+	p := &templateParser{src: src, lineStarts: []int{0}}
+	for i := 0; i < len(src); i++ {
+		if src[i] == '\n' {
+			p.lineStarts = append(p.lineStarts, i+1)
+		}
+	}
+
+	root := &templateNode{}
+	stack := []*templateNode{root}
+	add := func(n *templateNode) {
+		top := stack[len(stack)-1]
+		if top.hasElse {
+			top.elseBody = append(top.elseBody, n)
+		} else {
+			top.body = append(top.body, n)
+		}
+	}
+
+	last := 0
+	for i := 0; i < len(src); {
+		open := strings.Index(src[i:], "{{")
+		if open < 0 {
+			break
+		}
+		open += i
+		content := open + 2
+		for content < len(src) && isTemplateSpace(src[content]) {
+			content++
+		}
+		if content == len(src) || !isTemplateTagStart(src[content]) {
+			i = open + 1
+			continue
+		}
+		closing := strings.Index(src[open+2:], "}}")
+		if closing < 0 {
+			return nil, p.errorAt(open, "the tag is not closed by }}")
+		}
+		end := open + 2 + closing + 2
+		n, err := p.parseTag(open, end)
+		if err != nil {
+			return nil, err
+		}
+		if last < open {
+			add(&templateNode{kind: templateText, text: src[last:open]})
+		}
+		last = end
+		i = end
+
+		top := stack[len(stack)-1]
+		switch n.kind {
+		case templateElse:
+			if top == root {
+				return nil, p.errorAt(open, "{{else}} is outside of a block")
+			}
+			if top.hasElse {
+				return nil, p.errorAt(open, "the block already has an {{else}}")
+			}
+			top.hasElse = true
+		case templateClose:
+			if top == root {
+				return nil, p.errorAt(open, "{{/%s}} closes no block", n.name)
+			}
+			if opened := templateBlockName(top.kind); opened != n.name {
+				return nil, p.errorAt(open, "{{/%s}} closes the {{#%s}} opened at line %d, column %d", n.name, opened, top.line, top.column)
+			}
+			stack = stack[:len(stack)-1]
+		case templateIf, templateEach:
+			add(n)
+			stack = append(stack, n)
+		default:
+			add(n)
+		}
+	}
+	if last < len(src) {
+		add(&templateNode{kind: templateText, text: src[last:]})
+	}
+	if top := stack[len(stack)-1]; top != root {
+		return nil, p.errorAt(top.start, "the {{#%s}} is not closed", templateBlockName(top.kind))
+	}
+	return &Template{src: src, nodes: root.body}, nil
+}
+
+func templateBlockName(kind templateNodeKind) string {
+	if kind == templateEach {
+		return "each"
+	}
+	return "if"
+}
+
+// parseTag parses the tag between the offsets, the braces included
+func (p *templateParser) parseTag(start, end int) (*templateNode, error) {
+	line, column := p.position(start)
+	n := &templateNode{text: p.src[start:end], start: start, end: end, line: line, column: column}
+	tokens, err := p.tokenize(start+2, end-2)
+	if err != nil {
+		return nil, err
+	}
+
+	first := tokens[0]
+	switch {
+	case first.text == "else":
+		n.kind = templateElse
+		return n, p.expectEnd(tokens[1:])
+	case strings.HasPrefix(first.text, "#"):
+		switch first.text {
+		case "#if":
+			n.kind = templateIf
+		case "#each":
+			n.kind = templateEach
+		default:
+			return nil, p.errorAt(first.offset, "unknown block %s", first.text)
+		}
+		if len(tokens) < 2 {
+			return nil, p.errorAt(first.offset, "%s needs a variable", first.text)
+		}
+		if n.path, err = p.parsePath(tokens[1]); err != nil {
+			return nil, err
+		}
+		return n, p.expectEnd(tokens[2:])
+	case strings.HasPrefix(first.text, "/"):
+		n.kind = templateClose
+		n.name = first.text[1:]
+		if n.name != "if" && n.name != "each" {
+			return nil, p.errorAt(first.offset, "unknown block %s", first.text)
+		}
+		return n, p.expectEnd(tokens[1:])
+	case strings.HasPrefix(first.text, ">"):
+		n.kind = templatePartial
+		n.name = first.text[1:]
+		rest := tokens[1:]
+		if n.name == "" {
+			if len(rest) == 0 || rest[0].text == "" {
+				return nil, p.errorAt(first.offset, "the partial needs the name of a prompt")
+			}
+			n.name = rest[0].text
+			rest = rest[1:]
+		}
+		return n, p.expectEnd(rest)
+	}
+
+	n.kind = templateOutput
+	if n.path, err = p.parsePath(first); err != nil {
+		return nil, err
+	}
+	n.filters, err = p.parseFilters(tokens[1:])
+	return n, err
+}
+
+// tokenize splits the content of a tag into words, quoted strings and pipes
+func (p *templateParser) tokenize(from, to int) ([]templateToken, error) {
+	var tokens []templateToken
+	for i := from; i < to; {
+		c := p.src[i]
+		switch {
+		case isTemplateSpace(c):
+			i++
+		case c == '|':
+			tokens = append(tokens, templateToken{text: "|", offset: i})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < to && p.src[j] != '"' {
+				if p.src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= to {
+				return nil, p.errorAt(i, "the string is not closed")
+			}
+			text, err := strconv.Unquote(p.src[i : j+1])
+			if err != nil {
+				return nil, p.errorAt(i, "invalid string %s", p.src[i:j+1])
+			}
+			tokens = append(tokens, templateToken{text: text, offset: i, quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for j < to && !isTemplateSpace(p.src[j]) && p.src[j] != '|' && p.src[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, templateToken{text: p.src[i:j], offset: i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func (p *templateParser) expectEnd(tokens []templateToken) error {
+	if len(tokens) > 0 {
+		return p.errorAt(tokens[0].offset, "unexpected %s", tokens[0].text)
+	}
+	return nil
+}
+
+// parsePath parses a variable, e.g. name, user.name, items.0, this, this.name or @index
+func (p *templateParser) parsePath(token templateToken) ([]string, error) {
+	if token.quoted || token.text == "|" {
+		return nil, p.errorAt(token.offset, "a variable is expected")
+	}
+	if token.text == "@index" {
+		return []string{token.text}, nil
+	}
+	segments := strings.Split(token.text, ".")
+	for i, s := range segments {
+		if !templateNamePattern.MatchString(s) && (i == 0 || !templateIndexPattern.MatchString(s)) {
+			return nil, p.errorAt(token.offset, "invalid variable %s", token.text)
+		}
+	}
+	return segments, nil
+}
+
+func (p *templateParser) parseFilters(tokens []templateToken) ([]templateFilter, error) {
+	var filters []templateFilter
+	for i := 0; i < len(tokens); {
+		if tokens[i].quoted || tokens[i].text != "|" {
+			return nil, p.errorAt(tokens[i].offset, "unexpected %s, the filters start with |", tokens[i].text)
+		}
+		if i+1 == len(tokens) {
+			return nil, p.errorAt(tokens[i].offset, "a filter is expected after |")
+		}
+		name := tokens[i+1]
+		switch {
+		case name.quoted:
+			return nil, p.errorAt(name.offset, "a filter is expected after |")
+		case name.text == "json" || name.text == "trim" || name.text == "upper":
+			filters = append(filters, templateFilter{name: name.text})
+			i += 2
+		case name.text == "default":
+			if i+2 == len(tokens) || !tokens[i+2].quoted {
+				return nil, p.errorAt(name.offset, "default needs a quoted value")
+			}
+			filters = append(filters, templateFilter{name: name.text, arg: tokens[i+2].text})
+			i += 3
+		default:
+			return nil, p.errorAt(name.offset, "unknown filter %s", name.text)
+		}
+	}
+	return filters, nil
+}
+
+// templatePartialNodes returns the partials of the nodes in the order of the source
+func templatePartialNodes(nodes []*templateNode) []*templateNode {
+	var result []*templateNode
+	for _, n := range nodes {
+		if n.kind == templatePartial {
+			result = append(result, n)
+		}
+		result = append(result, templatePartialNodes(n.body)...)
+		result = append(result, templatePartialNodes(n.elseBody)...)
+	}
+	return result
+}
+
+// includePartials loads and parses the partials of the template, and theirs in turn.
+// names are the partials being included, to tell the cycles.
+func (t *Template) includePartials(load func(name string) (string, error), names []string) error {
+	for _, n := range templatePartialNodes(t.nodes) {
+		if slices.Contains(names, n.name) {
+			return templateNodeError(n, "%s includes itself", n.name)
+		}
+		if len(names) > maxTemplatePartialDepth {
+			return templateNodeError(n, "the partials are nested deeper than %d", maxTemplatePartialDepth)
+		}
+		src, err := load(n.name)
+		if err != nil {
+			if errors.Is(err, ErrorTemplatePartialNotFound) || errors.Is(err, ErrorTemplatePartialAmbiguous) {
+				return &TemplateError{Line: n.line, Column: n.column, Err: err}
+			}
+			return err
+		}
+		partial, err := ParseTemplate(src)
+		if err == nil {
+			err = partial.includePartials(load, append(slices.Clone(names), n.name))
+		}
+		if err != nil {
+			var templateErr *TemplateError
+			if errors.As(err, &templateErr) && templateErr.Partial == "" {
+				templateErr.Partial = n.name
+			}
+			return err
+		}
+		n.partial = partial
+	}
+	return nil
+}
+
+// source returns the source of the template, the included partials are in place of their tags
+func (t *Template) source() string {
+	var b strings.Builder
+	t.writeSource(&b)
+	return b.String()
+}
+
+func (t *Template) writeSource(b *strings.Builder) {
+	last := 0
+	for _, n := range templatePartialNodes(t.nodes) {
+		if n.partial == nil {
+			continue
+		}
+		b.WriteString(t.src[last:n.start])
+		n.partial.writeSource(b)
+		last = n.end
+	}
+	b.WriteString(t.src[last:])
+}
+
+func templateNodeError(n *templateNode, format string, args ...any) *TemplateError {
+	return &TemplateError{Line: n.line, Column: n.column, Err: fmt.Errorf(format, args...)}
+}
+
+// templatePart is a chunk of a rendered template
+type templatePart struct {
+	text string
+	// the media variable of the part, empty for the text
+	media string
+}
+
+type templateScope struct {
+	item  any
+	index int
+}
+
+type templateRenderer struct {
+	variables  map[string]string
+	mediaTypes map[string]schema.PromptVariableTypes
+	scopes     []templateScope
+	parts      []templatePart
+	size       int
+}
+
+// Render renders the template with the variables.
+// the variables without a value and the partials which are not included are left as they are written.
+func (t *Template) Render(variables map[string]string) (string, error) {
+	parts, err := t.renderParts(variables, nil)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		b.WriteString(part.text)
+	}
+	return b.String(), nil
+}
+
+// renderParts renders the template, the media variables become parts of their own
+func (t *Template) renderParts(variables map[string]string, mediaTypes map[string]schema.PromptVariableTypes) ([]templatePart, error) {
+	r := &templateRenderer{variables: variables, mediaTypes: mediaTypes}
+	if err := r.render(t.nodes); err != nil {
+		return nil, err
+	}
+	return r.parts, nil
+}
+
+func (r *templateRenderer) render(nodes []*templateNode) error {
+	for _, n := range nodes {
+		var err error
+		switch n.kind {
+		case templateText:
+			err = r.write(n, n.text)
+		case templateOutput:
+			err = r.renderOutput(n)
+		case templateIf:
+			if value, ok := r.lookup(n.path); ok && templateTruthy(value) {
+				err = r.render(n.body)
+			} else {
+				err = r.render(n.elseBody)
+			}
+		case templateEach:
+			err = r.renderEach(n)
+		case templatePartial:
+			if n.partial == nil {
+				err = r.write(n, n.text)
+				break
+			}
+			err = r.render(n.partial.nodes)
+			var templateErr *TemplateError
+			if errors.As(err, &templateErr) && templateErr.Partial == "" {
+				templateErr.Partial = n.name
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *templateRenderer) write(n *templateNode, text string) error {
+	r.size += len(text)
+	if r.size > maxTemplateOutput {
+		return templateNodeError(n, "the prompt renders to more than %d bytes", maxTemplateOutput)
+	}
+	if last := len(r.parts) - 1; last >= 0 && r.parts[last].media == "" {
+		r.parts[last].text += text
+		return nil
+	}
+	r.parts = append(r.parts, templatePart{text: text})
+	return nil
+}
+
+func (r *templateRenderer) renderOutput(n *templateNode) error {
+	value, ok := r.lookup(n.path)
+	for _, f := range n.filters {
+		if f.name == "default" {
+			if !ok || value == "" {
+				value, ok = f.arg, true
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		filtered, err := applyTemplateFilter(f.name, value)
+		if err != nil {
+			return templateNodeError(n, "%s: %s", f.name, err)
+		}
+		value = filtered
+	}
+	if !ok {
+		return r.write(n, n.text)
+	}
+
+	if _, isMedia := r.mediaTypes[n.path[0]]; isMedia && len(n.path) == 1 && len(n.filters) == 0 {
+		r.parts = append(r.parts, templatePart{media: n.path[0]})
+		return nil
+	}
+	text, err := templateString(value)
+	if err != nil {
+		return templateNodeError(n, "%s", err)
+	}
+	return r.write(n, text)
+}
+
+func (r *templateRenderer) renderEach(n *templateNode) error {
+	var items []any
+	value, ok := r.lookup(n.path)
+	if ok {
+		switch v := value.(type) {
+		case []any:
+			items = v
+		case nil:
+		case string:
+			if strings.TrimSpace(v) == "" {
+				break
+			}
+			parsed, err := parseTemplateJSON(v)
+			list, isList := parsed.([]any)
+			if err != nil || !isList {
+				return templateNodeError(n, "%s is not a JSON array", strings.Join(n.path, "."))
+			}
+			items = list
+		default:
+			return templateNodeError(n, "%s is not a JSON array", strings.Join(n.path, "."))
+		}
+	}
+
+	if len(items) == 0 {
+		return r.render(n.elseBody)
+	}
+	for i, item := range items {
+		r.scopes = append(r.scopes, templateScope{item: item, index: i})
+		err := r.render(n.body)
+		r.scopes = r.scopes[:len(r.scopes)-1]
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the variable. the fields of the items of the loops come
+// first, then the variables of the request. the variables holding JSON can be walked into.
+func (r *templateRenderer) lookup(path []string) (any, bool) {
+	var value any
+	rest := path[1:]
+	switch path[0] {
+	case "@index":
+		if len(r.scopes) == 0 {
+			return nil, false
+		}
+		return json.Number(strconv.Itoa(r.scopes[len(r.scopes)-1].index)), true
+	case "this":
+		if len(r.scopes) == 0 {
+			return nil, false
+		}
+		value = r.scopes[len(r.scopes)-1].item
+	default:
+		found := false
+		for i := len(r.scopes) - 1; i >= 0 && !found; i-- {
+			if item, ok := r.scopes[i].item.(map[string]any); ok {
+				value, found = item[path[0]]
+			}
+		}
+		if !found {
+			s, ok := r.variables[path[0]]
+			if !ok {
+				return nil, false
+			}
+			if len(rest) == 0 {
+				return s, true
+			}
+			parsed, err := parseTemplateJSON(s)
+			if err != nil {
+				return nil, false
+			}
+			value = parsed
+		}
+	}
+
+	for _, key := range rest {
+		switch v := value.(type) {
+		case map[string]any:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func parseTemplateJSON(s string) (any, error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+func templateJSON(value any) (string, error) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	// the prompts are not HTML
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// templateString writes a value as text, the arrays and the objects as JSON
+func templateString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return templateJSON(value)
+}
+
+func applyTemplateFilter(name string, value any) (any, error) {
+	if name == "json" {
+		return templateJSON(value)
+	}
+	text, err := templateString(value)
+	if err != nil {
+		return nil, err
+	}
+	if name == "upper" {
+		return strings.ToUpper(text), nil
+	}
+	return strings.TrimSpace(text), nil
+}
+
+// templateTruthy tells if an {{#if}} renders its body.
+// empty values, false, 0, null and the empty arrays and objects are falsy.
+func templateTruthy(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		switch strings.TrimSpace(v) {
+		case "", "false", "0", "null", "[]", "{}":
+			return false
+		}
+	case json.Number:
+		f, err := v.Float64()
+		return err != nil || f != 0
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
+
+// renderPromptRow renders the text of a row. the rows of the prompt are templates, the rows
+// appended to it for a run, the messages and the history of a conversation, are not.
+func renderPromptRow(row schema.PromptRow, variables map[string]string) string {
+	if row.Appended {
+		return replacePlaceholdersLegacy(row.Prompt, variables)
+	}
+	return renderPromptText(row.Prompt, variables)
+}
+
+// renderPromptText renders the text of a row of the prompt. the rows saved before the
+// templates may not be valid templates, they only get their placeholders replaced.
+func renderPromptText(text string, variables map[string]string) string {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		return replacePlaceholdersLegacy(text, variables)
+	}
+	result, err := t.Render(variables)
+	if err != nil {
+		return replacePlaceholdersLegacy(text, variables)
+	}
+	return result
+}
+
+// ValidatePromptTemplates checks the syntax of the rows of a prompt.
+// the partials are not checked, their prompts may be created later.
+func ValidatePromptTemplates(rows []schema.PromptRow) error {
+	for i, row := range rows {
+		if _, err := ParseTemplate(row.Prompt); err != nil {
+			return fmt.Errorf("prompts[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// promptPartialSource is the text a prompt is included with, its rows one after another
+func promptPartialSource(p ent.Prompt) string {
+	texts := make([]string, len(p.Prompts))
+	for i, row := range p.Prompts {
+		texts[i] = row.Prompt
+	}
+	return strings.Join(texts, "\n")
+}
+
+// ExpandPromptPartials includes the partials in the rows of the prompt, they are the latest
+// versions of the prompts of the project with the name. the rows are rendered with the
+// variables to report their errors before the prompt runs, the rows which are not valid templates
+// are kept as they are. it returns the included prompts.
+func ExpandPromptPartials(ctx context.Context, p ent.Prompt, variables map[string]string) (ent.Prompt, []ent.Prompt, error) {
+	var partials []ent.Prompt
+	load := func(name string) (string, error) {
+		i := slices.IndexFunc(partials, func(partial ent.Prompt) bool { return partial.Name == name })
+		if i >= 0 {
+			return promptPartialSource(partials[i]), nil
+		}
+		partial, err := EntClient.Prompt.
+			Query().
+			Where(prompt.ProjectId(p.ProjectId), prompt.Name(name)).
+			Only(ctx)
+		if err != nil {
+			if ent.IsNotFound(err) {
+				return "", fmt.Errorf("%w: %s", ErrorTemplatePartialNotFound, name)
+			}
+			if ent.IsNotSingular(err) {
+				return "", fmt.Errorf("%w: %s", ErrorTemplatePartialAmbiguous, name)
+			}
+			return "", err
+		}
+		partials = append(partials, *partial)
+		return promptPartialSource(*partial), nil
+	}
+
+	rows := slices.Clone(p.Prompts)
+	for i, row := range rows {
+		t, err := ParseTemplate(row.Prompt)
+		if err != nil {
+			// the rows saved before the templates may not be valid templates,
+			// they are sent with their placeholders replaced as before
+			continue
+		}
+		err = t.includePartials(load, []string{p.Name})
+		if err == nil {
+			_, err = t.Render(variables)
+		}
+		if err != nil {
+			return p, nil, fmt.Errorf("prompts[%d]: %w", i, err)
+		}
+		rows[i].Prompt = t.source()
+	}
+	p.Prompts = rows
+	return p, partials, nil
+}
+
+// PromptPartialsVersionID appends the versions of the partials to the version id of the
+// prompt, the replies cached for the prompt are not reused once a partial changes
+func PromptPartialsVersionID(id string, partials []ent.Prompt) string {
+	for _, partial := range partials {
+		id += fmt.Sprintf("+%d@v%d", partial.ID, partial.Version)
+	}
+	return id
+}
@@ -0,0 +1,179 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func renderTestTemplate(t *testing.T, src string, variables map[string]string) string {
+	tpl, err := ParseTemplate(src)
+	assert.Nil(t, err, src)
+	result, err := tpl.Render(variables)
+	assert.Nil(t, err, src)
+	return result
+}
+
+func TestTemplateVariables(t *testing.T) {
+	variables := map[string]string{
+		"name": "John",
+		"city": "  Paris ",
+		"user": `{"name":"Alice","langs":["en","fr"]}`,
+	}
+	cases := map[string]string{
+		"Hello {{name}}!":                           "Hello John!",
+		"Hello {{  name  }}!":                       "Hello John!",
+		"Hello {{unknown}}!":                        "Hello {{unknown}}!",
+		"Hello {{ unknown | upper }}!":              "Hello {{ unknown | upper }}!",
+		`Hello {{ unknown | default "you" }}!`:      "Hello you!",
+		`Hello {{ name | default "you" }}!`:         "Hello John!",
+		"from {{ city | trim | upper }}":            "from PARIS",
+		"{{ name | json }}":                         `"John"`,
+		"{{ user.name }} speaks {{ user.langs.1 }}": "Alice speaks fr",
+		"{{ user.langs }}":                          `["en","fr"]`,
+		`{"key": {{name|json}}}`:                    `{"key": "John"}`,
+		// not tags, they are kept as text
+		`{{"key": 1}}`: `{{"key": 1}}`,
+		"{{ }}":        "{{ }}",
+		"{{{name}}}":   "{John}",
+	}
+	for src, expected := range cases {
+		assert.Equal(t, expected, renderTestTemplate(t, src, variables), src)
+	}
+}
+
+func TestTemplateBlocks(t *testing.T) {
+	src := "{{#if premium}}Dear {{name}}{{else}}Hi{{/if}}: " +
+		"{{#each items}}{{@index}}.{{this.title}} x{{count}}{{#if last}}!{{/if}} {{else}}nothing{{/each}}"
+
+	assert.Equal(t, "Dear John: 0.Book x1 1.Pen x2! ", renderTestTemplate(t, src, map[string]string{
+		"premium": "true",
+		"name":    "John",
+		"items":   `[{"title":"Book","count":1},{"title":"Pen","count":2,"last":true}]`,
+	}))
+	assert.Equal(t, "Hi: nothing", renderTestTemplate(t, src, map[string]string{
+		"premium": "false",
+		"items":   "[]",
+	}))
+	assert.Equal(t, "Hi: nothing", renderTestTemplate(t, src, nil))
+
+	assert.Equal(t, "a-b-", renderTestTemplate(t, "{{#each tags}}{{this}}-{{/each}}", map[string]string{
+		"tags": `["a","b"]`,
+	}))
+	for _, falsy := range []string{"", "0", "false", "null", "[]", "{}"} {
+		assert.Equal(t, "no", renderTestTemplate(t, "{{#if v}}yes{{else}}no{{/if}}", map[string]string{"v": falsy}), falsy)
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	cases := map[string]string{
+		"Hello {{name":                     "line 1, column 7: the tag is not closed by }}",
+		"line\n  {{#if a}}open":            "line 2, column 3: the {{#if}} is not closed",
+		"{{#if a}}x{{/each}}":              "line 1, column 11: {{/each}} closes the {{#if}} opened at line 1, column 1",
+		"{{/if}}":                          "line 1, column 1: {{/if}} closes no block",
+		"{{else}}":                         "line 1, column 1: {{else}} is outside of a block",
+		"{{#if a}}{{else}}{{else}}{{/if}}": "line 1, column 18: the block already has an {{else}}",
+		"{{#unless a}}{{/unless}}":         "line 1, column 3: unknown block #unless",
+		"{{#each}}{{/each}}":               "line 1, column 3: #each needs a variable",
+		"é\n{{ name | lower }}":            "line 2, column 11: unknown filter lower",
+		"{{ name | default }}":             "line 1, column 11: default needs a quoted value",
+		"{{ name other }}":                 "line 1, column 9: unexpected other, the filters start with |",
+		"{{ na-me }}":                      "line 1, column 4: invalid variable na-me",
+		`{{ name | default "open }}`:       "line 1, column 19: the string is not closed",
+		"{{>}}":                            "line 1, column 3: the partial needs the name of a prompt",
+	}
+	for src, message := range cases {
+		_, err := ParseTemplate(src)
+		var templateErr *TemplateError
+		assert.True(t, errors.As(err, &templateErr), src)
+		assert.EqualError(t, err, message, src)
+	}
+
+	tpl, err := ParseTemplate("items:\n  {{#each items}}{{this}}{{/each}}")
+	assert.Nil(t, err)
+	_, err = tpl.Render(map[string]string{"items": "a, b"})
+	assert.EqualError(t, err, "line 2, column 3: items is not a JSON array")
+
+	err = ValidatePromptTemplates([]schema.PromptRow{
+		{Role: "system", Prompt: "you are {{ role }}"},
+		{Role: "user", Prompt: "{{#if a}}"},
+	})
+	assert.EqualError(t, err, "prompts[1]: line 1, column 1: the {{#if}} is not closed")
+}
+
+func TestTemplatePartials(t *testing.T) {
+	sources := map[string]string{
+		"tone":     "Be {{ tone | default \"kind\" }}.",
+		"persona":  "You are {{ name }}. {{> tone}}",
+		"my rules": "{{#each rules}}- {{this}}\n{{/each}}",
+		"loop":     "{{> loop}}",
+		"broken":   "ok\n  {{#if a}}",
+	}
+	load := func(name string) (string, error) {
+		if src, ok := sources[name]; ok {
+			return src, nil
+		}
+		return "", ErrorTemplatePartialNotFound
+	}
+
+	tpl, err := ParseTemplate("{{> persona}}\n{{#if rules}}{{> \"my rules\"}}{{/if}}")
+	assert.Nil(t, err)
+	assert.Nil(t, tpl.includePartials(load, []string{"main"}))
+	assert.Equal(t, "You are {{ name }}. Be {{ tone | default \"kind\" }}.\n{{#if rules}}{{#each rules}}- {{this}}\n{{/each}}{{/if}}", tpl.source())
+	result, err := tpl.Render(map[string]string{"name": "Bob", "rules": `["short","polite"]`})
+	assert.Nil(t, err)
+	assert.Equal(t, "You are Bob. Be kind.\n- short\n- polite\n", result)
+
+	// the partials which are not included are kept as they are written
+	assert.Equal(t, "{{> persona}}", renderTestTemplate(t, "{{> persona}}", nil))
+
+	failures := map[string]string{
+		"{{> missing}}":     "line 1, column 1: partial not found",
+		"x {{> loop}}":      "partial loop: line 1, column 1: loop includes itself",
+		"{{> main}}":        "line 1, column 1: main includes itself",
+		"\n\n {{> broken}}": "partial broken: line 2, column 3: the {{#if}} is not closed",
+	}
+	for src, message := range failures {
+		tpl, err := ParseTemplate(src)
+		assert.Nil(t, err, src)
+		assert.EqualError(t, tpl.includePartials(load, []string{"main"}), message, src)
+	}
+}
+
+func TestTemplateMediaParts(t *testing.T) {
+	variables := map[string]string{"lang": "English", "photo": testImageDataURI}
+	mediaTypes := map[string]schema.PromptVariableTypes{"photo": schema.PromptVariableTypesImage}
+
+	parts := renderMultiContent("{{#if photo}}Look at {{photo}} in {{lang}}.{{/if}}", variables, mediaTypes)
+	assert.Equal(t, []openai.ChatMessagePart{
+		{Type: openai.ChatMessagePartTypeText, Text: "Look at "},
+		{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: testImageDataURI}},
+		{Type: openai.ChatMessagePartTypeText, Text: " in English."},
+	}, parts)
+	assert.Nil(t, renderMultiContent("{{#if other}}{{photo}}{{/if}}", variables, mediaTypes))
+
+	// the texts which are not templates fall back to the placeholders
+	assert.Equal(t, "Hi John {{#if", renderPromptText("Hi {{name}} {{#if", map[string]string{"name": "John"}))
+	assert.Equal(t, "Reply as {{name extra}} to John", renderPromptText("Reply as {{name extra}} to {{name}}", map[string]string{"name": "John"}))
+}
+
+func TestTemplateAppendedRows(t *testing.T) {
+	variables := map[string]string{"name": "John", "admin": "true", "photo": testImageDataURI}
+	mediaTypes := map[string]schema.PromptVariableTypes{"photo": schema.PromptVariableTypesImage}
+
+	row := schema.PromptRow{Role: "user", Prompt: "{{#if admin}}{{name}}{{/if}}"}
+	assert.Equal(t, "John", promptRowMessage(row, variables, mediaTypes).Content)
+
+	// the messages of a run are not templates, only their placeholders are replaced
+	row.Appended = true
+	assert.Equal(t, "{{#if admin}}John{{/if}}", promptRowMessage(row, variables, mediaTypes).Content)
+
+	row.Prompt = "{{#if admin}} see {{photo}}"
+	assert.Equal(t, []openai.ChatMessagePart{
+		{Type: openai.ChatMessagePartTypeText, Text: "{{#if admin}} see "},
+		{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: testImageDataURI}},
+	}, promptRowMessage(row, variables, mediaTypes).MultiContent)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/PromptPal/PromptPal/ent"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// TokenCounter counts the tokens a chat request takes of the context window
+type TokenCounter interface {
+	CountTokens(model string, messages []openai.ChatCompletionMessage) int
+	// Exact tells if the count matches the tokenizer of the model, not an estimate
+	Exact(model string) bool
+}
+
+var ErrorContextWindowExceeded = errors.New("the prompt exceeds the context window of the model")
+
+var (
+	tokenCountersMu sync.RWMutex
+	tokenCounters   = map[string]TokenCounter{}
+)
+
+func init() {
+	RegisterTokenCounter(defaultProviderSource, tiktokenCounter{})
+	RegisterTokenCounter("azure-openai", tiktokenCounter{})
+	// claude, gemini and ollama models have tokenizers of their own
+	RegisterTokenCounter("claude", estimateTokenCounter{})
+	RegisterTokenCounter("gemini", estimateTokenCounter{})
+	RegisterTokenCounter("ollama", estimateTokenCounter{})
+	RegisterTokenCounter("mock", estimateTokenCounter{})
+}
+
+// RegisterTokenCounter sets the counter of the `Provider.Source`.
+// registering a source twice replaces the previous counter.
+func RegisterTokenCounter(source string, counter TokenCounter) {
+	tokenCountersMu.Lock()
+	defer tokenCountersMu.Unlock()
+	tokenCounters[strings.ToLower(source)] = counter
+}
+
+// GetTokenCounter returns the counter registered for the source, or the
+// tiktoken one if there is none, like the drivers do.
+func GetTokenCounter(source string) TokenCounter {
+	tokenCountersMu.RLock()
+	defer tokenCountersMu.RUnlock()
+	if counter, ok := tokenCounters[strings.ToLower(source)]; ok {
+		return counter
+	}
+	return tokenCounters[defaultProviderSource]
+}
+
+// tiktokenCounter counts like the openai api does, with the BPE encoding of the model.
+// the overhead of the chat format follows the openai cookbook.
+type tiktokenCounter struct {
+}
+
+const (
+	tiktokenTokensPerMessage = 3
+	// every reply is primed with <|start|>assistant<|message|>
+	tiktokenTokensPerReply = 3
+)
+
+type tiktokenModelCodec struct {
+	codec tokenizer.Codec
+	exact bool
+}
+
+var (
+	tiktokenCodecsMu sync.Mutex
+	tiktokenCodecs   = map[string]tiktokenModelCodec{}
+)
+
+// tiktokenCodec returns the BPE codec of the model, unknown models of the
+// openai protocol (deepseek, etc.) are counted with cl100k_base
+func tiktokenCodec(model string) (tokenizer.Codec, bool) {
+	// azure deployments and hosted models may have a prefix before the name of the model
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	tiktokenCodecsMu.Lock()
+	defer tiktokenCodecsMu.Unlock()
+	if c, ok := tiktokenCodecs[name]; ok {
+		return c.codec, c.exact
+	}
+	c := tiktokenModelCodec{exact: true}
+	codec, err := tokenizer.ForModel(tokenizer.Model(name))
+	if err != nil {
+		c.exact = false
+		// cl100k_base is built in, the error can not happen
+		codec, _ = tokenizer.Get(tokenizer.Cl100kBase)
+	}
+	c.codec = codec
+	tiktokenCodecs[name] = c
+	return c.codec, c.exact
+}
+
+func (t tiktokenCounter) countText(codec tokenizer.Codec, text string) int {
+	if text == "" {
+		return 0
+	}
+	count, err := codec.Count(text)
+	if err != nil {
+		return estimateTextTokens(text)
+	}
+	return count
+}
+
+func (t tiktokenCounter) CountTokens(model string, messages []openai.ChatCompletionMessage) int {
+	codec, _ := tiktokenCodec(model)
+	count := tiktokenTokensPerReply
+	for _, msg := range messages {
+		count += tiktokenTokensPerMessage
+		count += t.countText(codec, msg.Role)
+		count += t.countText(codec, messageText(msg))
+		for _, call := range msg.ToolCalls {
+			count += t.countText(codec, call.Function.Name)
+			count += t.countText(codec, call.Function.Arguments)
+		}
+	}
+	return count
+}
+
+func (t tiktokenCounter) Exact(model string) bool {
+	_, exact := tiktokenCodec(model)
+	return exact
+}
+
+// estimateTokenCounter estimates 4 characters per token, for the models
+// without a public tokenizer
+type estimateTokenCounter struct {
+}
+
+func estimateTextTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+func (e estimateTokenCounter) CountTokens(model string, messages []openai.ChatCompletionMessage) int {
+	count := 0
+	for _, msg := range messages {
+		count += tiktokenTokensPerMessage + estimateTextTokens(messageText(msg))
+		for _, call := range msg.ToolCalls {
+			count += estimateTextTokens(call.Function.Name + call.Function.Arguments)
+		}
+	}
+	return count
+}
+
+func (e estimateTokenCounter) Exact(model string) bool {
+	return false
+}
+
+// the context windows of the known models, matched by the longest prefix
+var modelContextWindows = map[string]int{
+	"gpt-3.5-turbo":     16385,
+	"gpt-35-turbo":      16385,
+	"gpt-4":             8192,
+	"gpt-4-32k":         32768,
+	"gpt-4-turbo":       128000,
+	"gpt-4-1106":        128000,
+	"gpt-4-0125":        128000,
+	"gpt-4o":            128000,
+	"chatgpt-4o":        128000,
+	"gpt-4.1":           1047576,
+	"gpt-5":             400000,
+	"o1":                200000,
+	"o1-mini":           128000,
+	"o1-preview":        128000,
+	"o3":                200000,
+	"o4-mini":           200000,
+	"claude-":           200000,
+	"gemini-1.5-pro":    2097152,
+	"gemini-":           1048576,
+	"gemini-1.0-pro":    32760,
+	"deepseek-chat":     65536,
+	"deepseek-reasoner": 65536,
+}
+
+// ModelContextWindow returns the context window of the model of the provider,
+// 0 if it is unknown. `contextWindow` in `Provider.Config` takes precedence,
+// then `numCtx` of ollama.
+func ModelContextWindow(provider *ent.Provider, model string) int {
+	if window := providerConfigInt(provider.Config, "contextWindow", 0); window > 0 {
+		return window
+	}
+	if strings.EqualFold(provider.Source, "ollama") {
+		return providerConfigInt(provider.Config, "numCtx", 0)
+	}
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	window, matched := 0, 0
+	for prefix, size := range modelContextWindows {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			window, matched = size, len(prefix)
+		}
+	}
+	return window
+}
+
+// CountRequestTokens counts the tokens of the messages of the request with the counter of the provider
+func CountRequestTokens(provider *ent.Provider, req openai.ChatCompletionRequest) int {
+	return GetTokenCounter(provider.Source).CountTokens(req.Model, req.Messages)
+}
+
+// checkContextWindow fails if the messages and the tokens reserved for the
+// reply do not fit in the context window of the model
+func checkContextWindow(provider *ent.Provider, req openai.ChatCompletionRequest) error {
+	window := ModelContextWindow(provider, req.Model)
+	if window <= 0 {
+		return nil
+	}
+	count := CountRequestTokens(provider, req)
+	if count+req.MaxTokens > window {
+		return fmt.Errorf("%w: %d tokens and %d for the reply, %s takes %d", ErrorContextWindowExceeded, count, req.MaxTokens, req.Model, window)
+	}
+	return nil
+}
+
+// TokenEstimate is the token count of a prompt on a provider
+type TokenEstimate struct {
+	Count         int
+	Model         string
+	ContextWindow int
+	Exact         bool
+}
+
+// EstimatePromptTokens counts the tokens the rows of the prompt take on the provider.
+// the placeholders without a value are counted as they are.
+func EstimatePromptTokens(provider *ent.Provider, prompt ent.Prompt, variables map[string]string) TokenEstimate {
+	req := isomorphicAIService{}.buildChatRequest(provider, prompt, variables, "")
+	counter := GetTokenCounter(provider.Source)
+	return TokenEstimate{
+		Count:         counter.CountTokens(req.Model, req.Messages),
+		Model:         req.Model,
+		ContextWindow: ModelContextWindow(provider, req.Model),
+		Exact:         counter.Exact(req.Model),
+	}
+}
+
+// CountPromptTokens counts the tokens of the rows of the prompt on the provider it runs on
+func CountPromptTokens(ctx context.Context, prompt ent.Prompt) (int, error) {
+	provider, err := isomorphicAIService{}.GetProvider(ctx, prompt)
+	if err != nil {
+		return 0, err
+	}
+	return EstimatePromptTokens(provider, prompt, nil).Count, nil
+}
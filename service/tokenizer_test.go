@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PromptPal/PromptPal/ent"
+	"github.com/PromptPal/PromptPal/ent/schema"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixedTokenCounter struct {
+	count int
+}
+
+func (f fixedTokenCounter) CountTokens(model string, messages []openai.ChatCompletionMessage) int {
+	return f.count
+}
+
+func (f fixedTokenCounter) Exact(model string) bool {
+	return true
+}
+
+func TestTokenizerCodecs(t *testing.T) {
+	cases := map[string]string{
+		"gpt-3.5-turbo":        "cl100k_base",
+		"gpt-4":                "cl100k_base",
+		"gpt-4o":               "o200k_base",
+		"gpt-4o-mini":          "o200k_base",
+		"GPT-4.1":              "o200k_base",
+		"openai/gpt-4o":        "o200k_base",
+		"deepseek-chat":        "cl100k_base",
+		"some-unknown-model-1": "cl100k_base",
+	}
+	for model, name := range cases {
+		codec, _ := tiktokenCodec(model)
+		assert.Equal(t, name, codec.GetName(), model)
+	}
+	_, exact := tiktokenCodec("gpt-4o")
+	assert.True(t, exact)
+	_, exact = tiktokenCodec("deepseek-chat")
+	assert.False(t, exact)
+}
+
+func TestTokenizerCountTokens(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "hello world"},
+	}
+	// 3 for the reply, 3 for the message, 1 for the role and 2 for the content
+	assert.Equal(t, 9, tiktokenCounter{}.CountTokens("gpt-3.5-turbo", messages))
+	assert.Equal(t, 3, tiktokenCounter{}.CountTokens("gpt-4o", nil))
+
+	// the text parts of a multi content message are counted
+	multi := []openai.ChatCompletionMessage{{
+		Role: openai.ChatMessageRoleUser,
+		MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: "hello world"},
+			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: testImageDataURI}},
+		},
+	}}
+	assert.Equal(t, 9, tiktokenCounter{}.CountTokens("gpt-3.5-turbo", multi))
+
+	calls := []openai.ChatCompletionMessage{{
+		Role:      openai.ChatMessageRoleAssistant,
+		ToolCalls: toOpenAIToolCalls([]schema.ToolCall{{ID: "call_1", Name: "weather", Arguments: `{}`}}),
+	}}
+	assert.Greater(t, tiktokenCounter{}.CountTokens("gpt-4o", calls), 7)
+
+	assert.Equal(t, 3+3, estimateTokenCounter{}.CountTokens("claude-3-5-sonnet", messages))
+	assert.False(t, estimateTokenCounter{}.Exact("claude-3-5-sonnet"))
+}
+
+func TestTokenizerRegister(t *testing.T) {
+	RegisterTokenCounter("Test-Tokens", fixedTokenCounter{count: 42})
+	defer RegisterTokenCounter("test-tokens", estimateTokenCounter{})
+
+	assert.Equal(t, fixedTokenCounter{count: 42}, GetTokenCounter("test-tokens"))
+	assert.Equal(t, estimateTokenCounter{}, GetTokenCounter("claude"))
+	// the sources without a counter speak the openai protocol
+	assert.Equal(t, tiktokenCounter{}, GetTokenCounter("deepseek"))
+}
+
+func TestTokenizerContextWindow(t *testing.T) {
+	provider := &ent.Provider{Source: "openai"}
+	assert.Equal(t, 8192, ModelContextWindow(provider, "gpt-4"))
+	assert.Equal(t, 128000, ModelContextWindow(provider, "gpt-4-turbo-2024-04-09"))
+	assert.Equal(t, 128000, ModelContextWindow(provider, "gpt-4o-mini"))
+	assert.Equal(t, 16385, ModelContextWindow(provider, "gpt-3.5-turbo-0125"))
+	assert.Equal(t, 200000, ModelContextWindow(provider, "claude-3-5-sonnet-latest"))
+	assert.Equal(t, 0, ModelContextWindow(provider, "my-finetune"))
+
+	provider.Config = map[string]interface{}{"contextWindow": float64(4096)}
+	assert.Equal(t, 4096, ModelContextWindow(provider, "gpt-4o"))
+
+	assert.Equal(t, 8192, ModelContextWindow(newOllamaTestProvider(""), "llama3.2"))
+	assert.Equal(t, 0, ModelContextWindow(&ent.Provider{Source: "ollama"}, "llama3.2"))
+}
+
+func TestTokenizerEstimatePromptTokens(t *testing.T) {
+	provider := &ent.Provider{Source: "openai", DefaultModel: "gpt-4o"}
+	prompt := ent.Prompt{Prompts: []schema.PromptRow{{Role: "user", Prompt: "hello {{name}}"}}}
+
+	result := EstimatePromptTokens(provider, prompt, map[string]string{"name": "world"})
+	assert.Equal(t, TokenEstimate{Count: 9, Model: "gpt-4o", ContextWindow: 128000, Exact: true}, result)
+
+	// the placeholder is counted as it is without a value
+	assert.Greater(t, EstimatePromptTokens(provider, prompt, nil).Count, result.Count)
+}
+
+func TestTokenizerRejectsContextWindow(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		writeOpenAITestReply(w)
+	}))
+	defer server.Close()
+
+	provider := &ent.Provider{
+		Source:       "openai",
+		Endpoint:     server.URL + "/v1",
+		DefaultModel: "gpt-4o",
+		MaxTokens:    16,
+		Config:       map[string]interface{}{"contextWindow": float64(32)},
+	}
+	prompt := ent.Prompt{Prompts: []schema.PromptRow{{Role: "user", Prompt: "{{text}}"}}}
+
+	_, err := NewIsomorphicAIService().Chat(context.Background(), provider, prompt, map[string]string{"text": "hello"}, "")
+	assert.Nil(t, err)
+	assert.True(t, called)
+
+	called = false
+	long := map[string]string{"text": strings.Repeat("hello ", 20)}
+	_, err = NewIsomorphicAIService().Chat(context.Background(), provider, prompt, long, "")
+	assert.True(t, errors.Is(err, ErrorContextWindowExceeded))
+	assert.ErrorContains(t, err, "and 16 for the reply, gpt-4o takes 32")
+	assert.False(t, called)
+
+	_, err = NewIsomorphicAIService().ChatStream(context.Background(), provider, prompt, long, "")
+	assert.True(t, errors.Is(err, ErrorContextWindowExceeded))
+	assert.False(t, called)
+}
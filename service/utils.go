@@ -4,8 +4,10 @@ import (
 	"regexp"
 )
 
+var placeholderRegexp = regexp.MustCompile(`{{\s*([a-zA-Z][a-zA-Z0-9]*)\s*}}`)
+
 func replacePlaceholdersLegacy(sentence string, replacements map[string]string) string {
-	re := regexp.MustCompile(`{{\s*([a-zA-Z][a-zA-Z0-9]*)\s*}}`)
+	re := placeholderRegexp
 	result := re.ReplaceAllStringFunc(sentence, func(match string) string {
 		placeholder := re.FindStringSubmatch(match)[1]
 		if value, ok := replacements[placeholder]; ok {